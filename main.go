@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/openfaas/faas-netes/k8s"
 	"github.com/openfaas/faas-netes/types"
+	bootstrap "github.com/openfaas/faas-provider"
 	providertypes "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/auth"
 	clientset "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned"
 	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/connector"
 	"github.com/openfaas/openfaas-operator/pkg/controller"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+	"github.com/openfaas/openfaas-operator/pkg/scheduler"
 	"github.com/openfaas/openfaas-operator/pkg/server"
 	"github.com/openfaas/openfaas-operator/pkg/signals"
 	"github.com/openfaas/openfaas-operator/pkg/version"
 
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -33,12 +45,31 @@ var (
 	kubeconfig string
 )
 
+const (
+	defaultAsyncQueueSize   = 1000
+	defaultDeadLetterSize   = 100
+	defaultCallbackTimeout  = time.Second * 30
+	defaultBrokerBufferSize = 1000
+
+	// defaultShutdownDelay is how long the readiness fails before the server stops
+	// accepting connections, it should cover the period of the readiness probe
+	defaultShutdownDelay = time.Second * 5
+	// defaultShutdownGracePeriod bounds the drain of the requests and of the async queue,
+	// it should be shorter than the terminationGracePeriodSeconds of the pod
+	defaultShutdownGracePeriod = time.Second * 20
+)
+
 var pullPolicyOptions = map[string]bool{
 	"Always":       true,
 	"IfNotPresent": true,
 	"Never":        true,
 }
 
+var networkPolicyOptions = map[string]bool{
+	controller.NetworkPolicyOpen:        true,
+	controller.NetworkPolicyGatewayOnly: true,
+}
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
@@ -54,6 +85,10 @@ func main() {
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
+	// the informers and the controllers are stopped last, once the requests and the async queue are drained
+	controllerStopCh := make(chan struct{})
+	// the async workers are stopped when the grace period expires before the queue is flushed
+	asyncStopCh := make(chan struct{})
 
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
@@ -102,10 +137,24 @@ func main() {
 		functionNamespace = namespace
 	}
 
+	ingressNamespace := "openfaas"
+	if namespace, exists := os.LookupEnv("ingress_namespace"); exists {
+		ingressNamespace = namespace
+	}
+
 	if !pullPolicyOptions[config.ImagePullPolicy] {
 		glog.Fatalf("Invalid image_pull_policy configured: %s", config.ImagePullPolicy)
 	}
 
+	networkPolicyMode := controller.NetworkPolicyOpen
+	if mode, exists := os.LookupEnv("network_policy_mode"); exists && len(mode) > 0 {
+		networkPolicyMode = mode
+	}
+
+	if !networkPolicyOptions[networkPolicyMode] {
+		glog.Fatalf("Invalid network_policy_mode configured: %s", networkPolicyMode)
+	}
+
 	// the sync interval does not affect the scale to/from zero feature
 	// auto-scaling is does via the HTTP API that acts on the deployment Spec.Replicas
 	defaultResync := time.Minute * 5
@@ -118,6 +167,13 @@ func main() {
 
 	endpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	functionInformer := faasInformerFactory.Openfaas().V1().Functions()
+
+	// FunctionIngresses live next to the gateway, their Ingresses must be in the same namespace as the gateway service
+	ingressKubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, defaultResync,
+		kubeinformers.WithNamespace(ingressNamespace))
+	ingressFaasInformerFactory := informers.NewSharedInformerFactoryWithOptions(faasClient, defaultResync,
+		informers.WithNamespace(ingressNamespace))
 
 	log.Printf("Waiting for cache sync in main")
 	kubeInformerFactory.WaitForCacheSync(stopCh)
@@ -129,17 +185,206 @@ func main() {
 		kubeInformerFactory,
 		faasInformerFactory,
 		factory,
+		networkPolicyMode,
 	)
 
-	srv := server.New(faasClient, kubeClient, endpointsInformer, deploymentInformer)
+	ingressCtrl := controller.NewFunctionIngressController(
+		kubeClient,
+		faasClient,
+		ingressFaasInformerFactory.Openfaas().V1().FunctionIngresses(),
+		ingressKubeInformerFactory.Networking().V1beta1().Ingresses(),
+	)
 
-	go faasInformerFactory.Start(stopCh)
-	go kubeInformerFactory.Start(stopCh)
+	idlerInterval := durationFromEnv("idler_interval", time.Second*30)
+	autoscalerInterval := durationFromEnv("autoscaler_interval", time.Second*15)
+	stabilizationWindow := durationFromEnv("autoscaler_stabilization_window", time.Minute*5)
+
+	tracker := scaling.NewTracker(clock.RealClock{})
+	idler := scaling.NewIdler(
+		kubeClient,
+		functionNamespace,
+		functionInformer,
+		deploymentInformer,
+		tracker,
+		clock.RealClock{},
+		idlerInterval,
+	)
+	autoscaler := scaling.NewAutoscaler(
+		kubeClient,
+		functionNamespace,
+		functionInformer,
+		deploymentInformer,
+		tracker,
+		clock.RealClock{},
+		autoscalerInterval,
+		stabilizationWindow,
+	)
+
+	asyncQueue, err := newAsyncQueue()
+	if err != nil {
+		glog.Fatalf("Error creating async queue: %s", err.Error())
+	}
+	deadLetters := queue.NewDeadLetters(defaultDeadLetterSize)
+
+	// the functions invoked by the operator itself are not authenticated again
+	invoker := auth.Internal(bootstrap.Router())
+
+	broker := connector.NewMemoryBroker(defaultBrokerBufferSize, time.Second)
+	dispatcher := connector.NewDispatcher(broker, functionInformer, invoker, deadLetters,
+		intFromEnv("connector_max_inflight", 10), intFromEnv("connector_max_attempts", 5))
+
+	srv := server.New(faasClient, kubeClient, endpointsInformer, deploymentInformer, functionInformer, tracker, asyncQueue, deadLetters, broker, factory)
+
+	asyncWorker := queue.NewWorker(asyncQueue,
+		invoker,
+		&http.Client{Timeout: defaultCallbackTimeout},
+		deadLetters,
+		wait.Backoff{
+			Duration: durationFromEnv("async_retry_delay", time.Second),
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    intFromEnv("async_max_attempts", 5),
+		},
+	)
+
+	go faasInformerFactory.Start(controllerStopCh)
+	go kubeInformerFactory.Start(controllerStopCh)
+	go ingressFaasInformerFactory.Start(controllerStopCh)
+	go ingressKubeInformerFactory.Start(controllerStopCh)
+
+	functionScheduler := scheduler.NewScheduler(
+		faasClient,
+		functionNamespace,
+		functionInformer,
+		invoker,
+		clock.RealClock{},
+		time.Second,
+		durationFromEnv("schedule_missed_deadline", time.Minute*10),
+	)
 
 	go srv.Start()
-	if err = ctrl.Run(1, stopCh); err != nil {
-		glog.Fatalf("Error running controller: %s", err.Error())
+
+	asyncDone := make(chan struct{})
+	go func() {
+		asyncWorker.Run(intFromEnv("async_workers", 10), asyncStopCh)
+		close(asyncDone)
+	}()
+	go func() {
+		if err := idler.Run(stopCh); err != nil {
+			glog.Errorf("Error running idler: %s", err.Error())
+		}
+	}()
+	go func() {
+		if err := autoscaler.Run(stopCh); err != nil {
+			glog.Errorf("Error running autoscaler: %s", err.Error())
+		}
+	}()
+
+	go func() {
+		if err := dispatcher.Run(stopCh); err != nil {
+			glog.Errorf("Error running connector dispatcher: %s", err.Error())
+		}
+	}()
+	go func() {
+		if err := functionScheduler.Run(stopCh); err != nil {
+			glog.Errorf("Error running scheduler: %s", err.Error())
+		}
+	}()
+
+	controllersDone := make(chan struct{}, 2)
+	go func() {
+		if err := ingressCtrl.Run(1, controllerStopCh); err != nil {
+			glog.Errorf("Error running FunctionIngress controller: %s", err.Error())
+		}
+		controllersDone <- struct{}{}
+	}()
+	go func() {
+		if err := ctrl.Run(1, controllerStopCh); err != nil {
+			glog.Fatalf("Error running controller: %s", err.Error())
+		}
+		controllersDone <- struct{}{}
+	}()
+
+	<-stopCh
+
+	shutdownDelay := durationFromEnv("shutdown_delay", defaultShutdownDelay)
+	gracePeriod := durationFromEnv("shutdown_grace_period", defaultShutdownGracePeriod)
+	glog.Infof("Shutting down, grace period: %s", gracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownDelay+gracePeriod)
+	defer cancel()
+
+	// readiness fails first so that the Services stop routing to the operator,
+	// then the server stops accepting connections and drains the inflight requests
+	if err := srv.Shutdown(ctx, shutdownDelay); err != nil {
+		glog.Errorf("Error draining the HTTP server: %s", err.Error())
+	}
+
+	// the async requests accepted before the shutdown are processed until the grace period expires
+	if err := asyncQueue.Close(ctx); err != nil {
+		glog.Errorf("Error closing the async queue: %s", err.Error())
+	}
+	select {
+	case <-asyncDone:
+		glog.Info("Async queue flushed")
+	case <-ctx.Done():
+		glog.Warningf("Async queue not flushed within the grace period, stopping the async workers")
+		close(asyncStopCh)
+	}
+
+	close(controllerStopCh)
+	<-controllersDone
+	<-controllersDone
+	glog.Info("Shutdown complete")
+}
+
+// durationFromEnv parses a duration such as 30s from the environment variable
+// or returns the default value when the variable is not set or invalid
+func durationFromEnv(name string, defaultValue time.Duration) time.Duration {
+	if val, exists := os.LookupEnv(name); exists {
+		if duration, err := time.ParseDuration(val); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// intFromEnv parses a positive integer from the environment variable
+// or returns the default value when the variable is not set or invalid
+func intFromEnv(name string, defaultValue int) int {
+	if val, exists := os.LookupEnv(name); exists {
+		if parsedVal, err := strconv.Atoi(val); err == nil && parsedVal > 0 {
+			return parsedVal
+		}
 	}
+	return defaultValue
+}
+
+// newAsyncQueue creates the queue of async invocations configured by async_queue,
+// either memory (default) or nats
+func newAsyncQueue() (queue.Queue, error) {
+	mode := "memory"
+	if val, exists := os.LookupEnv("async_queue"); exists && len(val) > 0 {
+		mode = val
+	}
+
+	switch mode {
+	case "memory":
+		return queue.NewMemoryQueue(intFromEnv("async_queue_size", defaultAsyncQueueSize)), nil
+	case "nats":
+		address := "nats://nats.openfaas:4222"
+		if val, exists := os.LookupEnv("nats_address"); exists && len(val) > 0 {
+			address = val
+		}
+		subject := queue.DefaultNATSSubject
+		if val, exists := os.LookupEnv("nats_subject"); exists && len(val) > 0 {
+			subject = val
+		}
+		glog.Infof("Using NATS queue %s on %s", subject, address)
+		return queue.NewNATSQueue(address, subject)
+	}
+
+	return nil, fmt.Errorf("invalid async_queue configured: %s", mode)
 }
 
 func setupLogging() {
@@ -2,10 +2,10 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // Function describes an OpenFaaS function
@@ -14,6 +14,8 @@ type Function struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec FunctionSpec `json:"spec"`
+	// +optional
+	Status FunctionStatus `json:"status,omitempty"`
 }
 
 // FunctionSpec is the spec for a Function resource
@@ -38,6 +40,16 @@ type FunctionSpec struct {
 	Requests *FunctionResources `json:"requests,omitempty"`
 	// +optional
 	ReadOnlyRootFilesystem bool `json:"readOnlyRootFilesystem"`
+	// +optional
+	Autoscaling *FunctionAutoscaling `json:"autoscaling,omitempty"`
+	// +optional
+	Disruption *FunctionDisruption `json:"disruption,omitempty"`
+	// +optional
+	Network *FunctionNetwork `json:"network,omitempty"`
+	// Mode is either service (default) for a long-running Deployment
+	// or job to run the function to completion as a Kubernetes Job
+	// +optional
+	Mode string `json:"mode,omitempty"`
 }
 
 // FunctionResources is used to set CPU and memory limits and requests
@@ -46,6 +58,57 @@ type FunctionResources struct {
 	CPU    string `json:"cpu,omitempty"`
 }
 
+// FunctionAutoscaling is used to scale a function with a HorizontalPodAutoscaler
+type FunctionAutoscaling struct {
+	// MetricType is the resource used to scale the function, either cpu or memory
+	MetricType string `json:"metricType"`
+	// TargetUtilization is the target average utilization as a percentage of the requests
+	TargetUtilization int32 `json:"targetUtilization"`
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas int32  `json:"maxReplicas"`
+}
+
+// FunctionDisruption is used to limit the number of function pods evicted
+// at the same time with a PodDisruptionBudget, only one field can be set
+type FunctionDisruption struct {
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// FunctionNetwork is used to restrict the traffic of a function with a NetworkPolicy
+type FunctionNetwork struct {
+	// Ingress sets which pods can call the function, either open or gateway.
+	// Defaults to the network policy mode of the operator.
+	// +optional
+	Ingress string `json:"ingress,omitempty"`
+	// Egress restricts the connections made by the function, all
+	// connections are allowed when not set
+	// +optional
+	Egress *FunctionEgress `json:"egress,omitempty"`
+}
+
+// FunctionEgress lists the destinations a function can connect to
+type FunctionEgress struct {
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Namespaces selects the namespaces by label
+	// +optional
+	Namespaces []metav1.LabelSelector `json:"namespaces,omitempty"`
+	// DNS allows name resolution on port 53
+	// +optional
+	DNS bool `json:"dns,omitempty"`
+}
+
+// FunctionStatus is the status of a Function resource
+type FunctionStatus struct {
+	// LastScheduleTime is the time the function was last invoked by its schedule
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // FunctionList is a list of Function resources
@@ -55,3 +118,55 @@ type FunctionList struct {
 
 	Items []Function `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// FunctionIngress describes a custom domain and path for an OpenFaaS function
+type FunctionIngress struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec FunctionIngressSpec `json:"spec"`
+	// +optional
+	Status FunctionIngressStatus `json:"status,omitempty"`
+}
+
+// FunctionIngressSpec is the spec for a FunctionIngress resource
+type FunctionIngressSpec struct {
+	// Host is the domain name of the function e.g. nodeinfo.example.com
+	Host string `json:"host"`
+	// Function is the name of the function exposed on the host
+	Function string `json:"function"`
+	// Path is the path prefix of the function on the host, defaults to /
+	// +optional
+	Path string `json:"path,omitempty"`
+	// IngressClass is the class of the ingress controller, only nginx is supported
+	// +optional
+	IngressClass string `json:"ingressClass,omitempty"`
+	// +optional
+	TLS *FunctionIngressTLS `json:"tls,omitempty"`
+}
+
+// FunctionIngressTLS is used to serve a FunctionIngress over HTTPS
+type FunctionIngressTLS struct {
+	// SecretName is the name of the secret holding the certificate for the host
+	SecretName string `json:"secretName"`
+}
+
+// FunctionIngressStatus is the status of a FunctionIngress resource
+type FunctionIngressStatus struct {
+	// Address is the IP address or host name of the ingress load balancer
+	// +optional
+	Address string `json:"address,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// FunctionIngressList is a list of FunctionIngress resources
+type FunctionIngressList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []FunctionIngress `json:"items"`
+}
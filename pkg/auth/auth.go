@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	providerauth "github.com/openfaas/faas-provider/auth"
+
+	lru "github.com/hashicorp/golang-lru"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// InternalUser is the user of the invocations made by the operator itself,
+	// for example by the async workers, the scheduler and the connectors
+	InternalUser = "system:openfaas-operator"
+
+	// tokenCacheSize is the number of token reviews kept, the tokens are
+	// reviewed again after tokenCacheTTL or when they are evicted
+	tokenCacheSize = 1024
+	tokenCacheTTL  = time.Minute
+	// failedTokenCacheTTL is shorter so that a new token is accepted soon after it is created
+	failedTokenCacheTTL = 10 * time.Second
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request has no
+	// credentials of its kind
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned by an Authenticator when the credentials
+	// of the request are wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// User is the identity of an authenticated request
+type User struct {
+	Name   string
+	UID    string
+	Groups []string
+	Extra  map[string][]string
+}
+
+type userKey struct{}
+
+var internalUser = &User{Name: InternalUser}
+
+// WithUser returns a copy of the context with the user of the request
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user of the request, if it was authenticated
+func UserFrom(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userKey{}).(*User)
+	return user, ok
+}
+
+// Internal marks the requests served by the handler as made by the operator itself so that
+// they are not authenticated again, it wraps the router given to the components which
+// invoke functions in-process. Requests received by the HTTP server can't be marked.
+func Internal(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(WithUser(r.Context(), internalUser)))
+	})
+}
+
+// IsInternal returns true for the user of the requests marked by Internal, authenticated
+// users with the same name are not internal
+func IsInternal(user *User) bool {
+	return user == internalUser
+}
+
+// Authenticator returns the user of a request, ErrNoCredentials when the request
+// has no credentials which the Authenticator can check or ErrInvalidCredentials
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// Authenticators accepts a request when one of the authenticators accepts it
+type Authenticators []Authenticator
+
+// Authenticate returns the user of the first authenticator which accepts the request,
+// the error of the first authenticator which checked the credentials otherwise
+func (a Authenticators) Authenticate(r *http.Request) (*User, error) {
+	result := ErrNoCredentials
+	for _, authenticator := range a {
+		user, err := authenticator.Authenticate(r)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrNoCredentials && result == ErrNoCredentials {
+			result = err
+		}
+	}
+	return nil, result
+}
+
+// BasicAuthenticator checks the basic auth credentials of requests against the credentials
+// mounted from a secret, the files are named basic-auth-user and basic-auth-password as
+// for the other OpenFaaS providers
+type BasicAuthenticator struct {
+	credentials *providerauth.BasicAuthCredentials
+}
+
+// NewBasicAuthenticator reads the credentials from the secret mount path
+func NewBasicAuthenticator(secretMountPath string) (*BasicAuthenticator, error) {
+	reader := providerauth.ReadBasicAuthFromDisk{SecretMountPath: secretMountPath}
+	credentials, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials.User) == 0 || len(credentials.Password) == 0 {
+		return nil, fmt.Errorf("basic auth user and password can't be empty in %s", secretMountPath)
+	}
+	return &BasicAuthenticator{credentials: credentials}, nil
+}
+
+// Authenticate compares the password in constant time
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(a.credentials.User), []byte(user))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(a.credentials.Password), []byte(password))
+	if userMatch&passwordMatch == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Name: user}, nil
+}
+
+// TokenAuthenticator checks bearer tokens, for example the tokens of service accounts,
+// with a TokenReview. The reviews are cached for a short time so that the API server
+// is not called for each request.
+type TokenAuthenticator struct {
+	kube      kubernetes.Interface
+	audiences []string
+	clock     clock.PassiveClock
+	reviews   *lru.Cache
+}
+
+type tokenReview struct {
+	user    *User
+	expires time.Time
+}
+
+// NewTokenAuthenticator creates a TokenAuthenticator, the tokens must be issued for one of the
+// audiences when set, otherwise for the audience of the API server
+func NewTokenAuthenticator(kube kubernetes.Interface, audiences []string, clock clock.PassiveClock) *TokenAuthenticator {
+	reviews, _ := lru.New(tokenCacheSize)
+	return &TokenAuthenticator{
+		kube:      kube,
+		audiences: audiences,
+		clock:     clock,
+		reviews:   reviews,
+	}
+}
+
+// Authenticate returns the user of the token, the error of the TokenReview is
+// returned when the API server can't be reached
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*User, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	if len(token) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	key := sha256.Sum256([]byte(token))
+	now := a.clock.Now()
+	if cached, ok := a.reviews.Get(key); ok {
+		review := cached.(*tokenReview)
+		if now.Before(review.expires) {
+			if review.user == nil {
+				return nil, ErrInvalidCredentials
+			}
+			return review.user, nil
+		}
+	}
+
+	result, err := a.kube.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.audiences,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token review failed: %v", err)
+	}
+
+	status := result.Status
+	if !status.Authenticated || (len(a.audiences) > 0 && !intersects(a.audiences, status.Audiences)) {
+		a.reviews.Add(key, &tokenReview{expires: now.Add(failedTokenCacheTTL)})
+		return nil, ErrInvalidCredentials
+	}
+
+	user := &User{
+		Name:   status.User.Username,
+		UID:    status.User.UID,
+		Groups: status.User.Groups,
+	}
+	if len(status.User.Extra) > 0 {
+		user.Extra = make(map[string][]string, len(status.User.Extra))
+		for name, values := range status.User.Extra {
+			user.Extra[name] = values
+		}
+	}
+	a.reviews.Add(key, &tokenReview{user: user, expires: now.Add(tokenCacheTTL)})
+	return user, nil
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
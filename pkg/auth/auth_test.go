@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newSecretMountPath writes the basic auth secret to a directory which is removed by the returned func
+func newSecretMountPath(t *testing.T, user, password string) (string, func()) {
+	dir, err := ioutil.TempDir("", "basic-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "basic-auth-user"), []byte(user+"\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "basic-auth-password"), []byte(password+"\n"), 0600)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newTokenClientset accepts the token "valid" for the user alice and counts the reviews
+func newTokenClientset(reviews *int, err error) *fake.Clientset {
+	kube := fake.NewSimpleClientset()
+	kube.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*reviews++
+		if err != nil {
+			return true, &authenticationv1.TokenReview{}, err
+		}
+
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				Audiences:     review.Spec.Audiences,
+				User: authenticationv1.UserInfo{
+					Username: "alice",
+					Groups:   []string{"system:authenticated"},
+				},
+			}
+		}
+		return true, review, nil
+	})
+	return kube
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func Test_BasicAuthenticator(t *testing.T) {
+	dir, removeDir := newSecretMountPath(t, "admin", "secret")
+	defer removeDir()
+
+	a, err := NewBasicAuthenticator(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		user     string
+		password string
+		noAuth   bool
+		expected error
+	}{
+		{name: "valid credentials", user: "admin", password: "secret"},
+		{name: "wrong password", user: "admin", password: "guess", expected: ErrInvalidCredentials},
+		{name: "wrong user", user: "root", password: "secret", expected: ErrInvalidCredentials},
+		{name: "no credentials", noAuth: true, expected: ErrNoCredentials},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+			if !tc.noAuth {
+				r.SetBasicAuth(tc.user, tc.password)
+			}
+
+			user, err := a.Authenticate(r)
+			if err != tc.expected {
+				t.Fatalf("expected error %v, got %v", tc.expected, err)
+			}
+			if err == nil && user.Name != "admin" {
+				t.Errorf("expected the user admin, got %+v", user)
+			}
+		})
+	}
+}
+
+func Test_NewBasicAuthenticator_MissingSecret(t *testing.T) {
+	if _, err := NewBasicAuthenticator(filepath.Join(os.TempDir(), "missing-basic-auth")); err == nil {
+		t.Errorf("expected an error when the secret is not mounted")
+	}
+
+	dir, removeDir := newSecretMountPath(t, "admin", "")
+	defer removeDir()
+	if _, err := NewBasicAuthenticator(dir); err == nil {
+		t.Errorf("expected an error for an empty password")
+	}
+}
+
+func Test_TokenAuthenticator(t *testing.T) {
+	reviews := 0
+	fakeClock := clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := NewTokenAuthenticator(newTokenClientset(&reviews, nil), nil, fakeClock)
+
+	user, err := a.Authenticate(bearer("valid"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Name != "alice" || len(user.Groups) != 1 || user.Groups[0] != "system:authenticated" {
+		t.Errorf("expected the user of the token review, got %+v", user)
+	}
+
+	if _, err := a.Authenticate(bearer("invalid")); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	r.SetBasicAuth("admin", "secret")
+	if _, err := a.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("expected ErrNoCredentials for basic auth, got %v", err)
+	}
+
+	// the reviews are cached
+	a.Authenticate(bearer("valid"))
+	a.Authenticate(bearer("invalid"))
+	if reviews != 2 {
+		t.Errorf("expected 2 token reviews, got %d", reviews)
+	}
+
+	fakeClock.Step(tokenCacheTTL)
+	a.Authenticate(bearer("valid"))
+	a.Authenticate(bearer("invalid"))
+	if reviews != 4 {
+		t.Errorf("expected the tokens to be reviewed again after the cache TTL, got %d reviews", reviews)
+	}
+}
+
+func Test_TokenAuthenticator_ReviewError(t *testing.T) {
+	reviews := 0
+	a := NewTokenAuthenticator(newTokenClientset(&reviews, fmt.Errorf("connection refused")), nil, clock.RealClock{})
+
+	_, err := a.Authenticate(bearer("valid"))
+	if err == nil || err == ErrInvalidCredentials || err == ErrNoCredentials {
+		t.Errorf("expected the error of the token review, got %v", err)
+	}
+}
+
+func Test_TokenAuthenticator_Audiences(t *testing.T) {
+	reviews := 0
+	kube := newTokenClientset(&reviews, nil)
+	a := NewTokenAuthenticator(kube, []string{"openfaas"}, clock.RealClock{})
+
+	if _, err := a.Authenticate(bearer("valid")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actions := kube.Actions()
+	review := actions[len(actions)-1].(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+	if len(review.Spec.Audiences) != 1 || review.Spec.Audiences[0] != "openfaas" {
+		t.Errorf("expected the audiences in the token review, got %v", review.Spec.Audiences)
+	}
+}
+
+func Test_Authenticators(t *testing.T) {
+	dir, removeDir := newSecretMountPath(t, "admin", "secret")
+	defer removeDir()
+
+	reviews := 0
+	basic, err := NewBasicAuthenticator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Authenticators{basic, NewTokenAuthenticator(newTokenClientset(&reviews, nil), nil, clock.RealClock{})}
+
+	r := httptest.NewRequest(http.MethodGet, "/system/functions", nil)
+	r.SetBasicAuth("admin", "secret")
+	if user, err := a.Authenticate(r); err != nil || user.Name != "admin" {
+		t.Errorf("expected basic auth to be accepted, got %+v %v", user, err)
+	}
+	if user, err := a.Authenticate(bearer("valid")); err != nil || user.Name != "alice" {
+		t.Errorf("expected the token to be accepted, got %+v %v", user, err)
+	}
+	if _, err := a.Authenticate(bearer("invalid")); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := a.Authenticate(httptest.NewRequest(http.MethodGet, "/system/functions", nil)); err != ErrNoCredentials {
+		t.Errorf("expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func Test_Internal(t *testing.T) {
+	var user *User
+	handler := Internal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = UserFrom(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/nodeinfo", nil))
+	if user == nil || !IsInternal(user) {
+		t.Errorf("expected the internal user, got %+v", user)
+	}
+	if IsInternal(&User{Name: InternalUser}) {
+		t.Errorf("expected a user with the name of the operator not to be internal")
+	}
+}
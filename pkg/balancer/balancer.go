@@ -0,0 +1,282 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	coreinformer "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// StrategyLeastInflight picks the endpoint with the fewest requests in progress
+	StrategyLeastInflight = "least-inflight"
+	// StrategyRoundRobin picks the endpoints in turn
+	StrategyRoundRobin = "round-robin"
+
+	watchdogPort = 8080
+)
+
+var endpointInflightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "operator_endpoint_inflight",
+	Help: "Requests in progress for each endpoint of a function",
+}, []string{"function_name", "endpoint"})
+
+func init() {
+	prometheus.MustRegister(endpointInflightGauge)
+}
+
+// Ejector reports the endpoints of a function which are ejected from load balancing
+type Ejector interface {
+	Ejected(functionName, address string) bool
+}
+
+// Balancer picks a ready endpoint of a function for each request made through
+// the function proxy, instead of leaving the choice to kube-proxy which balances
+// connections rather than requests
+type Balancer struct {
+	namespace       string
+	strategy        string
+	endpointsLister corelisters.EndpointsNamespaceLister
+	endpointsSynced cache.InformerSynced
+	ejector         Ejector
+
+	lock sync.Mutex
+	// inflight is the number of requests in progress for each endpoint of each function
+	inflight map[string]map[string]int
+	// next is the round-robin position of each function
+	next map[string]int
+}
+
+// NewBalancer returns a Balancer which picks endpoints with the strategy
+func NewBalancer(namespace string, endpointsInformer coreinformer.EndpointsInformer, strategy string) (*Balancer, error) {
+	if strategy != StrategyLeastInflight && strategy != StrategyRoundRobin {
+		return nil, fmt.Errorf("load balancing strategy must be %s or %s, got: %s", StrategyLeastInflight, StrategyRoundRobin, strategy)
+	}
+
+	b := &Balancer{
+		namespace:       namespace,
+		strategy:        strategy,
+		endpointsLister: endpointsInformer.Lister().Endpoints(namespace),
+		endpointsSynced: endpointsInformer.Informer().HasSynced,
+		inflight:        map[string]map[string]int{},
+		next:            map[string]int{},
+	}
+
+	endpointsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if endpoints, ok := new.(*corev1.Endpoints); ok {
+				b.prune(endpoints.Name, readyAddresses(endpoints))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if endpoints, ok := obj.(*corev1.Endpoints); ok {
+				b.prune(endpoints.Name, nil)
+			}
+		},
+	})
+
+	return b, nil
+}
+
+// SetEjector skips the endpoints ejected by the ejector, unless all the ready
+// endpoints of the function are ejected
+func (b *Balancer) SetEjector(ejector Ejector) {
+	b.ejector = ejector
+}
+
+// Resolve returns the URL of an endpoint of the function, done must be called once
+// the request to the endpoint has completed. The Service address is returned when
+// the endpoints cache has not synced yet.
+func (b *Balancer) Resolve(functionName string) (url.URL, func(), error) {
+	name := strings.TrimSuffix(functionName, "."+b.namespace)
+
+	if !b.endpointsSynced() {
+		glog.V(2).Infof("Endpoints cache not synced, using the service address of %s", name)
+		return url.URL{
+			Scheme: "http",
+			Host:   net.JoinHostPort(fmt.Sprintf("%s.%s", name, b.namespace), strconv.Itoa(watchdogPort)),
+		}, func() {}, nil
+	}
+
+	endpoints, err := b.endpointsLister.Get(name)
+	if err != nil {
+		return url.URL{}, nil, fmt.Errorf("error listing %s.%s %s", name, b.namespace, err.Error())
+	}
+
+	addresses := readyAddresses(endpoints)
+	if len(addresses) == 0 {
+		return url.URL{}, nil, fmt.Errorf("no ready endpoints for %s.%s", name, b.namespace)
+	}
+
+	address := b.pick(name, b.healthy(name, addresses))
+
+	once := sync.Once{}
+	done := func() {
+		once.Do(func() { b.release(name, address) })
+	}
+	return url.URL{Scheme: "http", Host: address}, done, nil
+}
+
+// healthy returns the addresses which are not ejected, all the addresses
+// are returned when they are all ejected
+func (b *Balancer) healthy(functionName string, addresses []string) []string {
+	if b.ejector == nil {
+		return addresses
+	}
+
+	healthy := []string{}
+	for _, address := range addresses {
+		if !b.ejector.Ejected(functionName, address) {
+			healthy = append(healthy, address)
+		}
+	}
+	if len(healthy) == 0 {
+		return addresses
+	}
+	return healthy
+}
+
+// pick selects an address and records a request in progress for it
+func (b *Balancer) pick(functionName string, addresses []string) string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	inflight, ok := b.inflight[functionName]
+	if !ok {
+		inflight = map[string]int{}
+		b.inflight[functionName] = inflight
+	}
+
+	// the round-robin position is also the starting point of least-inflight
+	// so that idle endpoints share the requests
+	start := b.next[functionName] % len(addresses)
+	b.next[functionName] = start + 1
+
+	address := addresses[start]
+	if b.strategy == StrategyLeastInflight {
+		for i := 1; i < len(addresses); i++ {
+			candidate := addresses[(start+i)%len(addresses)]
+			if inflight[candidate] < inflight[address] {
+				address = candidate
+			}
+		}
+	}
+
+	inflight[address]++
+	endpointInflightGauge.WithLabelValues(functionName, address).Set(float64(inflight[address]))
+	return address
+}
+
+func (b *Balancer) release(functionName, address string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	inflight, ok := b.inflight[functionName]
+	if !ok || inflight[address] == 0 {
+		return
+	}
+
+	inflight[address]--
+	if inflight[address] == 0 && !b.isReady(functionName, address) {
+		delete(inflight, address)
+		endpointInflightGauge.DeleteLabelValues(functionName, address)
+		return
+	}
+	endpointInflightGauge.WithLabelValues(functionName, address).Set(float64(inflight[address]))
+}
+
+func (b *Balancer) isReady(functionName, address string) bool {
+	endpoints, err := b.endpointsLister.Get(functionName)
+	if err != nil {
+		return false
+	}
+
+	for _, ready := range readyAddresses(endpoints) {
+		if ready == address {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadyReplicas returns the number of ready endpoints of a function, or zero
+// when the endpoints cache has not synced yet
+func (b *Balancer) ReadyReplicas(functionName string) int {
+	if !b.endpointsSynced() {
+		return 0
+	}
+
+	endpoints, err := b.endpointsLister.Get(strings.TrimSuffix(functionName, "."+b.namespace))
+	if err != nil {
+		return 0
+	}
+	return len(readyAddresses(endpoints))
+}
+
+// Inflight returns the number of requests in progress for each endpoint of a function
+func (b *Balancer) Inflight(functionName string) map[string]int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	inflight := map[string]int{}
+	for address, count := range b.inflight[functionName] {
+		inflight[address] = count
+	}
+	return inflight
+}
+
+// prune removes the idle endpoints which are no longer ready along with their metrics
+func (b *Balancer) prune(functionName string, addresses []string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ready := map[string]bool{}
+	for _, address := range addresses {
+		ready[address] = true
+	}
+
+	inflight := b.inflight[functionName]
+	for address, count := range inflight {
+		if ready[address] || count > 0 {
+			continue
+		}
+		delete(inflight, address)
+		endpointInflightGauge.DeleteLabelValues(functionName, address)
+	}
+
+	if len(inflight) == 0 {
+		delete(b.inflight, functionName)
+	}
+	if len(addresses) == 0 {
+		delete(b.next, functionName)
+	}
+}
+
+// readyAddresses returns the host:port of the ready addresses of the endpoints,
+// addresses which are not ready are listed apart by Kubernetes and are skipped
+func readyAddresses(endpoints *corev1.Endpoints) []string {
+	addresses := []string{}
+	for _, subset := range endpoints.Subsets {
+		port := int32(watchdogPort)
+		if len(subset.Ports) > 0 {
+			port = subset.Ports[0].Port
+		}
+
+		for _, address := range subset.Addresses {
+			addresses = append(addresses, net.JoinHostPort(address.IP, strconv.Itoa(int(port))))
+		}
+	}
+	return addresses
+}
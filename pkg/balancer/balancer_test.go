@@ -0,0 +1,231 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformer "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestEndpoints(name string, ready []string, notReady []string) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{
+		Ports: []corev1.EndpointPort{{Port: 8080}},
+	}
+	for _, ip := range ready {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: ip})
+	}
+	for _, ip := range notReady {
+		subset.NotReadyAddresses = append(subset.NotReadyAddresses, corev1.EndpointAddress{IP: ip})
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Subsets: []corev1.EndpointSubset{subset},
+	}
+}
+
+func newTestBalancer(t *testing.T, strategy string, synced bool, endpoints ...*corev1.Endpoints) (*Balancer, coreinformer.EndpointsInformer) {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
+
+	for _, e := range endpoints {
+		endpointsInformer.Informer().GetIndexer().Add(e)
+	}
+
+	b, err := NewBalancer("openfaas-fn", endpointsInformer, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the informer is not started, the cache is filled by the tests
+	b.endpointsSynced = func() bool { return synced }
+	return b, endpointsInformer
+}
+
+func resolve(t *testing.T, b *Balancer, functionName string) (string, func()) {
+	functionURL, done, err := b.Resolve(functionName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return functionURL.Host, done
+}
+
+func Test_Balancer_RoundRobin(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyRoundRobin, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil))
+
+	got := []string{}
+	for i := 0; i < 6; i++ {
+		host, done := resolve(t, b, "nodeinfo")
+		got = append(got, host)
+		done()
+	}
+
+	expected := []string{
+		"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080",
+		"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080",
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected endpoints in turn %v, got %v", expected, got)
+		}
+	}
+}
+
+func Test_Balancer_LeastInflight(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyLeastInflight, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1", "10.0.0.2"}, nil))
+
+	// a long request keeps the first endpoint busy
+	busy, doneBusy := resolve(t, b, "nodeinfo")
+
+	for i := 0; i < 3; i++ {
+		host, done := resolve(t, b, "nodeinfo")
+		if host == busy {
+			t.Errorf("expected the idle endpoint, got the busy endpoint %s", host)
+		}
+		done()
+	}
+
+	doneBusy()
+	if inflight := b.Inflight("nodeinfo"); inflight[busy] != 0 {
+		t.Errorf("expected no requests in progress after done, got %v", inflight)
+	}
+}
+
+func Test_Balancer_SkipsNotReadyAddresses(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyRoundRobin, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1"}, []string{"10.0.0.2"}))
+
+	for i := 0; i < 3; i++ {
+		host, done := resolve(t, b, "nodeinfo")
+		if host != "10.0.0.1:8080" {
+			t.Errorf("expected the ready endpoint, got %s", host)
+		}
+		done()
+	}
+}
+
+func Test_Balancer_NoReadyEndpoints(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyLeastInflight, true,
+		newTestEndpoints("nodeinfo", nil, []string{"10.0.0.2"}))
+
+	if _, _, err := b.Resolve("nodeinfo"); err == nil {
+		t.Errorf("expected an error without ready endpoints")
+	}
+	if _, _, err := b.Resolve("unknown"); err == nil {
+		t.Errorf("expected an error for a function without endpoints")
+	}
+}
+
+func Test_Balancer_FallsBackToServiceWhenNotSynced(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyLeastInflight, false)
+
+	host, done := resolve(t, b, "nodeinfo")
+	defer done()
+
+	if host != "nodeinfo.openfaas-fn:8080" {
+		t.Errorf("expected the service address, got %s", host)
+	}
+}
+
+func Test_Balancer_NamespaceSuffix(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyLeastInflight, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1"}, nil))
+
+	host, done := resolve(t, b, "nodeinfo.openfaas-fn")
+	defer done()
+
+	if host != "10.0.0.1:8080" {
+		t.Errorf("expected the endpoint of nodeinfo, got %s", host)
+	}
+}
+
+func Test_Balancer_InflightMetrics(t *testing.T) {
+	b, endpointsInformer := newTestBalancer(t, StrategyLeastInflight, true,
+		newTestEndpoints("metrics", []string{"10.0.0.1"}, nil))
+
+	host, done := resolve(t, b, "metrics")
+	if got := testutil.ToFloat64(endpointInflightGauge.WithLabelValues("metrics", host)); got != 1 {
+		t.Errorf("expected 1 request in progress, got %v", got)
+	}
+
+	// the endpoint is removed while the request is in progress
+	updated := newTestEndpoints("metrics", []string{"10.0.0.2"}, nil)
+	endpointsInformer.Informer().GetIndexer().Update(updated)
+	b.prune("metrics", readyAddresses(updated))
+
+	if inflight := b.Inflight("metrics"); inflight[host] != 1 {
+		t.Errorf("expected the busy endpoint to be kept until done, got %v", inflight)
+	}
+
+	done()
+	if inflight := b.Inflight("metrics"); len(inflight) != 0 {
+		t.Errorf("expected the removed endpoint to be forgotten after done, got %v", inflight)
+	}
+}
+
+func Test_Balancer_ReadyReplicas(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyLeastInflight, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.3"}))
+
+	if got := b.ReadyReplicas("nodeinfo"); got != 2 {
+		t.Errorf("expected 2 ready replicas, got %d", got)
+	}
+	if got := b.ReadyReplicas("unknown"); got != 0 {
+		t.Errorf("expected no ready replicas for a function without endpoints, got %d", got)
+	}
+
+	b, _ = newTestBalancer(t, StrategyLeastInflight, false)
+	if got := b.ReadyReplicas("nodeinfo"); got != 0 {
+		t.Errorf("expected no ready replicas before the cache has synced, got %d", got)
+	}
+}
+
+// testEjector ejects the listed addresses
+type testEjector map[string]bool
+
+func (e testEjector) Ejected(functionName, address string) bool {
+	return e[address]
+}
+
+func Test_Balancer_SkipsEjectedEndpoints(t *testing.T) {
+	b, _ := newTestBalancer(t, StrategyRoundRobin, true,
+		newTestEndpoints("nodeinfo", []string{"10.0.0.1", "10.0.0.2"}, nil))
+	b.SetEjector(testEjector{"10.0.0.1:8080": true})
+
+	for i := 0; i < 3; i++ {
+		host, done := resolve(t, b, "nodeinfo")
+		if host != "10.0.0.2:8080" {
+			t.Errorf("expected the endpoint which is not ejected, got %s", host)
+		}
+		done()
+	}
+
+	// all the endpoints are used when they are all ejected
+	b.SetEjector(testEjector{"10.0.0.1:8080": true, "10.0.0.2:8080": true})
+	hosts := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		host, done := resolve(t, b, "nodeinfo")
+		hosts[host] = true
+		done()
+	}
+	if len(hosts) != 2 {
+		t.Errorf("expected both endpoints when all are ejected, got %v", hosts)
+	}
+}
+
+func Test_NewBalancer_InvalidStrategy(t *testing.T) {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+
+	if _, err := NewBalancer("openfaas-fn", kubeInformerFactory.Core().V1().Endpoints(), "random"); err == nil {
+		t.Errorf("expected an error for an unknown strategy")
+	}
+}
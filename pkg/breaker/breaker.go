@@ -0,0 +1,500 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationErrorRatio is the function annotation that opens the circuit when the ratio
+	// of failed requests in the window reaches the value, for example 0.5
+	AnnotationErrorRatio = "com.openfaas.circuit-breaker.error-ratio"
+	// AnnotationConsecutiveFailures is the function annotation that opens the circuit
+	// after the number of failed requests in a row
+	AnnotationConsecutiveFailures = "com.openfaas.circuit-breaker.consecutive-failures"
+	// AnnotationMinRequests is the function annotation that sets the number of requests
+	// in the window before the error ratio is checked
+	AnnotationMinRequests = "com.openfaas.circuit-breaker.min-requests"
+	// AnnotationWindow is the function annotation that sets the duration over which the
+	// error ratio is measured
+	AnnotationWindow = "com.openfaas.circuit-breaker.window"
+	// AnnotationOpenDuration is the function annotation that sets how long the circuit stays
+	// open, or an endpoint ejected, before a request is let through to probe the function
+	AnnotationOpenDuration = "com.openfaas.circuit-breaker.open-duration"
+
+	// StateClosed lets the requests through
+	StateClosed = "closed"
+	// StateOpen rejects the requests
+	StateOpen = "open"
+	// StateHalfOpen lets a single request through to probe the function
+	StateHalfOpen = "half-open"
+
+	// ReasonCircuitOpen is the reason of the event recorded when the circuit opens
+	ReasonCircuitOpen = "CircuitOpen"
+	// ReasonCircuitHalfOpen is the reason of the event recorded when the circuit probes the function
+	ReasonCircuitHalfOpen = "CircuitHalfOpen"
+	// ReasonCircuitClosed is the reason of the event recorded when the function has recovered
+	ReasonCircuitClosed = "CircuitClosed"
+	// ReasonEndpointEjected is the reason of the event recorded when an endpoint is ejected
+	ReasonEndpointEjected = "EndpointEjected"
+	// ReasonEndpointRestored is the reason of the event recorded when an ejected endpoint has recovered
+	ReasonEndpointRestored = "EndpointRestored"
+
+	defaultMinRequests  = 10
+	defaultWindow       = 10 * time.Second
+	defaultOpenDuration = 30 * time.Second
+
+	// pruneInterval is how often the circuits of idle endpoints are removed
+	pruneInterval = time.Minute
+)
+
+// ErrOpen is returned for the requests of a function with an open circuit
+var ErrOpen = errors.New("circuit breaker is open")
+
+var (
+	circuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_circuit_breaker_state",
+		Help: "Circuit breaker state of a function, 0 closed, 1 half-open and 2 open",
+	}, []string{"function_name"})
+
+	circuitTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_circuit_breaker_transitions_total",
+		Help: "Circuit breaker state changes of a function by new state",
+	}, []string{"function_name", "state"})
+
+	circuitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_circuit_breaker_rejected_total",
+		Help: "Requests rejected because the circuit of a function was open",
+	}, []string{"function_name"})
+
+	endpointEjected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_endpoint_ejected",
+		Help: "Endpoints of a function ejected from load balancing, 1 when ejected",
+	}, []string{"function_name", "endpoint"})
+
+	endpointEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_endpoint_ejections_total",
+		Help: "Ejections of the endpoints of a function",
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(circuitState, circuitTransitions, circuitRejected, endpointEjected, endpointEjections)
+}
+
+var stateValues = map[string]float64{StateClosed: 0, StateHalfOpen: 1, StateOpen: 2}
+
+// Policy is the circuit breaker configuration of a function
+type Policy struct {
+	// ErrorRatio opens the circuit when reached by the failed requests in the window, zero disables it
+	ErrorRatio float64
+	// ConsecutiveFailures opens the circuit after the failed requests in a row, zero disables it
+	ConsecutiveFailures int
+	MinRequests         int
+	Window              time.Duration
+	OpenDuration        time.Duration
+}
+
+// GetPolicy reads the circuit breaker from the function annotations, nil is returned
+// for functions without an error ratio or consecutive failures
+func GetPolicy(function *faasv1.Function) (*Policy, error) {
+	if function.Spec.Annotations == nil {
+		return nil, nil
+	}
+	annotations := *function.Spec.Annotations
+
+	policy := &Policy{
+		MinRequests:  defaultMinRequests,
+		Window:       defaultWindow,
+		OpenDuration: defaultOpenDuration,
+	}
+
+	if value, ok := annotations[AnnotationErrorRatio]; ok && len(value) > 0 {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio <= 0 || ratio > 1 {
+			return nil, fmt.Errorf("%s must be a ratio greater than 0 and up to 1", AnnotationErrorRatio)
+		}
+		policy.ErrorRatio = ratio
+	}
+
+	if value, ok := annotations[AnnotationConsecutiveFailures]; ok && len(value) > 0 {
+		failures, err := strconv.Atoi(value)
+		if err != nil || failures < 1 {
+			return nil, fmt.Errorf("%s must be a number greater than zero", AnnotationConsecutiveFailures)
+		}
+		policy.ConsecutiveFailures = failures
+	}
+
+	if policy.ErrorRatio == 0 && policy.ConsecutiveFailures == 0 {
+		return nil, nil
+	}
+
+	if value, ok := annotations[AnnotationMinRequests]; ok && len(value) > 0 {
+		minRequests, err := strconv.Atoi(value)
+		if err != nil || minRequests < 1 {
+			return nil, fmt.Errorf("%s must be a number greater than zero", AnnotationMinRequests)
+		}
+		policy.MinRequests = minRequests
+	}
+
+	if value, ok := annotations[AnnotationWindow]; ok && len(value) > 0 {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("%s must be a duration greater than zero", AnnotationWindow)
+		}
+		policy.Window = window
+	}
+
+	if value, ok := annotations[AnnotationOpenDuration]; ok && len(value) > 0 {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("%s must be a duration greater than zero", AnnotationOpenDuration)
+		}
+		policy.OpenDuration = duration
+	}
+
+	return policy, nil
+}
+
+// circuit counts the results of the requests to a function or to an endpoint
+type circuit struct {
+	state string
+	// openUntil is when an open circuit lets a request through to probe
+	openUntil time.Time
+	// probing is set while the request which probes a half-open circuit is in progress,
+	// a new probe is let through after probeUntil when the request never completes
+	probing    bool
+	probeUntil time.Time
+	// probes tells the result of a probe which took too long from the one of the new probe
+	probes int
+
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	lastSeen    time.Time
+}
+
+// record counts the result of a request and returns why the circuit trips, if it does
+func (c *circuit) record(policy Policy, now time.Time, success bool) string {
+	c.lastSeen = now
+	if now.Sub(c.windowStart) >= policy.Window {
+		c.windowStart = now
+		c.requests = 0
+		c.failures = 0
+	}
+
+	c.requests++
+	if success {
+		c.consecutive = 0
+		return ""
+	}
+	c.failures++
+	c.consecutive++
+
+	if policy.ConsecutiveFailures > 0 && c.consecutive >= policy.ConsecutiveFailures {
+		return fmt.Sprintf("%d consecutive failures", c.consecutive)
+	}
+	if policy.ErrorRatio > 0 && c.requests >= policy.MinRequests {
+		if ratio := float64(c.failures) / float64(c.requests); ratio >= policy.ErrorRatio {
+			return fmt.Sprintf("%d of %d requests failed in %s", c.failures, c.requests, policy.Window)
+		}
+	}
+	return ""
+}
+
+// open trips the circuit and resets the counts for when it closes
+func (c *circuit) open(now time.Time, duration time.Duration) {
+	c.state = StateOpen
+	c.openUntil = now.Add(duration)
+	c.probing = false
+	c.reset(now)
+}
+
+func (c *circuit) reset(now time.Time) {
+	c.windowStart = now
+	c.requests = 0
+	c.failures = 0
+	c.consecutive = 0
+}
+
+// Breakers opens the circuit of functions which fail so that their requests are rejected
+// straight away, and ejects the failing endpoints of a function from load balancing. State
+// changes are recorded as events on the Function.
+type Breakers struct {
+	namespace string
+	lister    listers.FunctionNamespaceLister
+	recorder  record.EventRecorder
+	clock     clock.PassiveClock
+
+	lock      sync.Mutex
+	functions map[string]*circuit
+	endpoints map[string]map[string]*circuit
+	lastPrune time.Time
+}
+
+// NewBreakers returns Breakers for the functions of the namespace which read the
+// circuit breaker policies from the annotations of the functions
+func NewBreakers(namespace string, lister listers.FunctionNamespaceLister, recorder record.EventRecorder, clock clock.PassiveClock) *Breakers {
+	return &Breakers{
+		namespace: namespace,
+		lister:    lister,
+		recorder:  recorder,
+		clock:     clock,
+		functions: map[string]*circuit{},
+		endpoints: map[string]map[string]*circuit{},
+		lastPrune: clock.Now(),
+	}
+}
+
+// policy returns the function and its circuit breaker, nil is returned for functions
+// which are not in the cache or don't have a circuit breaker
+func (b *Breakers) policy(functionName string) (*faasv1.Function, *Policy) {
+	function, err := b.lister.Get(functionName)
+	if err != nil {
+		return nil, nil
+	}
+
+	policy, err := GetPolicy(function)
+	if err != nil {
+		glog.Warningf("Function %s circuit breaker error, the circuit breaker is disabled: %v", functionName, err)
+		return nil, nil
+	}
+	return function, policy
+}
+
+// Allow returns ErrOpen when the circuit of the function is open, otherwise done
+// must be called with the result of the request
+func (b *Breakers) Allow(functionName string) (func(success bool), error) {
+	functionName = strings.TrimSuffix(functionName, "."+b.namespace)
+	function, policy := b.policy(functionName)
+	if policy == nil {
+		b.forget(functionName)
+		return func(bool) {}, nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	c, ok := b.functions[functionName]
+	if !ok {
+		c = &circuit{state: StateClosed, windowStart: now}
+		b.functions[functionName] = c
+		circuitState.WithLabelValues(functionName).Set(stateValues[StateClosed])
+	}
+
+	probe := false
+	switch c.state {
+	case StateOpen:
+		if now.Before(c.openUntil) {
+			circuitRejected.WithLabelValues(functionName).Inc()
+			return nil, ErrOpen
+		}
+		b.transition(function, c, StateHalfOpen, fmt.Sprintf("Circuit breaker probing after %s open", policy.OpenDuration))
+		fallthrough
+	case StateHalfOpen:
+		if c.probing && now.Before(c.probeUntil) {
+			circuitRejected.WithLabelValues(functionName).Inc()
+			return nil, ErrOpen
+		}
+		c.probing = true
+		c.probeUntil = now.Add(policy.OpenDuration)
+		c.probes++
+		probe = true
+	}
+
+	probeID := c.probes
+	once := sync.Once{}
+	return func(success bool) {
+		once.Do(func() {
+			b.record(function, *policy, c, probe, probeID, success)
+		})
+	}, nil
+}
+
+func (b *Breakers) record(function *faasv1.Function, policy Policy, c *circuit, probe bool, probeID int, success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	if probe {
+		if c.state != StateHalfOpen || probeID != c.probes {
+			// a new probe was let through after this one took too long
+			return
+		}
+		c.probing = false
+		if success {
+			c.reset(now)
+			b.transition(function, c, StateClosed, "Circuit breaker closed after a successful probe")
+		} else {
+			c.open(now, policy.OpenDuration)
+			b.transition(function, c, StateOpen, "Circuit breaker opened again after a failed probe")
+		}
+		return
+	}
+
+	if c.state != StateClosed {
+		// the request started before the circuit opened
+		return
+	}
+
+	if reason := c.record(policy, now, success); len(reason) > 0 {
+		c.open(now, policy.OpenDuration)
+		b.transition(function, c, StateOpen, fmt.Sprintf("Circuit breaker opened for %s after %s", policy.OpenDuration, reason))
+	}
+}
+
+// transition sets the state of the circuit of a function, the lock must be held
+func (b *Breakers) transition(function *faasv1.Function, c *circuit, state, message string) {
+	c.state = state
+	functionName := function.Spec.Name
+
+	glog.Infof("Function %s: %s", functionName, message)
+	circuitState.WithLabelValues(functionName).Set(stateValues[state])
+	circuitTransitions.WithLabelValues(functionName, state).Inc()
+
+	switch state {
+	case StateOpen:
+		b.recorder.Event(function, corev1.EventTypeWarning, ReasonCircuitOpen, message)
+	case StateHalfOpen:
+		b.recorder.Event(function, corev1.EventTypeNormal, ReasonCircuitHalfOpen, message)
+	case StateClosed:
+		b.recorder.Event(function, corev1.EventTypeNormal, ReasonCircuitClosed, message)
+	}
+}
+
+// forget removes the circuit of a function without a circuit breaker
+func (b *Breakers) forget(functionName string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.functions[functionName]; ok {
+		delete(b.functions, functionName)
+		circuitState.DeleteLabelValues(functionName)
+	}
+	for address := range b.endpoints[functionName] {
+		endpointEjected.DeleteLabelValues(functionName, address)
+	}
+	delete(b.endpoints, functionName)
+}
+
+// Ejected returns true while an endpoint of a function is ejected from load balancing,
+// once the open duration has passed the endpoint gets requests again and is ejected
+// again straight away if the next request fails
+func (b *Breakers) Ejected(functionName, address string) bool {
+	functionName = strings.TrimSuffix(functionName, "."+b.namespace)
+	_, policy := b.policy(functionName)
+	if policy == nil {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c, ok := b.endpoints[functionName][address]
+	if !ok || c.state != StateOpen {
+		return false
+	}
+
+	if b.clock.Now().Before(c.openUntil) {
+		return true
+	}
+	c.state = StateHalfOpen
+	endpointEjected.WithLabelValues(functionName, address).Set(0)
+	return false
+}
+
+// RecordEndpoint counts the result of a request to an endpoint of a function and
+// ejects the endpoint when it trips the circuit breaker of the function
+func (b *Breakers) RecordEndpoint(functionName, address string, success bool) {
+	functionName = strings.TrimSuffix(functionName, "."+b.namespace)
+	function, policy := b.policy(functionName)
+	if policy == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	if now.Sub(b.lastPrune) >= pruneInterval {
+		b.prune(now)
+	}
+
+	endpoints, ok := b.endpoints[functionName]
+	if !ok {
+		endpoints = map[string]*circuit{}
+		b.endpoints[functionName] = endpoints
+	}
+	c, ok := endpoints[address]
+	if !ok {
+		c = &circuit{state: StateClosed, windowStart: now}
+		endpoints[address] = c
+	}
+	c.lastSeen = now
+
+	switch c.state {
+	case StateOpen:
+		// the request started before the endpoint was ejected
+		return
+	case StateHalfOpen:
+		if success {
+			c.state = StateClosed
+			c.reset(now)
+			message := fmt.Sprintf("Endpoint %s restored after a successful request", address)
+			glog.Infof("Function %s: %s", functionName, message)
+			b.recorder.Event(function, corev1.EventTypeNormal, ReasonEndpointRestored, message)
+			return
+		}
+		c.open(now, policy.OpenDuration)
+		b.eject(function, address, "a failed request after it was restored")
+		return
+	}
+
+	if reason := c.record(*policy, now, success); len(reason) > 0 {
+		c.open(now, policy.OpenDuration)
+		b.eject(function, address, reason)
+	}
+}
+
+// eject records the ejection of an endpoint, the lock must be held
+func (b *Breakers) eject(function *faasv1.Function, address, reason string) {
+	functionName := function.Spec.Name
+	message := fmt.Sprintf("Endpoint %s ejected after %s", address, reason)
+
+	glog.Infof("Function %s: %s", functionName, message)
+	endpointEjected.WithLabelValues(functionName, address).Set(1)
+	endpointEjections.WithLabelValues(functionName).Inc()
+	b.recorder.Event(function, corev1.EventTypeWarning, ReasonEndpointEjected, message)
+}
+
+// prune removes the circuits of endpoints which had no requests for a while and are
+// not ejected, such as the endpoints of deleted pods, the lock must be held
+func (b *Breakers) prune(now time.Time) {
+	for functionName, endpoints := range b.endpoints {
+		for address, c := range endpoints {
+			if now.Sub(c.lastSeen) >= pruneInterval && !now.Before(c.openUntil) {
+				delete(endpoints, address)
+				endpointEjected.DeleteLabelValues(functionName, address)
+			}
+		}
+		if len(endpoints) == 0 {
+			delete(b.endpoints, functionName)
+		}
+	}
+	b.lastPrune = now
+}
@@ -0,0 +1,324 @@
+package breaker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestFunction(name string, annotations map[string]string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+		},
+	}
+}
+
+func newTestBreakers(functions ...*faasv1.Function) (*Breakers, *clock.FakeClock, *record.FakeRecorder) {
+	faasInformerFactory := informers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	for _, function := range functions {
+		functionsInformer.Informer().GetIndexer().Add(function)
+	}
+
+	fakeClock := clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	recorder := record.NewFakeRecorder(100)
+	b := NewBreakers("openfaas-fn", functionsInformer.Lister().Functions("openfaas-fn"), recorder, fakeClock)
+	return b, fakeClock, recorder
+}
+
+func invoke(t *testing.T, b *Breakers, functionName string, success bool) {
+	done, err := b.Allow(functionName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done(success)
+}
+
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, reason string) {
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, reason) {
+			t.Errorf("expected a %s event, got %q", reason, event)
+		}
+	default:
+		t.Errorf("expected a %s event, got none", reason)
+	}
+}
+
+func Test_GetPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *Policy
+		wantErr     bool
+	}{
+		{
+			name: "no circuit breaker",
+		},
+		{
+			name:        "window without a threshold",
+			annotations: map[string]string{AnnotationWindow: "1m"},
+		},
+		{
+			name:        "consecutive failures with defaults",
+			annotations: map[string]string{AnnotationConsecutiveFailures: "5"},
+			expected:    &Policy{ConsecutiveFailures: 5, MinRequests: defaultMinRequests, Window: defaultWindow, OpenDuration: defaultOpenDuration},
+		},
+		{
+			name: "error ratio",
+			annotations: map[string]string{
+				AnnotationErrorRatio:   "0.5",
+				AnnotationMinRequests:  "20",
+				AnnotationWindow:       "1m",
+				AnnotationOpenDuration: "10s",
+			},
+			expected: &Policy{ErrorRatio: 0.5, MinRequests: 20, Window: time.Minute, OpenDuration: time.Second * 10},
+		},
+		{
+			name:        "ratio over 1",
+			annotations: map[string]string{AnnotationErrorRatio: "1.5"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid open duration",
+			annotations: map[string]string{AnnotationConsecutiveFailures: "5", AnnotationOpenDuration: "soon"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := GetPolicy(newTestFunction("nodeinfo", tc.annotations))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (policy == nil) != (tc.expected == nil) || (policy != nil && *policy != *tc.expected) {
+				t.Errorf("expected policy %+v, got %+v", tc.expected, policy)
+			}
+		})
+	}
+}
+
+func Test_Breakers_OpensAfterConsecutiveFailures(t *testing.T) {
+	circuitRejected.Reset()
+	b, fakeClock, recorder := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationConsecutiveFailures: "3",
+		AnnotationOpenDuration:        "30s",
+	}))
+
+	invoke(t, b, "nodeinfo", false)
+	invoke(t, b, "nodeinfo", false)
+	invoke(t, b, "nodeinfo", true)
+	invoke(t, b, "nodeinfo", false)
+	invoke(t, b, "nodeinfo", false)
+	if _, err := b.Allow("nodeinfo"); err != nil {
+		t.Fatalf("expected the circuit to stay closed after a success between failures, got %v", err)
+	}
+
+	invoke(t, b, "nodeinfo", false)
+	expectEvent(t, recorder, ReasonCircuitOpen)
+
+	if _, err := b.Allow("nodeinfo.openfaas-fn"); err != ErrOpen {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+	if got := testutil.ToFloat64(circuitState.WithLabelValues("nodeinfo")); got != 2 {
+		t.Errorf("expected the open state in the metrics, got %v", got)
+	}
+	if got := testutil.ToFloat64(circuitRejected.WithLabelValues("nodeinfo")); got != 1 {
+		t.Errorf("expected 1 rejected request, got %v", got)
+	}
+
+	fakeClock.Step(time.Second * 30)
+	probe, err := b.Allow("nodeinfo")
+	if err != nil {
+		t.Fatalf("expected a probe after the open duration, got %v", err)
+	}
+	expectEvent(t, recorder, ReasonCircuitHalfOpen)
+
+	if _, err := b.Allow("nodeinfo"); err != ErrOpen {
+		t.Errorf("expected a single probe at a time, got %v", err)
+	}
+
+	probe(true)
+	expectEvent(t, recorder, ReasonCircuitClosed)
+	invoke(t, b, "nodeinfo", true)
+}
+
+func Test_Breakers_OpensAgainAfterFailedProbe(t *testing.T) {
+	b, fakeClock, recorder := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationConsecutiveFailures: "1",
+		AnnotationOpenDuration:        "10s",
+	}))
+
+	invoke(t, b, "nodeinfo", false)
+	expectEvent(t, recorder, ReasonCircuitOpen)
+
+	fakeClock.Step(time.Second * 10)
+	invoke(t, b, "nodeinfo", false)
+	expectEvent(t, recorder, ReasonCircuitHalfOpen)
+	expectEvent(t, recorder, ReasonCircuitOpen)
+
+	if _, err := b.Allow("nodeinfo"); err != ErrOpen {
+		t.Errorf("expected the circuit to open again, got %v", err)
+	}
+}
+
+func Test_Breakers_NewProbeAfterStuckProbe(t *testing.T) {
+	b, fakeClock, recorder := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationConsecutiveFailures: "1",
+		AnnotationOpenDuration:        "10s",
+	}))
+
+	invoke(t, b, "nodeinfo", false)
+	expectEvent(t, recorder, ReasonCircuitOpen)
+
+	fakeClock.Step(time.Second * 10)
+	stuck, err := b.Allow("nodeinfo")
+	if err != nil {
+		t.Fatalf("expected a probe after the open duration, got %v", err)
+	}
+	expectEvent(t, recorder, ReasonCircuitHalfOpen)
+
+	fakeClock.Step(time.Second * 5)
+	if _, err := b.Allow("nodeinfo"); err != ErrOpen {
+		t.Fatalf("expected a single probe at a time, got %v", err)
+	}
+
+	fakeClock.Step(time.Second * 5)
+	probe, err := b.Allow("nodeinfo")
+	if err != nil {
+		t.Fatalf("expected a new probe when the previous one never completes, got %v", err)
+	}
+
+	// the result of the stuck probe is ignored
+	stuck(false)
+	if _, err := b.Allow("nodeinfo"); err != ErrOpen {
+		t.Errorf("expected the new probe to be in progress, got %v", err)
+	}
+
+	probe(true)
+	expectEvent(t, recorder, ReasonCircuitClosed)
+	invoke(t, b, "nodeinfo", true)
+}
+
+func Test_Breakers_ErrorRatio(t *testing.T) {
+	b, fakeClock, _ := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationErrorRatio:  "0.5",
+		AnnotationMinRequests: "4",
+		AnnotationWindow:      "10s",
+	}))
+
+	// the ratio is not checked until the window has the minimum number of requests
+	invoke(t, b, "nodeinfo", false)
+	invoke(t, b, "nodeinfo", true)
+	invoke(t, b, "nodeinfo", false)
+
+	// the counts are reset with the window
+	fakeClock.Step(time.Second * 10)
+	invoke(t, b, "nodeinfo", true)
+	invoke(t, b, "nodeinfo", false)
+	invoke(t, b, "nodeinfo", true)
+	if _, err := b.Allow("nodeinfo"); err != nil {
+		t.Fatalf("expected the circuit to be closed under the minimum requests, got %v", err)
+	}
+
+	invoke(t, b, "nodeinfo", false)
+	if _, err := b.Allow("nodeinfo"); err != ErrOpen {
+		t.Errorf("expected the circuit to open at an error ratio of 0.5, got %v", err)
+	}
+}
+
+func Test_Breakers_FunctionsWithoutCircuitBreaker(t *testing.T) {
+	b, _, _ := newTestBreakers(newTestFunction("nodeinfo", nil))
+
+	for i := 0; i < 100; i++ {
+		invoke(t, b, "nodeinfo", false)
+		b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", false)
+	}
+	if b.Ejected("nodeinfo", "10.0.0.1:8080") {
+		t.Errorf("expected endpoints not to be ejected without a circuit breaker")
+	}
+	invoke(t, b, "unknown", false)
+}
+
+func Test_Breakers_EjectsFailingEndpoints(t *testing.T) {
+	endpointEjections.Reset()
+	b, fakeClock, recorder := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationConsecutiveFailures: "2",
+		AnnotationOpenDuration:        "30s",
+	}))
+
+	b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", false)
+	b.RecordEndpoint("nodeinfo", "10.0.0.2:8080", true)
+	b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", false)
+	expectEvent(t, recorder, ReasonEndpointEjected)
+
+	if !b.Ejected("nodeinfo", "10.0.0.1:8080") {
+		t.Errorf("expected the failing endpoint to be ejected")
+	}
+	if b.Ejected("nodeinfo", "10.0.0.2:8080") {
+		t.Errorf("expected the healthy endpoint to stay")
+	}
+	if got := testutil.ToFloat64(endpointEjected.WithLabelValues("nodeinfo", "10.0.0.1:8080")); got != 1 {
+		t.Errorf("expected the ejected endpoint in the metrics, got %v", got)
+	}
+	if got := testutil.ToFloat64(endpointEjections.WithLabelValues("nodeinfo")); got != 1 {
+		t.Errorf("expected 1 ejection, got %v", got)
+	}
+
+	// the endpoint gets requests again after the open duration
+	fakeClock.Step(time.Second * 30)
+	if b.Ejected("nodeinfo", "10.0.0.1:8080") {
+		t.Fatalf("expected the endpoint to be restored after the open duration")
+	}
+
+	// a single failure ejects it again
+	b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", false)
+	expectEvent(t, recorder, ReasonEndpointEjected)
+	if !b.Ejected("nodeinfo", "10.0.0.1:8080") {
+		t.Errorf("expected the endpoint to be ejected after a failed request")
+	}
+
+	fakeClock.Step(time.Second * 30)
+	b.Ejected("nodeinfo", "10.0.0.1:8080")
+	b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", true)
+	expectEvent(t, recorder, ReasonEndpointRestored)
+}
+
+func Test_Breakers_PrunesIdleEndpoints(t *testing.T) {
+	b, fakeClock, _ := newTestBreakers(newTestFunction("nodeinfo", map[string]string{
+		AnnotationConsecutiveFailures: "5",
+	}))
+
+	b.RecordEndpoint("nodeinfo", "10.0.0.1:8080", false)
+	fakeClock.Step(pruneInterval)
+	b.RecordEndpoint("nodeinfo", "10.0.0.2:8080", true)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.endpoints["nodeinfo"]["10.0.0.1:8080"]; ok {
+		t.Errorf("expected the idle endpoint to be removed")
+	}
+}
@@ -0,0 +1,181 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/clock"
+	glog "k8s.io/klog"
+)
+
+// checkInterval is how often the files are checked for changes, the
+// check is made by the TLS handshakes so idle servers don't read the files
+const checkInterval = 10 * time.Second
+
+var (
+	certificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "operator_tls_certificate_expiry_timestamp_seconds",
+		Help: "The time at which the serving certificate of the operator expires.",
+	})
+
+	certificateReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_tls_certificate_reloads_total",
+		Help: "The reloads of the serving certificate and client CA of the operator by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(certificateExpiry, certificateReloads)
+}
+
+// Reloader serves a certificate and an optional client CA from files and reloads them when
+// the files change, for example when a Secret mounted in the pod is rotated. The previous
+// files keep being served when the new ones can't be loaded.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	clock        clock.PassiveClock
+
+	lock      sync.Mutex
+	config    *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the certificate and the key, the client certificates are required
+// and verified with the client CA when clientCAFile is set
+func NewReloader(certFile, keyFile, clientCAFile string, clock clock.PassiveClock) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		clock:        clock,
+	}
+
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	r.lastCheck = clock.Now()
+	return r, nil
+}
+
+// TLSConfig returns the config of a server which serves the current files
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.Config(), nil
+		},
+	}
+}
+
+// Config returns the TLS config of the current files, the files are checked
+// for changes at most every checkInterval
+func (r *Reloader) Config() *tls.Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+	if now.Sub(r.lastCheck) < checkInterval {
+		return r.config
+	}
+	r.lastCheck = now
+
+	modTimes, err := r.stat()
+	if err != nil {
+		glog.Errorf("Unable to check the TLS files: %v", err)
+		return r.config
+	}
+	if !changed(modTimes, r.modTimes) {
+		return r.config
+	}
+
+	if err := r.load(modTimes); err != nil {
+		certificateReloads.WithLabelValues("error").Inc()
+		glog.Errorf("Unable to reload the TLS files, serving the previous certificate: %v", err)
+		return r.config
+	}
+	certificateReloads.WithLabelValues("success").Inc()
+	glog.Infof("Reloaded the TLS certificate from %s", r.certFile)
+	return r.config
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if len(r.clientCAFile) > 0 {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// load replaces the config with the files, the config is left as it is on errors
+func (r *Reloader) load(modTimes []time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the certificate %s: %v", r.certFile, err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse the certificate %s: %v", r.certFile, err)
+	}
+	certificate.Leaf = leaf
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if len(r.clientCAFile) > 0 {
+		data, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the client CA %s: %v", r.clientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in the client CA %s", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config = config
+	r.modTimes = modTimes
+	certificateExpiry.Set(float64(leaf.NotAfter.Unix()))
+	return nil
+}
+
+func changed(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return true
+		}
+	}
+	return false
+}
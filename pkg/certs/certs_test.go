@@ -0,0 +1,241 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+type authority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newAuthority(t *testing.T) *authority {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	return &authority{certificate: certificate, key: key}
+}
+
+// issue writes a certificate and its key signed by the authority to name.crt and name.key
+func (a *authority) issue(t *testing.T, dir, name string, notAfter time.Time) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.certificate, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func (a *authority) write(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.certificate.Raw}), 0600)
+	return path
+}
+
+func (a *authority) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.certificate)
+	return pool
+}
+
+// tempDir returns a directory which is removed by the returned func
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// touch moves the modification time of the files forward so that the change is
+// seen even when the files are written within the resolution of the file system
+func touch(files ...string) {
+	later := time.Now().Add(time.Minute)
+	for _, file := range files {
+		os.Chtimes(file, later, later)
+	}
+}
+
+func Test_NewReloader_Invalid(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	ca := newAuthority(t)
+	certFile, keyFile := ca.issue(t, dir, "server", time.Now().Add(time.Hour))
+	_, otherKeyFile := ca.issue(t, dir, "other", time.Now().Add(time.Hour))
+	invalidCA := filepath.Join(dir, "invalid-ca.crt")
+	ioutil.WriteFile(invalidCA, []byte("not a certificate"), 0600)
+
+	cases := []struct {
+		name         string
+		certFile     string
+		keyFile      string
+		clientCAFile string
+	}{
+		{name: "missing certificate", certFile: filepath.Join(dir, "missing.crt"), keyFile: keyFile},
+		{name: "key of another certificate", certFile: certFile, keyFile: otherKeyFile},
+		{name: "missing client CA", certFile: certFile, keyFile: keyFile, clientCAFile: filepath.Join(dir, "missing-ca.crt")},
+		{name: "invalid client CA", certFile: certFile, keyFile: keyFile, clientCAFile: invalidCA},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewReloader(tc.certFile, tc.keyFile, tc.clientCAFile, clock.RealClock{}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func Test_Reloader_ReloadsChangedFiles(t *testing.T) {
+	certificateReloads.Reset()
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	ca := newAuthority(t)
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	certFile, keyFile := ca.issue(t, dir, "server", firstExpiry)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	r, err := NewReloader(certFile, keyFile, "", fakeClock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := r.Config().Certificates[0].Leaf
+	if got := testutil.ToFloat64(certificateExpiry); got != float64(firstExpiry.Unix()) {
+		t.Errorf("expected the expiry %d, got %v", firstExpiry.Unix(), got)
+	}
+
+	secondExpiry := firstExpiry.Add(time.Hour)
+	ca.issue(t, dir, "server", secondExpiry)
+	touch(certFile, keyFile)
+
+	if got := r.Config().Certificates[0].Leaf; !got.Equal(first) {
+		t.Errorf("expected the files to be checked after %s only", checkInterval)
+	}
+
+	fakeClock.Step(checkInterval)
+	second := r.Config().Certificates[0].Leaf
+	if second.Equal(first) || !second.NotAfter.Equal(secondExpiry) {
+		t.Errorf("expected the rotated certificate expiring at %s, got %s", secondExpiry, second.NotAfter)
+	}
+	if got := testutil.ToFloat64(certificateExpiry); got != float64(secondExpiry.Unix()) {
+		t.Errorf("expected the expiry %d, got %v", secondExpiry.Unix(), got)
+	}
+	if got := testutil.ToFloat64(certificateReloads.WithLabelValues("success")); got != 1 {
+		t.Errorf("expected 1 reload, got %v", got)
+	}
+
+	// a certificate written without its key yet keeps the previous one
+	ioutil.WriteFile(certFile, []byte("partial"), 0600)
+	touch(certFile)
+	fakeClock.Step(checkInterval)
+	if got := r.Config().Certificates[0].Leaf; !got.Equal(second) {
+		t.Errorf("expected the previous certificate to be served when the new one is invalid")
+	}
+	if got := testutil.ToFloat64(certificateReloads.WithLabelValues("error")); got != 1 {
+		t.Errorf("expected 1 failed reload, got %v", got)
+	}
+}
+
+func Test_Reloader_ClientCertificates(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	ca := newAuthority(t)
+	certFile, keyFile := ca.issue(t, dir, "server", time.Now().Add(time.Hour))
+
+	r, err := NewReloader(certFile, keyFile, ca.write(t, dir), clock.RealClock{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = r.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "gateway", time.Now().Add(time.Hour))
+	clientCertificate, _ := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	otherCertFile, otherKeyFile := newAuthority(t).issue(t, dir, "other", time.Now().Add(time.Hour))
+	otherCertificate, _ := tls.LoadX509KeyPair(otherCertFile, otherKeyFile)
+
+	cases := []struct {
+		name         string
+		certificates []tls.Certificate
+		allowed      bool
+	}{
+		{name: "client certificate of the CA", certificates: []tls.Certificate{clientCertificate}, allowed: true},
+		{name: "no client certificate"},
+		{name: "client certificate of another CA", certificates: []tls.Certificate{otherCertificate}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      ca.pool(),
+				Certificates: tc.certificates,
+			}}}
+			res, err := client.Get(server.URL)
+			if !tc.allowed {
+				if err == nil {
+					res.Body.Close()
+					t.Errorf("expected the connection to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			if string(body) != "gateway" {
+				t.Errorf("expected the client certificate of the gateway, got %q", string(body))
+			}
+		})
+	}
+}
@@ -90,6 +90,18 @@ func (c *FakeFunctions) Update(function *openfaasv1.Function) (result *openfaasv
 	return obj.(*openfaasv1.Function), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeFunctions) UpdateStatus(function *openfaasv1.Function) (*openfaasv1.Function, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(functionsResource, "status", c.ns, function), &openfaasv1.Function{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*openfaasv1.Function), err
+}
+
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
 func (c *FakeFunctions) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
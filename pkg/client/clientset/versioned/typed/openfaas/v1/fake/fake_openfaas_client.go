@@ -22,6 +22,10 @@ func (c *FakeOpenfaasV1) Functions(namespace string) v1.FunctionInterface {
 	return &FakeFunctions{c, namespace}
 }
 
+func (c *FakeOpenfaasV1) FunctionIngresses(namespace string) v1.FunctionIngressInterface {
+	return &FakeFunctionIngresses{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeOpenfaasV1) RESTClient() rest.Interface {
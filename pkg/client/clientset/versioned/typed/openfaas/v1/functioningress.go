@@ -0,0 +1,181 @@
+/*
+Copyright 2019-2020 OpenFaaS Authors
+
+Licensed under the MIT license. See LICENSE file in the project root for full license information.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"time"
+
+	v1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	scheme "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// FunctionIngressesGetter has a method to return a FunctionIngressInterface.
+// A group's client should implement this interface.
+type FunctionIngressesGetter interface {
+	FunctionIngresses(namespace string) FunctionIngressInterface
+}
+
+// FunctionIngressInterface has methods to work with FunctionIngress resources.
+type FunctionIngressInterface interface {
+	Create(*v1.FunctionIngress) (*v1.FunctionIngress, error)
+	Update(*v1.FunctionIngress) (*v1.FunctionIngress, error)
+	UpdateStatus(*v1.FunctionIngress) (*v1.FunctionIngress, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.FunctionIngress, error)
+	List(opts metav1.ListOptions) (*v1.FunctionIngressList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.FunctionIngress, err error)
+	FunctionIngressExpansion
+}
+
+// functionIngresses implements FunctionIngressInterface
+type functionIngresses struct {
+	client rest.Interface
+	ns     string
+}
+
+// newFunctionIngresses returns a FunctionIngresses
+func newFunctionIngresses(c *OpenfaasV1Client, namespace string) *functionIngresses {
+	return &functionIngresses{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the functionIngress, and returns the corresponding functionIngress object, and an error if there is any.
+func (c *functionIngresses) Get(name string, options metav1.GetOptions) (result *v1.FunctionIngress, err error) {
+	result = &v1.FunctionIngress{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of FunctionIngresses that match those selectors.
+func (c *functionIngresses) List(opts metav1.ListOptions) (result *v1.FunctionIngressList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.FunctionIngressList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested functionIngresses.
+func (c *functionIngresses) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a functionIngress and creates it.  Returns the server's representation of the functionIngress, and an error, if there is any.
+func (c *functionIngresses) Create(functionIngress *v1.FunctionIngress) (result *v1.FunctionIngress, err error) {
+	result = &v1.FunctionIngress{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		Body(functionIngress).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a functionIngress and updates it. Returns the server's representation of the functionIngress, and an error, if there is any.
+func (c *functionIngresses) Update(functionIngress *v1.FunctionIngress) (result *v1.FunctionIngress, err error) {
+	result = &v1.FunctionIngress{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		Name(functionIngress.Name).
+		Body(functionIngress).
+		Do().
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *functionIngresses) UpdateStatus(functionIngress *v1.FunctionIngress) (result *v1.FunctionIngress, err error) {
+	result = &v1.FunctionIngress{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		Name(functionIngress.Name).
+		SubResource("status").
+		Body(functionIngress).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the functionIngress and deletes it. Returns an error if one occurs.
+func (c *functionIngresses) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *functionIngresses) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("functioningresses").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched functionIngress.
+func (c *functionIngresses) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.FunctionIngress, err error) {
+	result = &v1.FunctionIngress{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("functioningresses").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
@@ -17,6 +17,7 @@ import (
 type OpenfaasV1Interface interface {
 	RESTClient() rest.Interface
 	FunctionsGetter
+	FunctionIngressesGetter
 }
 
 // OpenfaasV1Client is used to interact with features provided by the openfaas.com group.
@@ -28,6 +29,10 @@ func (c *OpenfaasV1Client) Functions(namespace string) FunctionInterface {
 	return newFunctions(c, namespace)
 }
 
+func (c *OpenfaasV1Client) FunctionIngresses(namespace string) FunctionIngressInterface {
+	return newFunctionIngresses(c, namespace)
+}
+
 // NewForConfig creates a new OpenfaasV1Client for the given config.
 func NewForConfig(c *rest.Config) (*OpenfaasV1Client, error) {
 	config := *c
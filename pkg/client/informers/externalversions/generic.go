@@ -45,6 +45,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=openfaas.com, Version=v1
 	case v1.SchemeGroupVersion.WithResource("functions"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Openfaas().V1().Functions().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("functioningresses"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Openfaas().V1().FunctionIngresses().Informer()}, nil
 
 	}
 
@@ -16,6 +16,8 @@ import (
 type Interface interface {
 	// Functions returns a FunctionInformer.
 	Functions() FunctionInformer
+	// FunctionIngresses returns a FunctionIngressInformer.
+	FunctionIngresses() FunctionIngressInformer
 }
 
 type version struct {
@@ -33,3 +35,8 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (v *version) Functions() FunctionInformer {
 	return &functionInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// FunctionIngresses returns a FunctionIngressInformer.
+func (v *version) FunctionIngresses() FunctionIngressInformer {
+	return &functionIngressInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
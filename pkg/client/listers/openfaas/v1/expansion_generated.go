@@ -15,3 +15,11 @@ type FunctionListerExpansion interface{}
 // FunctionNamespaceListerExpansion allows custom methods to be added to
 // FunctionNamespaceLister.
 type FunctionNamespaceListerExpansion interface{}
+
+// FunctionIngressListerExpansion allows custom methods to be added to
+// FunctionIngressLister.
+type FunctionIngressListerExpansion interface{}
+
+// FunctionIngressNamespaceListerExpansion allows custom methods to be added to
+// FunctionIngressNamespaceLister.
+type FunctionIngressNamespaceListerExpansion interface{}
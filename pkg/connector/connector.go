@@ -0,0 +1,32 @@
+package connector
+
+import "net/http"
+
+// Message is a message received on a topic
+type Message struct {
+	// ID identifies the message across its deliveries
+	ID     string
+	Topic  string
+	Body   []byte
+	Header http.Header
+}
+
+// Handler processes a message, the message is acknowledged when the handler
+// returns nil and delivered again when it returns an error
+type Handler func(msg *Message) error
+
+// Subscription receives the messages of a topic until it's unsubscribed
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Connector receives messages from a message broker with at-least-once delivery
+type Connector interface {
+	// Subscribe calls the handler for each message published to the topic
+	Subscribe(topic string, handler Handler) (Subscription, error)
+}
+
+// Publisher sends messages to the topics of a message broker
+type Publisher interface {
+	Publish(msg *Message) error
+}
@@ -0,0 +1,382 @@
+package connector
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+// AnnotationTopic is the function annotation holding a comma separated list of topics
+const AnnotationTopic = "topic"
+
+// Dispatcher subscribes to the topics of the functions and invokes the
+// functions through the function proxy when messages arrive
+type Dispatcher struct {
+	connector   Connector
+	invoker     http.Handler
+	deadLetters *queue.DeadLetters
+	maxAttempts int
+	// slots limits the number of concurrent invocations
+	slots chan struct{}
+
+	functionsSynced cache.InformerSynced
+
+	lock          sync.Mutex
+	started       bool
+	topics        *TopicMap
+	subscriptions map[string]Subscription
+	// deliveries are the messages which are not acknowledged yet, by ID
+	deliveries map[string]*delivery
+	running    sync.WaitGroup
+}
+
+// delivery tracks the functions of a message which are done, either invoked successfully
+// or given up after all their attempts, and the attempts of the others
+type delivery struct {
+	topic      string
+	receivedAt time.Time
+	done       map[string]bool
+	attempts   map[string]int
+}
+
+// NewDispatcher returns a Dispatcher which keeps the subscriptions of the connector in sync
+// with the topic annotations of the functions and invokes at most maxInflight functions at a time.
+// A function which still fails after maxAttempts deliveries of a message gets a dead letter.
+func NewDispatcher(
+	connector Connector,
+	functionsInformer faasinformers.FunctionInformer,
+	invoker http.Handler,
+	deadLetters *queue.DeadLetters,
+	maxInflight int,
+	maxAttempts int) *Dispatcher {
+
+	d := &Dispatcher{
+		connector:       connector,
+		invoker:         invoker,
+		deadLetters:     deadLetters,
+		maxAttempts:     maxAttempts,
+		slots:           make(chan struct{}, maxInflight),
+		functionsSynced: functionsInformer.Informer().HasSynced,
+		topics:          NewTopicMap(),
+		subscriptions:   map[string]Subscription{},
+		deliveries:      map[string]*delivery{},
+	}
+
+	functionsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if function, ok := obj.(*faasv1.Function); ok {
+				d.update(function.Spec.Name, functionTopics(function))
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if function, ok := new.(*faasv1.Function); ok {
+				d.update(function.Spec.Name, functionTopics(function))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if function, ok := obj.(*faasv1.Function); ok {
+				d.update(function.Spec.Name, nil)
+			}
+		},
+	})
+
+	return d
+}
+
+// Run waits for the informer cache to sync and subscribes to the topics of the functions,
+// when stopCh is closed it unsubscribes and waits for the invocations in progress
+func (d *Dispatcher) Run(stopCh <-chan struct{}) error {
+	if ok := cache.WaitForCacheSync(stopCh, d.functionsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	d.lock.Lock()
+	d.started = true
+	d.syncSubscriptions()
+	d.lock.Unlock()
+
+	glog.Info("Started connector dispatcher")
+	<-stopCh
+
+	d.lock.Lock()
+	d.started = false
+	for topic, sub := range d.subscriptions {
+		if err := sub.Unsubscribe(); err != nil {
+			runtime.HandleError(fmt.Errorf("unsubscribe from %s error: %v", topic, err))
+		}
+		delete(d.subscriptions, topic)
+	}
+	d.deliveries = map[string]*delivery{}
+	d.lock.Unlock()
+
+	d.running.Wait()
+	glog.Info("Shutting down connector dispatcher")
+	return nil
+}
+
+// update sets the topics of a function and updates the subscriptions
+func (d *Dispatcher) update(functionName string, topics []string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.topics.Set(functionName, topics)
+	if d.started {
+		d.syncSubscriptions()
+	}
+}
+
+// syncSubscriptions subscribes to new topics and unsubscribes from topics without
+// functions, the lock must be held by the caller
+func (d *Dispatcher) syncSubscriptions() {
+	topics := map[string]bool{}
+	for _, topic := range d.topics.Topics() {
+		topics[topic] = true
+
+		if _, ok := d.subscriptions[topic]; ok {
+			continue
+		}
+
+		sub, err := d.connector.Subscribe(topic, d.makeHandler(topic))
+		if err != nil {
+			runtime.HandleError(fmt.Errorf("subscribe to %s error: %v", topic, err))
+			continue
+		}
+		glog.Infof("Subscribed to topic %s", topic)
+		d.subscriptions[topic] = sub
+	}
+
+	for topic, sub := range d.subscriptions {
+		if topics[topic] {
+			continue
+		}
+		if err := sub.Unsubscribe(); err != nil {
+			runtime.HandleError(fmt.Errorf("unsubscribe from %s error: %v", topic, err))
+		}
+		glog.Infof("Unsubscribed from topic %s", topic)
+		delete(d.subscriptions, topic)
+
+		// the messages of the topic are not delivered again
+		for id, delivery := range d.deliveries {
+			if delivery.topic == topic {
+				delete(d.deliveries, id)
+			}
+		}
+	}
+}
+
+// makeHandler returns the handler of a topic which invokes the functions of the topic, the
+// message is only acknowledged when all the functions are done. The functions which were
+// invoked successfully are not invoked again when the message is delivered again.
+func (d *Dispatcher) makeHandler(topic string) Handler {
+	return func(msg *Message) error {
+		d.running.Add(1)
+		defer d.running.Done()
+
+		d.lock.Lock()
+		functions := d.topics.Functions(topic)
+		current := d.deliveries[msg.ID]
+		if current == nil {
+			current = &delivery{topic: topic, receivedAt: time.Now(), done: map[string]bool{}, attempts: map[string]int{}}
+		}
+		pending := []string{}
+		for _, functionName := range functions {
+			if !current.done[functionName] {
+				pending = append(pending, functionName)
+			}
+		}
+		d.lock.Unlock()
+
+		results := make([]invocation, len(pending))
+		wg := sync.WaitGroup{}
+		for i, functionName := range pending {
+			wg.Add(1)
+			d.slots <- struct{}{}
+			go func(i int, functionName string) {
+				defer wg.Done()
+				defer func() { <-d.slots }()
+
+				results[i] = d.invoke(functionName, msg)
+			}(i, functionName)
+		}
+		wg.Wait()
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		failed := []string{}
+		for i, functionName := range pending {
+			result := results[i]
+			if result.err == nil {
+				current.done[functionName] = true
+				continue
+			}
+
+			current.attempts[functionName]++
+			if current.attempts[functionName] < d.maxAttempts {
+				failed = append(failed, result.err.Error())
+				continue
+			}
+
+			glog.Errorf("Topic %s delivery to %s failed after %d attempts: %v", topic, functionName, current.attempts[functionName], result.err)
+			current.done[functionName] = true
+			d.deadLetters.Add(queue.DeadLetter{
+				Request: &queue.Request{
+					CallID:     msg.ID,
+					Function:   functionName,
+					Method:     http.MethodPost,
+					Header:     msg.Header,
+					Body:       msg.Body,
+					EnqueuedAt: current.receivedAt,
+				},
+				StatusCode: result.statusCode,
+				Error:      result.err.Error(),
+				Attempts:   current.attempts[functionName],
+				FailedAt:   time.Now(),
+			})
+		}
+
+		if len(failed) > 0 {
+			d.deliveries[msg.ID] = current
+			return fmt.Errorf("topic %s delivery failed: %s", topic, strings.Join(failed, ", "))
+		}
+		delete(d.deliveries, msg.ID)
+		return nil
+	}
+}
+
+// invocation is the result of the invocation of a function with a message
+type invocation struct {
+	statusCode int
+	err        error
+}
+
+// invoke calls the function with the message through the invoker
+func (d *Dispatcher) invoke(functionName string, msg *Message) invocation {
+	req, err := http.NewRequest(http.MethodPost, "/function/"+functionName, bytes.NewReader(msg.Body))
+	if err != nil {
+		return invocation{err: err}
+	}
+	for k, v := range msg.Header {
+		req.Header[k] = append([]string{}, v...)
+	}
+	req.Header.Set("X-Topic", msg.Topic)
+
+	w := &statusRecorder{header: http.Header{}, statusCode: http.StatusOK}
+	d.invoker.ServeHTTP(w, req)
+
+	if w.statusCode >= http.StatusInternalServerError || w.statusCode == http.StatusTooManyRequests {
+		return invocation{statusCode: w.statusCode, err: fmt.Errorf("function %s returned %d", functionName, w.statusCode)}
+	}
+
+	glog.V(2).Infof("Topic %s delivered to %s with status %d", msg.Topic, functionName, w.statusCode)
+	return invocation{statusCode: w.statusCode}
+}
+
+// functionTopics returns the topics in the topic annotation of a function
+func functionTopics(function *faasv1.Function) []string {
+	if function.Spec.Annotations == nil {
+		return nil
+	}
+
+	value, ok := (*function.Spec.Annotations)[AnnotationTopic]
+	if !ok {
+		return nil
+	}
+
+	topics := []string{}
+	for _, topic := range strings.Split(value, ",") {
+		if topic = strings.TrimSpace(topic); len(topic) > 0 {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// TopicMap maps topics to the functions subscribed to them
+type TopicMap struct {
+	functions map[string][]string
+}
+
+// NewTopicMap returns an empty TopicMap
+func NewTopicMap() *TopicMap {
+	return &TopicMap{
+		functions: map[string][]string{},
+	}
+}
+
+// Set replaces the topics of a function, a function without topics is removed from the map
+func (m *TopicMap) Set(functionName string, topics []string) {
+	for topic, functions := range m.functions {
+		remaining := []string{}
+		for _, f := range functions {
+			if f != functionName {
+				remaining = append(remaining, f)
+			}
+		}
+
+		if len(remaining) == 0 {
+			delete(m.functions, topic)
+		} else {
+			m.functions[topic] = remaining
+		}
+	}
+
+	for _, topic := range topics {
+		m.functions[topic] = append(m.functions[topic], functionName)
+		sort.Strings(m.functions[topic])
+	}
+}
+
+// Functions returns the functions subscribed to a topic
+func (m *TopicMap) Functions(topic string) []string {
+	return append([]string{}, m.functions[topic]...)
+}
+
+// Topics returns the topics with at least one function
+func (m *TopicMap) Topics() []string {
+	topics := []string{}
+	for topic := range m.functions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// statusRecorder is an http.ResponseWriter which discards the response body
+type statusRecorder struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.statusCode = statusCode
+	r.wroteHeader = true
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return len(data), nil
+}
@@ -0,0 +1,346 @@
+package connector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestFunction(name, topic string) *faasv1.Function {
+	annotations := map[string]string{AnnotationTopic: topic}
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+		},
+	}
+}
+
+// testInvoker records the bodies received by each function
+type testInvoker struct {
+	lock     sync.Mutex
+	received map[string][]string
+	// status returns the status code of an invocation
+	status func(functionName string) int
+}
+
+func (i *testInvoker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	functionName := r.URL.Path[len("/function/"):]
+
+	i.lock.Lock()
+	i.received[functionName] = append(i.received[functionName], string(body)+"@"+r.Header.Get("X-Topic"))
+	status := i.status
+	i.lock.Unlock()
+
+	if status != nil {
+		w.WriteHeader(status(functionName))
+	}
+}
+
+func (i *testInvoker) get(functionName string) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return append([]string{}, i.received[functionName]...)
+}
+
+func (i *testInvoker) waitFor(functionName string, count int) []string {
+	for n := 0; n < 200 && len(i.get(functionName)) < count; n++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	return i.get(functionName)
+}
+
+type testDispatcher struct {
+	*Dispatcher
+	broker      *MemoryBroker
+	deadLetters *queue.DeadLetters
+	invoker     *testInvoker
+	functions   cache.Indexer
+	stopCh      chan struct{}
+	done        chan struct{}
+}
+
+func newTestDispatcher(t *testing.T, functions ...*faasv1.Function) *testDispatcher {
+	faas := faasfake.NewSimpleClientset()
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+
+	broker := NewMemoryBroker(10, time.Millisecond)
+	invoker := &testInvoker{received: map[string][]string{}}
+	deadLetters := queue.NewDeadLetters(10)
+	d := &testDispatcher{
+		Dispatcher:  NewDispatcher(broker, functionsInformer, invoker, deadLetters, 2, 3),
+		broker:      broker,
+		deadLetters: deadLetters,
+		invoker:     invoker,
+		functions:   functionsInformer.Informer().GetIndexer(),
+		stopCh:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	for _, function := range functions {
+		d.add(function)
+	}
+
+	// the informer is not started, the cache is filled by the tests
+	d.functionsSynced = func() bool { return true }
+	go func() {
+		if err := d.Run(d.stopCh); err != nil {
+			t.Error(err)
+		}
+		close(d.done)
+	}()
+
+	for n := 0; n < 200 && !d.isStarted(); n++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	return d
+}
+
+// add simulates an informer event for a new function
+func (d *testDispatcher) add(function *faasv1.Function) {
+	d.functions.Add(function)
+	d.update(function.Spec.Name, functionTopics(function))
+}
+
+func (d *testDispatcher) isStarted() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.started
+}
+
+func (d *testDispatcher) subscribed(topic string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, ok := d.subscriptions[topic]
+	return ok
+}
+
+func (d *testDispatcher) stop() {
+	close(d.stopCh)
+	<-d.done
+}
+
+func Test_Dispatcher_DeliversToFunctionsOfTopic(t *testing.T) {
+	d := newTestDispatcher(t,
+		newTestFunction("orders", "orders.created"),
+		newTestFunction("audit", "orders.created, payments.received"),
+		newTestFunction("payments", "payments.received"),
+	)
+	defer d.stop()
+
+	if err := d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.invoker.waitFor("orders", 1); !reflect.DeepEqual(got, []string{"order-1@orders.created"}) {
+		t.Errorf("expected orders to receive order-1, got: %v", got)
+	}
+	if got := d.invoker.waitFor("audit", 1); !reflect.DeepEqual(got, []string{"order-1@orders.created"}) {
+		t.Errorf("expected audit to receive order-1, got: %v", got)
+	}
+	if got := d.invoker.get("payments"); len(got) != 0 {
+		t.Errorf("expected payments to receive nothing, got: %v", got)
+	}
+}
+
+func Test_Dispatcher_KeepsSubscriptionsInSync(t *testing.T) {
+	d := newTestDispatcher(t, newTestFunction("orders", "orders.created"))
+	defer d.stop()
+
+	d.add(newTestFunction("payments", "payments.received"))
+	if !d.subscribed("payments.received") {
+		t.Fatalf("expected a subscription to payments.received")
+	}
+
+	d.update("orders", nil)
+	if d.subscribed("orders.created") {
+		t.Fatalf("expected orders.created to be unsubscribed")
+	}
+
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	d.broker.Publish(&Message{Topic: "payments.received", Body: []byte("payment-1")})
+
+	if got := d.invoker.waitFor("payments", 1); len(got) != 1 {
+		t.Errorf("expected payments to receive payment-1, got: %v", got)
+	}
+	if got := d.invoker.get("orders"); len(got) != 0 {
+		t.Errorf("expected orders to receive nothing after its topic was removed, got: %v", got)
+	}
+}
+
+func Test_Dispatcher_RedeliversUntilAcknowledged(t *testing.T) {
+	d := newTestDispatcher(t, newTestFunction("orders", "orders.created"))
+	defer d.stop()
+
+	var attempts int32
+	d.invoker.lock.Lock()
+	d.invoker.status = func(functionName string) int {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}
+	d.invoker.lock.Unlock()
+
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-2")})
+
+	got := d.invoker.waitFor("orders", 4)
+	expected := []string{
+		"order-1@orders.created",
+		"order-1@orders.created",
+		"order-1@orders.created",
+		"order-2@orders.created",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected order-1 to be redelivered before order-2, got: %v", got)
+	}
+}
+
+func Test_Dispatcher_RedeliversToFailedFunctions(t *testing.T) {
+	d := newTestDispatcher(t,
+		newTestFunction("orders", "orders.created"),
+		newTestFunction("audit", "orders.created"),
+	)
+	defer d.stop()
+
+	var attempts int32
+	d.invoker.lock.Lock()
+	d.invoker.status = func(functionName string) int {
+		if functionName == "audit" && atomic.AddInt32(&attempts, 1) < 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}
+	d.invoker.lock.Unlock()
+
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-2")})
+
+	if got := d.invoker.waitFor("audit", 3); len(got) != 3 {
+		t.Fatalf("expected audit to receive order-1 twice and order-2, got: %v", got)
+	}
+	expected := []string{"order-1@orders.created", "order-2@orders.created"}
+	if got := d.invoker.waitFor("orders", 2); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected orders to receive each message once, got: %v", got)
+	}
+}
+
+func Test_Dispatcher_DeadLetterAfterMaxAttempts(t *testing.T) {
+	d := newTestDispatcher(t,
+		newTestFunction("orders", "orders.created"),
+		newTestFunction("audit", "orders.created"),
+	)
+	defer d.stop()
+
+	d.invoker.lock.Lock()
+	d.invoker.status = func(functionName string) int {
+		if functionName == "audit" {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	}
+	d.invoker.lock.Unlock()
+
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-2")})
+
+	d.invoker.waitFor("audit", 6)
+	d.invoker.waitFor("orders", 2)
+	for n := 0; n < 200 && len(d.deadLetters.List("audit")) < 2; n++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	if got := d.invoker.get("audit"); len(got) != 6 {
+		t.Errorf("expected 3 attempts of each message for audit, got: %v", got)
+	}
+	expected := []string{"order-1@orders.created", "order-2@orders.created"}
+	if got := d.invoker.get("orders"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected orders to receive each message once, got: %v", got)
+	}
+
+	letters := d.deadLetters.List("audit")
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters for audit, got: %+v", letters)
+	}
+	letter := letters[0]
+	if string(letter.Request.Body) != "order-1" || letter.StatusCode != http.StatusBadGateway || letter.Attempts != 3 || len(letter.Request.CallID) == 0 {
+		t.Errorf("unexpected dead letter: %+v", letter)
+	}
+	if len(d.deadLetters.List("orders")) != 0 {
+		t.Errorf("expected no dead letters for orders")
+	}
+}
+
+func Test_Dispatcher_LimitsConcurrency(t *testing.T) {
+	functions := []*faasv1.Function{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		functions = append(functions, newTestFunction(name, "orders.created"))
+	}
+	d := newTestDispatcher(t, functions...)
+	defer d.stop()
+
+	var inflight, maxInflight int32
+	d.invoker.lock.Lock()
+	d.invoker.status = func(functionName string) int {
+		current := atomic.AddInt32(&inflight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInflight, max, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+		atomic.AddInt32(&inflight, -1)
+		return http.StatusOK
+	}
+	d.invoker.lock.Unlock()
+
+	d.broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		d.invoker.waitFor(name, 1)
+	}
+
+	if max := atomic.LoadInt32(&maxInflight); max > 2 {
+		t.Errorf("expected at most 2 concurrent invocations, got %d", max)
+	}
+}
+
+func Test_TopicMap(t *testing.T) {
+	m := NewTopicMap()
+	m.Set("orders", []string{"orders.created"})
+	m.Set("audit", []string{"orders.created", "payments.received"})
+
+	if got := m.Functions("orders.created"); !reflect.DeepEqual(got, []string{"audit", "orders"}) {
+		t.Errorf("expected audit and orders, got: %v", got)
+	}
+
+	m.Set("audit", []string{"payments.received"})
+	if got := m.Topics(); !reflect.DeepEqual(got, []string{"orders.created", "payments.received"}) {
+		t.Errorf("expected both topics, got: %v", got)
+	}
+
+	m.Set("orders", nil)
+	if got := m.Topics(); !reflect.DeepEqual(got, []string{"payments.received"}) {
+		t.Errorf("expected payments.received, got: %v", got)
+	}
+}
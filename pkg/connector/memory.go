@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// MemoryBroker is a Connector and Publisher held in memory, messages are only
+// delivered to the subscriptions which exist when they are published
+type MemoryBroker struct {
+	bufferSize      int
+	redeliveryDelay time.Duration
+
+	lock          sync.RWMutex
+	subscriptions map[string]map[*memorySubscription]bool
+}
+
+type memorySubscription struct {
+	broker   *MemoryBroker
+	topic    string
+	handler  Handler
+	messages chan *Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewMemoryBroker returns a broker which buffers up to bufferSize messages for each
+// subscription, messages which are not acknowledged are delivered again after the delay
+func NewMemoryBroker(bufferSize int, redeliveryDelay time.Duration) *MemoryBroker {
+	return &MemoryBroker{
+		bufferSize:      bufferSize,
+		redeliveryDelay: redeliveryDelay,
+		subscriptions:   map[string]map[*memorySubscription]bool{},
+	}
+}
+
+// Publish delivers the message to the subscriptions of its topic, the message is not
+// delivered to any of them when the buffer of one is full so that it can be published
+// again without duplicates. Messages without an ID get one.
+func (b *MemoryBroker) Publish(msg *Message) error {
+	// the publishers are serialized so that the buffers checked can't be filled by another one
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for sub := range b.subscriptions[msg.Topic] {
+		if len(sub.messages) == cap(sub.messages) {
+			return fmt.Errorf("subscription buffer of topic %s is full", msg.Topic)
+		}
+	}
+
+	if len(msg.ID) == 0 {
+		msg.ID = string(uuid.NewUUID())
+	}
+	for sub := range b.subscriptions[msg.Topic] {
+		sub.messages <- msg
+	}
+	return nil
+}
+
+// Subscribe calls the handler for each message of the topic, one message at a time
+// and in the order they were published
+func (b *MemoryBroker) Subscribe(topic string, handler Handler) (Subscription, error) {
+	sub := &memorySubscription{
+		broker:   b,
+		topic:    topic,
+		handler:  handler,
+		messages: make(chan *Message, b.bufferSize),
+		done:     make(chan struct{}),
+	}
+
+	b.lock.Lock()
+	if _, ok := b.subscriptions[topic]; !ok {
+		b.subscriptions[topic] = map[*memorySubscription]bool{}
+	}
+	b.subscriptions[topic][sub] = true
+	b.lock.Unlock()
+
+	go sub.run()
+	return sub, nil
+}
+
+func (s *memorySubscription) run() {
+	for {
+		select {
+		case msg := <-s.messages:
+			s.deliver(msg)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deliver calls the handler until the message is acknowledged or the subscription is closed
+func (s *memorySubscription) deliver(msg *Message) {
+	for {
+		if err := s.handler(msg); err == nil {
+			return
+		}
+
+		select {
+		case <-time.After(s.broker.redeliveryDelay):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Unsubscribe stops the delivery of messages, buffered messages are dropped
+func (s *memorySubscription) Unsubscribe() error {
+	s.once.Do(func() {
+		s.broker.lock.Lock()
+		delete(s.broker.subscriptions[s.topic], s)
+		if len(s.broker.subscriptions[s.topic]) == 0 {
+			delete(s.broker.subscriptions, s.topic)
+		}
+		s.broker.lock.Unlock()
+
+		close(s.done)
+	})
+	return nil
+}
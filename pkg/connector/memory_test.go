@@ -0,0 +1,53 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_MemoryBroker_PublishToNoneWhenFull(t *testing.T) {
+	broker := NewMemoryBroker(1, time.Millisecond)
+
+	blocked := make(chan struct{})
+	defer close(blocked)
+	received := make(chan *Message, 10)
+	slow, _ := broker.Subscribe("orders.created", func(msg *Message) error {
+		<-blocked
+		return nil
+	})
+	defer slow.Unsubscribe()
+	fast, _ := broker.Subscribe("orders.created", func(msg *Message) error {
+		received <- msg
+		return nil
+	})
+	defer fast.Unsubscribe()
+
+	// the slow subscription handles the first message and buffers the second
+	broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-1")})
+	err := broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-2")})
+	for n := 0; n < 200 && err != nil; n++ {
+		time.Sleep(time.Millisecond * 5)
+		err = broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-2")})
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := broker.Publish(&Message{Topic: "orders.created", Body: []byte("order-3")}); err == nil {
+		t.Fatalf("expected an error when a buffer is full")
+	}
+
+	bodies := []string{}
+	for n := 0; n < 200 && len(bodies) < 2; n++ {
+		select {
+		case msg := <-received:
+			bodies = append(bodies, string(msg.Body))
+		case <-time.After(time.Millisecond * 5):
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	if len(received) != 0 || !reflect.DeepEqual(bodies, []string{"order-1", "order-2"}) {
+		t.Errorf("expected the message to be delivered to none of the subscriptions, got: %v and %d more", bodies, len(received))
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"fmt"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	glog "k8s.io/klog"
+)
+
+const (
+	// MetricTypeCPU scales a function on the average CPU utilization of its pods
+	MetricTypeCPU = "cpu"
+	// MetricTypeMemory scales a function on the average memory utilization of its pods
+	MetricTypeMemory = "memory"
+)
+
+// newHorizontalPodAutoscaler creates a new HorizontalPodAutoscaler for a Function resource
+// that has autoscaling configured. It also sets the appropriate OwnerReferences on the
+// resource so handleObject can discover the Function resource that 'owns' it.
+func newHorizontalPodAutoscaler(function *faasv1.Function) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	autoscaling := function.Spec.Autoscaling
+
+	var resourceName corev1.ResourceName
+	switch autoscaling.MetricType {
+	case MetricTypeCPU:
+		resourceName = corev1.ResourceCPU
+	case MetricTypeMemory:
+		resourceName = corev1.ResourceMemory
+	default:
+		return nil, fmt.Errorf("autoscaling metric type must be %s or %s, got: %q",
+			MetricTypeCPU, MetricTypeMemory, autoscaling.MetricType)
+	}
+
+	if autoscaling.TargetUtilization <= 0 {
+		return nil, fmt.Errorf("autoscaling target utilization must be greater than zero")
+	}
+
+	if autoscaling.MaxReplicas <= 0 {
+		return nil, fmt.Errorf("autoscaling max replicas must be greater than zero")
+	}
+
+	if autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		return nil, fmt.Errorf("autoscaling min replicas must not be greater than max replicas")
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      function.Spec.Name,
+			Namespace: function.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       function.Spec.Name,
+			},
+			MinReplicas: autoscaling.MinReplicas,
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ResourceMetricSourceType,
+					Resource: &autoscalingv2.ResourceMetricSource{
+						Name: resourceName,
+						Target: autoscalingv2.MetricTarget{
+							Type:               autoscalingv2.UtilizationMetricType,
+							AverageUtilization: int32p(autoscaling.TargetUtilization),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// syncHorizontalPodAutoscaler creates or updates the HorizontalPodAutoscaler of a Function
+// that has autoscaling configured and deletes it when autoscaling is removed from the Function
+func (c *Controller) syncHorizontalPodAutoscaler(function *faasv1.Function) error {
+	hpaClient := c.kubeclientset.AutoscalingV2beta2().HorizontalPodAutoscalers(function.Namespace)
+
+	existing, err := c.hpasLister.HorizontalPodAutoscalers(function.Namespace).Get(function.Spec.Name)
+	if errors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+
+	if function.Spec.Autoscaling == nil {
+		if existing != nil && metav1.IsControlledBy(existing, function) {
+			glog.Infof("Deleting HorizontalPodAutoscaler for '%s'", function.Spec.Name)
+			err := hpaClient.Delete(function.Spec.Name, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	hpa, err := newHorizontalPodAutoscaler(function)
+	if err != nil {
+		// the spec is invalid until the Function is updated, so the
+		// error is recorded as an event instead of requeuing the item
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return nil
+	}
+
+	if existing == nil {
+		glog.Infof("Creating HorizontalPodAutoscaler for '%s'", function.Spec.Name)
+		_, err := hpaClient.Create(hpa)
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if equality.Semantic.DeepEqual(existing.Spec, hpa.Spec) {
+		return nil
+	}
+
+	glog.Infof("Updating HorizontalPodAutoscaler for '%s'", function.Spec.Name)
+	// the objects of the lister are shared with the informer so the update is made on a copy
+	updated := existing.DeepCopy()
+	updated.Spec = hpa.Spec
+	_, err = hpaClient.Update(updated)
+	return err
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newAutoscalingFunction(autoscaling *faasv1.FunctionAutoscaling) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas-fn",
+			UID:       "nodeinfo-uid",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        "nodeinfo",
+			Image:       "functions/nodeinfo",
+			Autoscaling: autoscaling,
+		},
+	}
+}
+
+func Test_newHorizontalPodAutoscaler(t *testing.T) {
+	function := newAutoscalingFunction(&faasv1.FunctionAutoscaling{
+		MetricType:        MetricTypeMemory,
+		TargetUtilization: 70,
+		MinReplicas:       int32p(2),
+		MaxReplicas:       5,
+	})
+
+	hpa, err := newHorizontalPodAutoscaler(function)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hpa.Spec.ScaleTargetRef.Kind != "Deployment" || hpa.Spec.ScaleTargetRef.Name != "nodeinfo" {
+		t.Errorf("expected scale target Deployment nodeinfo, got: %s %s",
+			hpa.Spec.ScaleTargetRef.Kind, hpa.Spec.ScaleTargetRef.Name)
+	}
+
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 5 {
+		t.Errorf("expected replicas between 2 and 5, got: %d and %d", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+
+	if len(hpa.Spec.Metrics) != 1 {
+		t.Fatalf("expected one metric, got: %d", len(hpa.Spec.Metrics))
+	}
+
+	resource := hpa.Spec.Metrics[0].Resource
+	if resource.Name != corev1.ResourceMemory {
+		t.Errorf("expected metric %s, got: %s", corev1.ResourceMemory, resource.Name)
+	}
+
+	if resource.Target.Type != autoscalingv2.UtilizationMetricType || *resource.Target.AverageUtilization != 70 {
+		t.Errorf("expected target utilization of 70, got: %s %d", resource.Target.Type, *resource.Target.AverageUtilization)
+	}
+
+	if !metav1.IsControlledBy(hpa, function) {
+		t.Errorf("expected HPA to be controlled by the function")
+	}
+}
+
+func Test_newHorizontalPodAutoscaler_InvalidSpec(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		autoscaling *faasv1.FunctionAutoscaling
+	}{
+		{"unknown metric type", &faasv1.FunctionAutoscaling{MetricType: "rps", TargetUtilization: 50, MaxReplicas: 5}},
+		{"missing target utilization", &faasv1.FunctionAutoscaling{MetricType: MetricTypeCPU, MaxReplicas: 5}},
+		{"missing max replicas", &faasv1.FunctionAutoscaling{MetricType: MetricTypeCPU, TargetUtilization: 50}},
+		{"min greater than max replicas", &faasv1.FunctionAutoscaling{MetricType: MetricTypeCPU, TargetUtilization: 50, MinReplicas: int32p(6), MaxReplicas: 5}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if _, err := newHorizontalPodAutoscaler(newAutoscalingFunction(s.autoscaling)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func Test_syncHorizontalPodAutoscaler(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	c, kubeInformerFactory := newTestController(kube)
+	hpaClient := kube.AutoscalingV2beta2().HorizontalPodAutoscalers("openfaas-fn")
+	hpaIndexer := kubeInformerFactory.Autoscaling().V2beta2().HorizontalPodAutoscalers().Informer().GetIndexer()
+
+	function := newAutoscalingFunction(&faasv1.FunctionAutoscaling{
+		MetricType:        MetricTypeCPU,
+		TargetUtilization: 50,
+		MaxReplicas:       5,
+	})
+
+	if err := c.syncHorizontalPodAutoscaler(function); err != nil {
+		t.Fatalf("unexpected error creating HPA: %v", err)
+	}
+
+	created, err := hpaClient.Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected HPA to be created: %v", err)
+	}
+	hpaIndexer.Add(created)
+
+	function.Spec.Autoscaling.MaxReplicas = 10
+	if err := c.syncHorizontalPodAutoscaler(function); err != nil {
+		t.Fatalf("unexpected error updating HPA: %v", err)
+	}
+
+	hpa, err := hpaClient.Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected HPA to exist: %v", err)
+	}
+	if hpa.Spec.MaxReplicas != 10 {
+		t.Errorf("expected HPA max replicas to be updated to 10, got: %d", hpa.Spec.MaxReplicas)
+	}
+	if created.Spec.MaxReplicas != 5 {
+		t.Errorf("expected the HPA of the lister to be copied before the update")
+	}
+	hpaIndexer.Update(hpa)
+
+	function.Spec.Autoscaling = nil
+	if err := c.syncHorizontalPodAutoscaler(function); err != nil {
+		t.Fatalf("unexpected error deleting HPA: %v", err)
+	}
+
+	if _, err := hpaClient.Get("nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected HPA to be deleted")
+	}
+}
+
+func Test_syncHorizontalPodAutoscaler_NotOwned(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"}}
+	kube := fake.NewSimpleClientset(hpa)
+	c, kubeInformerFactory := newTestController(kube)
+	kubeInformerFactory.Autoscaling().V2beta2().HorizontalPodAutoscalers().Informer().GetIndexer().Add(hpa)
+
+	function := newAutoscalingFunction(&faasv1.FunctionAutoscaling{
+		MetricType:        MetricTypeCPU,
+		TargetUtilization: 50,
+		MaxReplicas:       5,
+	})
+
+	if err := c.syncHorizontalPodAutoscaler(function); err == nil {
+		t.Errorf("expected an error for an HPA which isn't owned by the function")
+	}
+}
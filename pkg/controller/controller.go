@@ -4,10 +4,15 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -17,6 +22,10 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v2beta2"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -39,6 +48,9 @@ const (
 	// ErrResourceExists is used as part of the Event 'reason' when a Function fails
 	// to sync due to a Deployment of the same name already existing.
 	ErrResourceExists = "ErrResourceExists"
+	// ErrInvalidSpec is used as part of the Event 'reason' when a Function fails
+	// to sync due to an invalid configuration in its spec.
+	ErrInvalidSpec = "ErrInvalidSpec"
 
 	// MessageResourceExists is the message used for Events when a resource
 	// fails to sync due to a Deployment already existing
@@ -55,10 +67,18 @@ type Controller struct {
 	// faasclientset is a clientset for our own API group
 	faasclientset clientset.Interface
 
-	deploymentsLister appslisters.DeploymentLister
-	deploymentsSynced cache.InformerSynced
-	functionsLister   listers.FunctionLister
-	functionsSynced   cache.InformerSynced
+	deploymentsLister     appslisters.DeploymentLister
+	deploymentsSynced     cache.InformerSynced
+	functionsLister       listers.FunctionLister
+	functionsSynced       cache.InformerSynced
+	hpasLister            autoscalinglisters.HorizontalPodAutoscalerLister
+	hpasSynced            cache.InformerSynced
+	pdbsLister            policylisters.PodDisruptionBudgetLister
+	pdbsSynced            cache.InformerSynced
+	networkPoliciesLister networkinglisters.NetworkPolicyLister
+	networkPoliciesSynced cache.InformerSynced
+	jobsLister            batchlisters.JobLister
+	jobsSynced            cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -72,6 +92,10 @@ type Controller struct {
 
 	// OpenFaaS function factory
 	factory FunctionFactory
+
+	// networkPolicyMode is the default network policy of functions,
+	// either NetworkPolicyOpen or NetworkPolicyGatewayOnly
+	networkPolicyMode string
 }
 
 // NewController returns a new OpenFaaS controller
@@ -80,10 +104,15 @@ func NewController(
 	faasclientset clientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	faasInformerFactory informers.SharedInformerFactory,
-	factory FunctionFactory) *Controller {
+	factory FunctionFactory,
+	networkPolicyMode string) *Controller {
 
-	// obtain references to shared index informers for the Deployment and Function types
+	// obtain references to shared index informers for the Function type and the resources it owns
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	hpaInformer := kubeInformerFactory.Autoscaling().V2beta2().HorizontalPodAutoscalers()
+	pdbInformer := kubeInformerFactory.Policy().V1beta1().PodDisruptionBudgets()
+	networkPolicyInformer := kubeInformerFactory.Networking().V1().NetworkPolicies()
+	jobInformer := kubeInformerFactory.Batch().V1().Jobs()
 	faasInformer := faasInformerFactory.Openfaas().V1().Functions()
 
 	// Create event broadcaster
@@ -97,15 +126,24 @@ func NewController(
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
 	controller := &Controller{
-		kubeclientset:     kubeclientset,
-		faasclientset:     faasclientset,
-		deploymentsLister: deploymentInformer.Lister(),
-		deploymentsSynced: deploymentInformer.Informer().HasSynced,
-		functionsLister:   faasInformer.Lister(),
-		functionsSynced:   faasInformer.Informer().HasSynced,
-		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
-		recorder:          recorder,
-		factory:           factory,
+		kubeclientset:         kubeclientset,
+		faasclientset:         faasclientset,
+		deploymentsLister:     deploymentInformer.Lister(),
+		deploymentsSynced:     deploymentInformer.Informer().HasSynced,
+		functionsLister:       faasInformer.Lister(),
+		functionsSynced:       faasInformer.Informer().HasSynced,
+		hpasLister:            hpaInformer.Lister(),
+		hpasSynced:            hpaInformer.Informer().HasSynced,
+		pdbsLister:            pdbInformer.Lister(),
+		pdbsSynced:            pdbInformer.Informer().HasSynced,
+		networkPoliciesLister: networkPolicyInformer.Lister(),
+		networkPoliciesSynced: networkPolicyInformer.Informer().HasSynced,
+		jobsLister:            jobInformer.Lister(),
+		jobsSynced:            jobInformer.Informer().HasSynced,
+		workqueue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
+		recorder:              recorder,
+		factory:               factory,
+		networkPolicyMode:     networkPolicyMode,
 	}
 
 	glog.Info("Setting up event handlers")
@@ -116,10 +154,67 @@ func NewController(
 	faasInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueFunction,
 		UpdateFunc: func(old, new interface{}) {
+			// the generation changes with the spec, the updates of the status such as
+			// the last schedule time of the scheduler don't need a sync. The periodic
+			// resyncs keep the resource version and restore the changed Deployments.
+			oldFunction := old.(*faasv1.Function)
+			newFunction := new.(*faasv1.Function)
+			if oldFunction.ResourceVersion != newFunction.ResourceVersion &&
+				oldFunction.Generation == newFunction.Generation {
+				return
+			}
 			controller.enqueueFunction(new)
 		},
 	})
 
+	// Set up an event handler for when the HorizontalPodAutoscaler of a Function is changed
+	// or deleted, the status is updated by Kubernetes on every scaling decision so only the
+	// changes of the spec enqueue the owning Function
+	hpaInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			oldHPA := old.(*autoscalingv2.HorizontalPodAutoscaler)
+			newHPA := new.(*autoscalingv2.HorizontalPodAutoscaler)
+			if equality.Semantic.DeepEqual(oldHPA.Spec, newHPA.Spec) &&
+				equality.Semantic.DeepEqual(oldHPA.OwnerReferences, newHPA.OwnerReferences) {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	// Set up an event handler for when the PodDisruptionBudget of a Function is changed or
+	// deleted, the status follows the pods of the Function so it is ignored as for the HPA
+	pdbInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			oldPDB := old.(*policyv1beta1.PodDisruptionBudget)
+			newPDB := new.(*policyv1beta1.PodDisruptionBudget)
+			if equality.Semantic.DeepEqual(oldPDB.Spec, newPDB.Spec) &&
+				equality.Semantic.DeepEqual(oldPDB.OwnerReferences, newPDB.OwnerReferences) {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	// Set up an event handler for when the NetworkPolicy of a Function is changed or deleted
+	networkPolicyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			oldPolicy := old.(*networkingv1.NetworkPolicy)
+			newPolicy := new.(*networkingv1.NetworkPolicy)
+			if equality.Semantic.DeepEqual(oldPolicy.Spec, newPolicy.Spec) &&
+				equality.Semantic.DeepEqual(oldPolicy.OwnerReferences, newPolicy.OwnerReferences) {
+				return
+			}
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
 	// Set up an event handler for when functions related resources like pods, deployments, replica sets
 	// can't be materialized. This logs abnormal events like ImagePullBackOff, back-off restarting failed container,
 	// failed to start container, oci runtime errors, etc
@@ -152,20 +247,29 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	// Start the informer factories to begin populating the informer caches
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced, c.hpasSynced, c.pdbsSynced, c.networkPoliciesSynced, c.jobsSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
 	glog.Info("Starting workers")
 	// Launch two workers to process Function resources
+	wg := sync.WaitGroup{}
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
 	}
 
 	glog.Info("Started workers")
 	<-stopCh
 	glog.Info("Shutting down workers")
 
+	// the workers process the items left in the queue and return
+	c.workqueue.ShutDown()
+	wg.Wait()
+
 	return nil
 }
 
@@ -241,6 +345,16 @@ func (c *Controller) syncHandler(key string) error {
 		return nil
 	}
 
+	switch function.Spec.Mode {
+	case "", FunctionModeService:
+	case FunctionModeJob:
+		return c.syncJobFunction(function)
+	default:
+		msg := fmt.Sprintf("mode must be %s or %s, got: %q", FunctionModeService, FunctionModeJob, function.Spec.Mode)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, msg)
+		return nil
+	}
+
 	// Get the deployment with the name specified in Function.spec
 	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(deploymentName)
 	// If the resource doesn't exist, we'll create it
@@ -326,6 +440,18 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	if err := c.syncHorizontalPodAutoscaler(function); err != nil {
+		return err
+	}
+
+	if err := c.syncPodDisruptionBudget(function); err != nil {
+		return err
+	}
+
+	if err := c.syncNetworkPolicy(function); err != nil {
+		return err
+	}
+
 	c.recorder.Event(function, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	return nil
 }
@@ -401,18 +527,7 @@ func (c *Controller) getSecrets(namespace string, secretNames []string) (map[str
 // getReplicas returns the desired number of replicas for a function taking into account
 // the min replicas label, HPA, the OF autoscaler and scaled to zero deployments
 func getReplicas(function *faasv1.Function, deployment *appsv1.Deployment) *int32 {
-	var minReplicas *int32
-
-	// extract min replicas from label if specified
-	if function != nil && function.Spec.Labels != nil {
-		lb := *function.Spec.Labels
-		if value, exists := lb[LabelMinReplicas]; exists {
-			r, err := strconv.Atoi(value)
-			if err == nil && r > 0 {
-				minReplicas = int32p(int32(r))
-			}
-		}
-	}
+	minReplicas := getMinReplicas(function)
 
 	// extract current deployment replicas if specified
 	var deploymentReplicas *int32
@@ -420,6 +535,15 @@ func getReplicas(function *faasv1.Function, deployment *appsv1.Deployment) *int3
 		deploymentReplicas = deployment.Spec.Replicas
 	}
 
+	// do not override replicas set by the HPA of a function with autoscaling
+	// configured, new deployments start with the HPA min replicas
+	if function != nil && function.Spec.Autoscaling != nil {
+		if deploymentReplicas != nil {
+			return deploymentReplicas
+		}
+		return function.Spec.Autoscaling.MinReplicas
+	}
+
 	// do not set replicas if min replicas is not set
 	// and current deployment has no replicas count
 	if minReplicas == nil && deploymentReplicas == nil {
@@ -450,3 +574,19 @@ func getReplicas(function *faasv1.Function, deployment *appsv1.Deployment) *int3
 
 	return minReplicas
 }
+
+// getMinReplicas returns the value of the min replicas label or nil when it's not set
+func getMinReplicas(function *faasv1.Function) *int32 {
+	if function == nil || function.Spec.Labels == nil {
+		return nil
+	}
+
+	if value, exists := (*function.Spec.Labels)[LabelMinReplicas]; exists {
+		r, err := strconv.Atoi(value)
+		if err == nil && r > 0 {
+			return int32p(int32(r))
+		}
+	}
+
+	return nil
+}
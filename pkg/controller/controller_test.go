@@ -0,0 +1,24 @@
+package controller
+
+import (
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestController returns a controller whose listers read from the informers of the
+// factory, the tests add the objects to the indexers of the informers as the watch would
+func newTestController(kube *fake.Clientset) (*Controller, kubeinformers.SharedInformerFactory) {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+
+	return &Controller{
+		kubeclientset:         kube,
+		deploymentsLister:     kubeInformerFactory.Apps().V1().Deployments().Lister(),
+		hpasLister:            kubeInformerFactory.Autoscaling().V2beta2().HorizontalPodAutoscalers().Lister(),
+		pdbsLister:            kubeInformerFactory.Policy().V1beta1().PodDisruptionBudgets().Lister(),
+		networkPoliciesLister: kubeInformerFactory.Networking().V1().NetworkPolicies().Lister(),
+		jobsLister:            kubeInformerFactory.Batch().V1().Jobs().Lister(),
+		recorder:              record.NewFakeRecorder(10),
+		networkPolicyMode:     NetworkPolicyOpen,
+	}, kubeInformerFactory
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"fmt"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	glog "k8s.io/klog"
+)
+
+// needsPodDisruptionBudget returns true when the Function has an explicit disruption
+// setting or more than one min replica, in which case the pods of the function should
+// not be evicted all at once
+func needsPodDisruptionBudget(function *faasv1.Function) bool {
+	if function.Spec.Disruption != nil {
+		return true
+	}
+
+	minReplicas := getMinReplicas(function)
+	return minReplicas != nil && *minReplicas > 1
+}
+
+// newPodDisruptionBudget creates a new PodDisruptionBudget for a Function resource using the same
+// selector as the function Service. Functions without an explicit disruption setting allow one pod
+// to be unavailable at a time. It also sets the appropriate OwnerReferences on the resource so
+// handleObject can discover the Function resource that 'owns' it.
+func newPodDisruptionBudget(function *faasv1.Function) (*policyv1beta1.PodDisruptionBudget, error) {
+	spec := policyv1beta1.PodDisruptionBudgetSpec{
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"faas_function": function.Spec.Name},
+		},
+	}
+
+	if disruption := function.Spec.Disruption; disruption != nil {
+		if disruption.MinAvailable != nil && disruption.MaxUnavailable != nil {
+			return nil, fmt.Errorf("disruption minAvailable and maxUnavailable cannot be set at the same time")
+		}
+		if disruption.MinAvailable == nil && disruption.MaxUnavailable == nil {
+			return nil, fmt.Errorf("disruption requires minAvailable or maxUnavailable to be set")
+		}
+
+		spec.MinAvailable = disruption.MinAvailable
+		spec.MaxUnavailable = disruption.MaxUnavailable
+	} else {
+		spec.MaxUnavailable = &intstr.IntOrString{
+			Type:   intstr.Int,
+			IntVal: int32(1),
+		}
+	}
+
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      function.Spec.Name,
+			Namespace: function.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// syncPodDisruptionBudget creates or updates the PodDisruptionBudget of a Function
+// and deletes it when the Function no longer needs one
+func (c *Controller) syncPodDisruptionBudget(function *faasv1.Function) error {
+	pdbClient := c.kubeclientset.PolicyV1beta1().PodDisruptionBudgets(function.Namespace)
+
+	existing, err := c.pdbsLister.PodDisruptionBudgets(function.Namespace).Get(function.Spec.Name)
+	if errors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+
+	if !needsPodDisruptionBudget(function) {
+		if existing != nil && metav1.IsControlledBy(existing, function) {
+			glog.Infof("Deleting PodDisruptionBudget for '%s'", function.Spec.Name)
+			err := pdbClient.Delete(function.Spec.Name, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pdb, err := newPodDisruptionBudget(function)
+	if err != nil {
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return nil
+	}
+
+	if existing == nil {
+		glog.Infof("Creating PodDisruptionBudget for '%s'", function.Spec.Name)
+		_, err := pdbClient.Create(pdb)
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if equality.Semantic.DeepEqual(existing.Spec, pdb.Spec) {
+		return nil
+	}
+
+	// the spec of a PodDisruptionBudget is immutable before Kubernetes 1.15
+	// so it's replaced instead of updated
+	glog.Infof("Replacing PodDisruptionBudget for '%s'", function.Spec.Name)
+	if err := pdbClient.Delete(function.Spec.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	_, err = pdbClient.Create(pdb)
+	return err
+}
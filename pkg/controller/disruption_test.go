@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDisruptionFunction(minReplicas string, disruption *faasv1.FunctionDisruption) *faasv1.Function {
+	labels := map[string]string{}
+	if len(minReplicas) > 0 {
+		labels[LabelMinReplicas] = minReplicas
+	}
+
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas-fn",
+			UID:       "nodeinfo-uid",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:       "nodeinfo",
+			Image:      "functions/nodeinfo",
+			Labels:     &labels,
+			Disruption: disruption,
+		},
+	}
+}
+
+func Test_needsPodDisruptionBudget(t *testing.T) {
+	minAvailable := intstr.FromInt(1)
+
+	scenarios := []struct {
+		name     string
+		function *faasv1.Function
+		expected bool
+	}{
+		{"without min replicas", newDisruptionFunction("", nil), false},
+		{"with one min replica", newDisruptionFunction("1", nil), false},
+		{"with two min replicas", newDisruptionFunction("2", nil), true},
+		{"with disruption setting", newDisruptionFunction("", &faasv1.FunctionDisruption{MinAvailable: &minAvailable}), true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if value := needsPodDisruptionBudget(s.function); value != s.expected {
+				t.Errorf("expected %v, got %v", s.expected, value)
+			}
+		})
+	}
+}
+
+func Test_newPodDisruptionBudget(t *testing.T) {
+	t.Run("defaults to one unavailable pod", func(t *testing.T) {
+		pdb, err := newPodDisruptionBudget(newDisruptionFunction("3", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if pdb.Spec.MaxUnavailable == nil || pdb.Spec.MaxUnavailable.IntValue() != 1 {
+			t.Errorf("expected maxUnavailable of 1, got: %v", pdb.Spec.MaxUnavailable)
+		}
+		if pdb.Spec.MinAvailable != nil {
+			t.Errorf("expected minAvailable to be nil, got: %v", pdb.Spec.MinAvailable)
+		}
+		if pdb.Spec.Selector.MatchLabels["faas_function"] != "nodeinfo" {
+			t.Errorf("expected faas_function selector, got: %v", pdb.Spec.Selector.MatchLabels)
+		}
+	})
+
+	t.Run("uses the disruption setting", func(t *testing.T) {
+		minAvailable := intstr.FromString("50%")
+		pdb, err := newPodDisruptionBudget(newDisruptionFunction("", &faasv1.FunctionDisruption{MinAvailable: &minAvailable}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.String() != "50%" {
+			t.Errorf("expected minAvailable of 50%%, got: %v", pdb.Spec.MinAvailable)
+		}
+		if pdb.Spec.MaxUnavailable != nil {
+			t.Errorf("expected maxUnavailable to be nil, got: %v", pdb.Spec.MaxUnavailable)
+		}
+	})
+
+	t.Run("rejects both disruption settings", func(t *testing.T) {
+		value := intstr.FromInt(1)
+		disruption := &faasv1.FunctionDisruption{MinAvailable: &value, MaxUnavailable: &value}
+		if _, err := newPodDisruptionBudget(newDisruptionFunction("", disruption)); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func Test_syncPodDisruptionBudget(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	c, kubeInformerFactory := newTestController(kube)
+	pdbClient := kube.PolicyV1beta1().PodDisruptionBudgets("openfaas-fn")
+	pdbIndexer := kubeInformerFactory.Policy().V1beta1().PodDisruptionBudgets().Informer().GetIndexer()
+
+	function := newDisruptionFunction("2", nil)
+	if err := c.syncPodDisruptionBudget(function); err != nil {
+		t.Fatalf("unexpected error creating PDB: %v", err)
+	}
+
+	created, err := pdbClient.Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected PDB to be created: %v", err)
+	}
+	pdbIndexer.Add(created)
+
+	maxUnavailable := intstr.FromString("25%")
+	function.Spec.Disruption = &faasv1.FunctionDisruption{MaxUnavailable: &maxUnavailable}
+	if err := c.syncPodDisruptionBudget(function); err != nil {
+		t.Fatalf("unexpected error updating PDB: %v", err)
+	}
+
+	pdb, err := pdbClient.Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected PDB to exist: %v", err)
+	}
+	if pdb.Spec.MaxUnavailable.String() != "25%" {
+		t.Errorf("expected maxUnavailable to be updated to 25%%, got: %v", pdb.Spec.MaxUnavailable)
+	}
+	pdbIndexer.Update(pdb)
+
+	function.Spec.Disruption = nil
+	(*function.Spec.Labels)[LabelMinReplicas] = "1"
+	if err := c.syncPodDisruptionBudget(function); err != nil {
+		t.Fatalf("unexpected error deleting PDB: %v", err)
+	}
+
+	if _, err := pdbClient.Get("nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected PDB to be deleted")
+	}
+}
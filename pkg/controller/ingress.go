@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	clientset "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned"
+	faasscheme "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/scheme"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	networkinginformers "k8s.io/client-go/informers/networking/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	glog "k8s.io/klog"
+)
+
+const (
+	functionIngressKind = "FunctionIngress"
+	// gatewayService is the service of the OpenFaaS gateway that receives the traffic of a FunctionIngress
+	gatewayService = "gateway"
+	gatewayPort    = 8080
+	// defaultIngressClass is the ingress class used when a FunctionIngress does not set one, it is
+	// the only class supported as the path is matched and rewritten with the nginx annotations
+	defaultIngressClass = "nginx"
+
+	ingressClassAnnotation  = "kubernetes.io/ingress.class"
+	rewriteTargetAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+
+	// MessageFunctionIngressSynced is the message used for an Event fired when a
+	// FunctionIngress is synced successfully
+	MessageFunctionIngressSynced = "FunctionIngress synced successfully"
+)
+
+// FunctionIngressController reconciles FunctionIngress resources into Ingress
+// resources that route a host and path to a function through the gateway
+type FunctionIngressController struct {
+	kubeclientset kubernetes.Interface
+	faasclientset clientset.Interface
+
+	functionIngressesLister listers.FunctionIngressLister
+	functionIngressesSynced cache.InformerSynced
+	ingressesLister         networkinglisters.IngressLister
+	ingressesSynced         cache.InformerSynced
+
+	workqueue workqueue.RateLimitingInterface
+	recorder  record.EventRecorder
+}
+
+// NewFunctionIngressController returns a new FunctionIngress controller, the informers
+// must watch the namespace of the gateway as Ingress resources can only route traffic
+// to services in their own namespace
+func NewFunctionIngressController(
+	kubeclientset kubernetes.Interface,
+	faasclientset clientset.Interface,
+	functionIngressInformer informers.FunctionIngressInformer,
+	ingressInformer networkinginformers.IngressInformer) *FunctionIngressController {
+
+	faasscheme.AddToScheme(scheme.Scheme)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.V(4).Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+
+	controller := &FunctionIngressController{
+		kubeclientset:           kubeclientset,
+		faasclientset:           faasclientset,
+		functionIngressesLister: functionIngressInformer.Lister(),
+		functionIngressesSynced: functionIngressInformer.Informer().HasSynced,
+		ingressesLister:         ingressInformer.Lister(),
+		ingressesSynced:         ingressInformer.Informer().HasSynced,
+		workqueue:               workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "FunctionIngresses"),
+		recorder:                recorder,
+	}
+
+	functionIngressInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueFunctionIngress,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueFunctionIngress(new)
+		},
+	})
+
+	// the load balancer address is set on the Ingress by the ingress controller,
+	// changes to owned Ingresses are queued to update the FunctionIngress status
+	ingressInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			controller.handleObject(new)
+		},
+		DeleteFunc: controller.handleObject,
+	})
+
+	return controller
+}
+
+// Run waits for the informer caches to sync and starts the workers.
+// It blocks until stopCh is closed and the workers have finished.
+func (c *FunctionIngressController) Run(threadiness int, stopCh <-chan struct{}) error {
+	defer runtime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	if ok := cache.WaitForCacheSync(stopCh, c.functionIngressesSynced, c.ingressesSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < threadiness; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
+	}
+
+	glog.Info("Started FunctionIngress workers")
+	<-stopCh
+	glog.Info("Shutting down FunctionIngress workers")
+
+	c.workqueue.ShutDown()
+	wg.Wait()
+
+	return nil
+}
+
+func (c *FunctionIngressController) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *FunctionIngressController) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.workqueue.Forget(obj)
+		runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+
+	if err := c.syncHandler(key); err != nil {
+		c.workqueue.AddRateLimited(key)
+		runtime.HandleError(fmt.Errorf("error syncing '%s': %s", key, err.Error()))
+		return true
+	}
+
+	c.workqueue.Forget(obj)
+	return true
+}
+
+// syncHandler creates or updates the Ingress of a FunctionIngress and
+// copies the address of the load balancer into its status
+func (c *FunctionIngressController) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	fni, err := c.functionIngressesLister.FunctionIngresses(namespace).Get(name)
+	if err != nil {
+		// the Ingress is garbage collected through its owner reference
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	desired, err := newIngress(fni)
+	if err != nil {
+		c.recorder.Event(fni, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return nil
+	}
+
+	ingressClient := c.kubeclientset.NetworkingV1beta1().Ingresses(fni.Namespace)
+
+	ingress, err := c.ingressesLister.Ingresses(fni.Namespace).Get(fni.Name)
+	if errors.IsNotFound(err) {
+		glog.Infof("Creating Ingress for FunctionIngress '%s'", key)
+		ingress, err = ingressClient.Create(desired)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(ingress, fni) {
+		msg := fmt.Sprintf(MessageResourceExists, ingress.Name)
+		c.recorder.Event(fni, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if !equality.Semantic.DeepEqual(ingress.Spec, desired.Spec) ||
+		!equality.Semantic.DeepEqual(ingress.Annotations, desired.Annotations) {
+		glog.Infof("Updating Ingress for FunctionIngress '%s'", key)
+		updated := ingress.DeepCopy()
+		updated.Spec = desired.Spec
+		updated.Annotations = desired.Annotations
+		ingress, err = ingressClient.Update(updated)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.updateStatus(fni, ingress); err != nil {
+		return err
+	}
+
+	c.recorder.Event(fni, corev1.EventTypeNormal, SuccessSynced, MessageFunctionIngressSynced)
+	return nil
+}
+
+// updateStatus sets the address of the FunctionIngress to the address of its Ingress
+func (c *FunctionIngressController) updateStatus(fni *faasv1.FunctionIngress, ingress *networkingv1beta1.Ingress) error {
+	address := ingressAddress(ingress)
+	if fni.Status.Address == address {
+		return nil
+	}
+
+	updated := fni.DeepCopy()
+	updated.Status.Address = address
+	_, err := c.faasclientset.OpenfaasV1().FunctionIngresses(fni.Namespace).UpdateStatus(updated)
+	return err
+}
+
+func (c *FunctionIngressController) enqueueFunctionIngress(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.workqueue.AddRateLimited(key)
+}
+
+// handleObject enqueues the FunctionIngress that owns an Ingress
+func (c *FunctionIngressController) handleObject(obj interface{}) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object, invalid type"))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			runtime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
+			return
+		}
+	}
+
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef == nil || ownerRef.Kind != functionIngressKind {
+		return
+	}
+
+	fni, err := c.functionIngressesLister.FunctionIngresses(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		glog.V(4).Infof("FunctionIngress '%s' deleted. Ignoring orphaned object '%s'", ownerRef.Name, object.GetName())
+		return
+	}
+
+	c.enqueueFunctionIngress(fni)
+}
+
+// newIngress creates the Ingress of a FunctionIngress, requests for the host and
+// path are rewritten to the /function/ route of the gateway. The path matches the
+// prefix with or without a trailing slash, for example /v1 and /v1/info for /v1/.
+func newIngress(fni *faasv1.FunctionIngress) (*networkingv1beta1.Ingress, error) {
+	if len(fni.Spec.Host) == 0 {
+		return nil, fmt.Errorf("host must be specified")
+	}
+	if len(fni.Spec.Function) == 0 {
+		return nil, fmt.Errorf("function must be specified")
+	}
+
+	path := fni.Spec.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must start with /, got: %q", path)
+	}
+
+	ingressClass := fni.Spec.IngressClass
+	if len(ingressClass) == 0 {
+		ingressClass = defaultIngressClass
+	}
+	if ingressClass != defaultIngressClass {
+		return nil, fmt.Errorf("ingress class must be %s, got: %q", defaultIngressClass, ingressClass)
+	}
+
+	// the last group of the path is the route of the request on the function
+	prefix := strings.TrimSuffix(path, "/")
+	ingressPath, rewriteTarget := prefix+"(/|$)(.*)", fmt.Sprintf("/function/%s/$2", fni.Spec.Function)
+	if len(prefix) == 0 {
+		ingressPath, rewriteTarget = "/(.*)", fmt.Sprintf("/function/%s/$1", fni.Spec.Function)
+	}
+
+	ingress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fni.Name,
+			Namespace: fni.Namespace,
+			Annotations: map[string]string{
+				ingressClassAnnotation:  ingressClass,
+				rewriteTargetAnnotation: rewriteTarget,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(fni, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    functionIngressKind,
+				}),
+			},
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: []networkingv1beta1.IngressRule{
+				{
+					Host: fni.Spec.Host,
+					IngressRuleValue: networkingv1beta1.IngressRuleValue{
+						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+							Paths: []networkingv1beta1.HTTPIngressPath{
+								{
+									Path: ingressPath,
+									Backend: networkingv1beta1.IngressBackend{
+										ServiceName: gatewayService,
+										ServicePort: intstr.FromInt(gatewayPort),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if fni.Spec.TLS != nil {
+		if len(fni.Spec.TLS.SecretName) == 0 {
+			return nil, fmt.Errorf("tls secret name must be specified")
+		}
+		ingress.Spec.TLS = []networkingv1beta1.IngressTLS{
+			{
+				Hosts:      []string{fni.Spec.Host},
+				SecretName: fni.Spec.TLS.SecretName,
+			},
+		}
+	}
+
+	return ingress, nil
+}
+
+// ingressAddress returns the IP address or host name of the load balancer of an Ingress
+func ingressAddress(ingress *networkingv1beta1.Ingress) string {
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if len(lb.IP) > 0 {
+			return lb.IP
+		}
+		if len(lb.Hostname) > 0 {
+			return lb.Hostname
+		}
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"regexp"
+	"testing"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestFunctionIngress(spec faasv1.FunctionIngressSpec) *faasv1.FunctionIngress {
+	return &faasv1.FunctionIngress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas",
+			UID:       "nodeinfo-uid",
+		},
+		Spec: spec,
+	}
+}
+
+func newTestFunctionIngressController(fni *faasv1.FunctionIngress, objects ...*networkingv1beta1.Ingress) *FunctionIngressController {
+	kube := fake.NewSimpleClientset()
+	faas := faasfake.NewSimpleClientset(fni)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+
+	ingressInformer := kubeInformerFactory.Networking().V1beta1().Ingresses()
+	functionIngressInformer := faasInformerFactory.Openfaas().V1().FunctionIngresses()
+
+	functionIngressInformer.Informer().GetIndexer().Add(fni)
+	for _, ingress := range objects {
+		kube.Tracker().Add(ingress)
+		ingressInformer.Informer().GetIndexer().Add(ingress)
+	}
+
+	return &FunctionIngressController{
+		kubeclientset:           kube,
+		faasclientset:           faas,
+		functionIngressesLister: functionIngressInformer.Lister(),
+		ingressesLister:         ingressInformer.Lister(),
+		recorder:                record.NewFakeRecorder(10),
+	}
+}
+
+func Test_newIngress(t *testing.T) {
+	fni := newTestFunctionIngress(faasv1.FunctionIngressSpec{
+		Host:     "nodeinfo.example.com",
+		Function: "nodeinfo",
+		Path:     "/v1/",
+		TLS:      &faasv1.FunctionIngressTLS{SecretName: "nodeinfo-cert"},
+	})
+
+	ingress, err := newIngress(fni)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ingress.Annotations[ingressClassAnnotation] != defaultIngressClass {
+		t.Errorf("expected ingress class %s, got: %s", defaultIngressClass, ingress.Annotations[ingressClassAnnotation])
+	}
+
+	if rewrite := ingress.Annotations[rewriteTargetAnnotation]; rewrite != "/function/nodeinfo/$2" {
+		t.Errorf("expected rewrite to the function route, got: %s", rewrite)
+	}
+
+	rule := ingress.Spec.Rules[0]
+	if rule.Host != "nodeinfo.example.com" {
+		t.Errorf("expected host nodeinfo.example.com, got: %s", rule.Host)
+	}
+
+	path := rule.HTTP.Paths[0]
+	if path.Path != "/v1(/|$)(.*)" {
+		t.Errorf("expected path /v1(/|$)(.*), got: %s", path.Path)
+	}
+	if path.Backend.ServiceName != gatewayService || path.Backend.ServicePort.IntValue() != gatewayPort {
+		t.Errorf("expected the gateway backend, got: %v", path.Backend)
+	}
+
+	if len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != "nodeinfo-cert" ||
+		ingress.Spec.TLS[0].Hosts[0] != "nodeinfo.example.com" {
+		t.Errorf("expected TLS for the host with secret nodeinfo-cert, got: %v", ingress.Spec.TLS)
+	}
+
+	if !metav1.IsControlledBy(ingress, fni) {
+		t.Errorf("expected the ingress to be controlled by the FunctionIngress")
+	}
+}
+
+func Test_newIngress_DefaultPath(t *testing.T) {
+	ingress, err := newIngress(newTestFunctionIngress(faasv1.FunctionIngressSpec{
+		Host:         "nodeinfo.example.com",
+		Function:     "nodeinfo",
+		IngressClass: "nginx",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path := ingress.Spec.Rules[0].HTTP.Paths[0].Path; path != "/(.*)" {
+		t.Errorf("expected path /(.*), got: %s", path)
+	}
+	if rewrite := ingress.Annotations[rewriteTargetAnnotation]; rewrite != "/function/nodeinfo/$1" {
+		t.Errorf("expected rewrite to the function route, got: %s", rewrite)
+	}
+	if len(ingress.Spec.TLS) != 0 {
+		t.Errorf("expected no TLS, got: %v", ingress.Spec.TLS)
+	}
+}
+
+func Test_newIngress_PathMatchesPrefix(t *testing.T) {
+	ingress, err := newIngress(newTestFunctionIngress(faasv1.FunctionIngressSpec{
+		Host:     "nodeinfo.example.com",
+		Function: "nodeinfo",
+		Path:     "/api",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// nginx matches the path as a case insensitive regular expression from the start of the request path
+	path := regexp.MustCompile("(?i)^" + ingress.Spec.Rules[0].HTTP.Paths[0].Path)
+	scenarios := []struct {
+		requestPath string
+		route       string
+	}{
+		{"/api", ""},
+		{"/api/", ""},
+		{"/api/info", "info"},
+	}
+	for _, s := range scenarios {
+		match := path.FindStringSubmatch(s.requestPath)
+		if match == nil || match[2] != s.route {
+			t.Errorf("expected %s to be routed to %q, got: %v", s.requestPath, s.route, match)
+		}
+	}
+	if path.MatchString("/apis") {
+		t.Errorf("expected /apis not to match the prefix /api")
+	}
+}
+
+func Test_newIngress_InvalidSpec(t *testing.T) {
+	scenarios := []struct {
+		name string
+		spec faasv1.FunctionIngressSpec
+	}{
+		{"without host", faasv1.FunctionIngressSpec{Function: "nodeinfo"}},
+		{"without function", faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com"}},
+		{"with relative path", faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo", Path: "v1"}},
+		{"with another ingress class", faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo", IngressClass: "traefik"}},
+		{"without tls secret", faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo", TLS: &faasv1.FunctionIngressTLS{}}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if _, err := newIngress(newTestFunctionIngress(s.spec)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func Test_FunctionIngressController_CreatesIngress(t *testing.T) {
+	fni := newTestFunctionIngress(faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo"})
+	c := newTestFunctionIngressController(fni)
+
+	if err := c.syncHandler("openfaas/nodeinfo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ingress, err := c.kubeclientset.NetworkingV1beta1().Ingresses("openfaas").Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the ingress to be created: %v", err)
+	}
+
+	if ingress.Spec.Rules[0].Host != "nodeinfo.example.com" {
+		t.Errorf("expected host nodeinfo.example.com, got: %s", ingress.Spec.Rules[0].Host)
+	}
+}
+
+func Test_FunctionIngressController_UpdatesIngressAndStatus(t *testing.T) {
+	fni := newTestFunctionIngress(faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo"})
+
+	existing, err := newIngress(newTestFunctionIngress(faasv1.FunctionIngressSpec{Host: "old.example.com", Function: "nodeinfo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.10"}}
+
+	c := newTestFunctionIngressController(fni, existing)
+
+	if err := c.syncHandler("openfaas/nodeinfo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ingress, err := c.kubeclientset.NetworkingV1beta1().Ingresses("openfaas").Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ingress.Spec.Rules[0].Host != "nodeinfo.example.com" {
+		t.Errorf("expected host to be updated to nodeinfo.example.com, got: %s", ingress.Spec.Rules[0].Host)
+	}
+
+	updated, err := c.faasclientset.OpenfaasV1().FunctionIngresses("openfaas").Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.Address != "10.0.0.10" {
+		t.Errorf("expected status address 10.0.0.10, got: %q", updated.Status.Address)
+	}
+}
+
+func Test_FunctionIngressController_IngressNotControlled(t *testing.T) {
+	fni := newTestFunctionIngress(faasv1.FunctionIngressSpec{Host: "nodeinfo.example.com", Function: "nodeinfo"})
+
+	existing := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas"},
+	}
+
+	c := newTestFunctionIngressController(fni, existing)
+
+	if err := c.syncHandler("openfaas/nodeinfo"); err == nil {
+		t.Fatalf("expected an error for an ingress not controlled by the FunctionIngress")
+	}
+}
+
+func Test_ingressAddress(t *testing.T) {
+	ingress := &networkingv1beta1.Ingress{}
+	if address := ingressAddress(ingress); address != "" {
+		t.Errorf("expected no address, got: %s", address)
+	}
+
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+	if address := ingressAddress(ingress); address != "lb.example.com" {
+		t.Errorf("expected lb.example.com, got: %s", address)
+	}
+}
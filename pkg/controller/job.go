@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	glog "k8s.io/klog"
+)
+
+const (
+	// FunctionModeService runs a function as a long-running Deployment
+	FunctionModeService = "service"
+	// FunctionModeJob runs a function to completion as a Job for each payload
+	FunctionModeJob = "job"
+
+	// LabelJobID identifies the Job of a function run
+	LabelJobID = "faas_job_id"
+
+	// JobPayloadKey is the key of the payload in the ConfigMap of a Job
+	JobPayloadKey  = "payload"
+	jobPayloadPath = "/var/openfaas/job"
+	jobVolumeName  = "payload"
+
+	// maxJobPrefixLength keeps the Job name within the 63 characters of a label value
+	maxJobPrefixLength = 54
+
+	// jobTTLSecondsAfterFinished is how long a finished Job and its payload are kept for the
+	// status and logs endpoints. Kubernetes deletes them when the TTLAfterFinished feature
+	// gate is on, otherwise the controller deletes them when it syncs the function.
+	jobTTLSecondsAfterFinished = 24 * 60 * 60
+)
+
+// IsJob returns true when the function runs to completion as a Job
+func IsJob(function *faasv1.Function) bool {
+	return function.Spec.Mode == FunctionModeJob
+}
+
+// JobName returns the name of the Job and payload ConfigMap of a function run
+func JobName(function *faasv1.Function, id string) string {
+	prefix := function.Spec.Name
+	if len(prefix) > maxJobPrefixLength {
+		prefix = prefix[:maxJobPrefixLength]
+	}
+	return fmt.Sprintf("%s-%s", prefix, id)
+}
+
+// NewJob creates a Job which runs the function image to completion with the payload
+// mounted from the ConfigMap of the same name. The env, secrets, resources and
+// constraints of the function are rendered as they are for its Deployment.
+func NewJob(
+	function *faasv1.Function,
+	id string,
+	existingSecrets map[string]*corev1.Secret,
+	factory FunctionFactory) *batchv1.Job {
+
+	name := JobName(function, id)
+
+	labels := makeLabels(function)
+	labels[LabelJobID] = id
+
+	resources, err := makeResources(function)
+	if err != nil {
+		glog.Warningf("Function %s resources parsing failed: %v",
+			function.Spec.Name, err)
+	}
+
+	container := corev1.Container{
+		Name:            function.Spec.Name,
+		Image:           function.Spec.Image,
+		ImagePullPolicy: corev1.PullPolicy(factory.Factory.Config.ImagePullPolicy),
+		Env:             makeEnvVars(function),
+		Resources:       *resources,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      jobVolumeName,
+				ReadOnly:  true,
+				MountPath: jobPayloadPath,
+			},
+		},
+	}
+
+	// the process of the function reads the payload from stdin as it does with the watchdog,
+	// images without a process are run with their own entrypoint and read the payload file
+	if len(function.Spec.Handler) > 0 {
+		container.Command = []string{"sh", "-c", fmt.Sprintf("exec $fprocess < %s/%s", jobPayloadPath, JobPayloadKey)}
+	}
+
+	var serviceAccount string
+	if function.Spec.Annotations != nil {
+		if val, ok := (*function.Spec.Annotations)["com.openfaas.serviceaccount"]; ok && len(val) > 0 {
+			serviceAccount = val
+		}
+	}
+
+	// the factory and secrets helpers configure the pod template of a Deployment,
+	// the template is rendered in a Deployment and then moved to the Job
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:       makeNodeSelector(function.Spec.Constraints),
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: serviceAccount,
+					Containers:         []corev1.Container{container},
+					Volumes: []corev1.Volume{
+						{
+							Name: jobVolumeName,
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: name},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	factory.ConfigureReadOnlyRootFilesystem(function, deployment)
+	factory.ConfigureContainerUserID(deployment)
+
+	if err := UpdateSecrets(function, deployment, existingSecrets); err != nil {
+		glog.Warningf("Function %s secrets update failed: %v",
+			function.Spec.Name, err)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: function.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            int32p(0),
+			TTLSecondsAfterFinished: int32p(jobTTLSecondsAfterFinished),
+			Template:                deployment.Spec.Template,
+		},
+	}
+}
+
+// NewJobPayload creates the ConfigMap holding the payload of a function run. The ConfigMap
+// is created before the Job so that its pod finds the payload, it is owned by the function
+// until SetJobPayloadOwner hands it over to the Job so that it's deleted with it.
+func NewJobPayload(function *faasv1.Function, id string, payload []byte) *corev1.ConfigMap {
+	labels := makeLabels(function)
+	labels[LabelJobID] = id
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      JobName(function, id),
+			Namespace: function.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		BinaryData: map[string][]byte{
+			JobPayloadKey: payload,
+		},
+	}
+}
+
+// SetJobPayloadOwner makes the Job the owner of the ConfigMap of its payload
+func SetJobPayloadOwner(configMap *corev1.ConfigMap, job *batchv1.Job) {
+	configMap.OwnerReferences = []metav1.OwnerReference{
+		*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job")),
+	}
+}
+
+// syncJobFunction removes the Deployment, Service, HorizontalPodAutoscaler and
+// PodDisruptionBudget of a function which was changed to the job mode and the Jobs
+// which finished more than jobTTLSecondsAfterFinished ago, the Jobs are created
+// through the jobs API
+func (c *Controller) syncJobFunction(function *faasv1.Function) error {
+	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(function.Spec.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(deployment, function) {
+		glog.Infof("Deleting deployment for job function '%s'", function.Spec.Name)
+		err := c.kubeclientset.AppsV1().Deployments(function.Namespace).Delete(function.Spec.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	services := c.kubeclientset.CoreV1().Services(function.Namespace)
+	service, err := services.Get(function.Spec.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(service, function) {
+		glog.Infof("Deleting service for job function '%s'", function.Spec.Name)
+		if err := services.Delete(function.Spec.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	hpa, err := c.hpasLister.HorizontalPodAutoscalers(function.Namespace).Get(function.Spec.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(hpa, function) {
+		glog.Infof("Deleting HorizontalPodAutoscaler for job function '%s'", function.Spec.Name)
+		err := c.kubeclientset.AutoscalingV2beta2().HorizontalPodAutoscalers(function.Namespace).Delete(function.Spec.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	pdb, err := c.pdbsLister.PodDisruptionBudgets(function.Namespace).Get(function.Spec.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(pdb, function) {
+		glog.Infof("Deleting PodDisruptionBudget for job function '%s'", function.Spec.Name)
+		err := c.kubeclientset.PolicyV1beta1().PodDisruptionBudgets(function.Namespace).Delete(function.Spec.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	if err := c.deleteFinishedJobs(function, time.Now()); err != nil {
+		return err
+	}
+
+	if err := c.syncNetworkPolicy(function); err != nil {
+		return err
+	}
+
+	c.recorder.Event(function, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	return nil
+}
+
+// deleteFinishedJobs deletes the Jobs of a function which finished more than
+// jobTTLSecondsAfterFinished ago, their pods and payload are deleted with them
+func (c *Controller) deleteFinishedJobs(function *faasv1.Function, now time.Time) error {
+	selector := labels.SelectorFromSet(labels.Set{"faas_function": function.Spec.Name})
+	jobs, err := c.jobsLister.Jobs(function.Namespace).List(selector)
+	if err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs {
+		finished, ok := jobFinishedAt(job)
+		if !ok || !metav1.IsControlledBy(job, function) || now.Sub(finished) < jobTTLSecondsAfterFinished*time.Second {
+			continue
+		}
+
+		glog.Infof("Deleting finished job %s of function '%s'", job.Name, function.Spec.Name)
+		err := c.kubeclientset.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobFinishedAt returns when a Job completed or failed
+func jobFinishedAt(job *batchv1.Job) (time.Time, bool) {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
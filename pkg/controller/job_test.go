@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-netes/k8s"
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newJobFunction() *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "resize",
+			Namespace: "openfaas-fn",
+			UID:       "resize-uid",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        "resize",
+			Image:       "functions/resize",
+			Mode:        FunctionModeJob,
+			Handler:     "convert - -resize 50% fd:1",
+			Environment: &map[string]string{"output": "png"},
+			Constraints: []string{"kubernetes.io/arch=amd64"},
+			Secrets:     []string{"s3-key"},
+			Limits:      &faasv1.FunctionResources{Memory: "128Mi"},
+		},
+	}
+}
+
+func Test_NewJob(t *testing.T) {
+	function := newJobFunction()
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{SetNonRootUser: true})
+	secrets := map[string]*corev1.Secret{
+		"s3-key": {
+			ObjectMeta: metav1.ObjectMeta{Name: "s3-key"},
+			Data:       map[string][]byte{"s3-key": []byte("secret")},
+		},
+	}
+
+	job := NewJob(function, "abc123", secrets, factory)
+
+	if job.Name != "resize-abc123" {
+		t.Errorf("expected job name resize-abc123, got: %s", job.Name)
+	}
+	if job.Labels["faas_function"] != "resize" || job.Labels[LabelJobID] != "abc123" {
+		t.Errorf("expected function and job id labels, got: %v", job.Labels)
+	}
+	if !metav1.IsControlledBy(job, function) {
+		t.Errorf("expected job to be owned by the function")
+	}
+	if *job.Spec.BackoffLimit != 0 {
+		t.Errorf("expected no retries, got backoff limit %d", *job.Spec.BackoffLimit)
+	}
+	if ttl := job.Spec.TTLSecondsAfterFinished; ttl == nil || *ttl != jobTTLSecondsAfterFinished {
+		t.Errorf("expected finished jobs to be deleted after %d seconds, got: %v", jobTTLSecondsAfterFinished, ttl)
+	}
+
+	spec := job.Spec.Template.Spec
+	if spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("expected restart policy Never, got: %s", spec.RestartPolicy)
+	}
+	if spec.NodeSelector["kubernetes.io/arch"] != "amd64" {
+		t.Errorf("expected node selector from constraints, got: %v", spec.NodeSelector)
+	}
+
+	container := spec.Containers[0]
+	if container.Image != "functions/resize" {
+		t.Errorf("expected function image, got: %s", container.Image)
+	}
+	if len(container.Command) != 3 || !strings.HasSuffix(container.Command[2], "< /var/openfaas/job/payload") {
+		t.Errorf("expected the payload to be passed on stdin, got: %v", container.Command)
+	}
+	if container.Resources.Limits.Memory().String() != "128Mi" {
+		t.Errorf("expected memory limit 128Mi, got: %s", container.Resources.Limits.Memory().String())
+	}
+	if *container.SecurityContext.RunAsUser != k8s.SecurityContextUserID {
+		t.Errorf("expected non-root user %d", k8s.SecurityContextUserID)
+	}
+
+	env := map[string]string{}
+	for _, e := range container.Env {
+		env[e.Name] = e.Value
+	}
+	if env["output"] != "png" || env["fprocess"] != function.Spec.Handler {
+		t.Errorf("expected function env and fprocess, got: %v", container.Env)
+	}
+
+	volumes := map[string]corev1.Volume{}
+	for _, v := range spec.Volumes {
+		volumes[v.Name] = v
+	}
+	if payload, ok := volumes[jobVolumeName]; !ok || payload.ConfigMap.Name != job.Name {
+		t.Errorf("expected payload volume from ConfigMap %s, got: %v", job.Name, spec.Volumes)
+	}
+	if _, ok := volumes["resize-projected-secrets"]; !ok {
+		t.Errorf("expected secrets volume, got: %v", spec.Volumes)
+	}
+}
+
+func Test_NewJobPayload(t *testing.T) {
+	function := newJobFunction()
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{})
+	job := NewJob(function, "abc123", nil, factory)
+	job.UID = "resize-abc123-uid"
+
+	configMap := NewJobPayload(function, "abc123", []byte("image"))
+
+	if configMap.Name != job.Name || string(configMap.BinaryData[JobPayloadKey]) != "image" {
+		t.Errorf("expected payload in ConfigMap %s, got: %v", job.Name, configMap)
+	}
+	if !metav1.IsControlledBy(configMap, function) {
+		t.Errorf("expected ConfigMap to be owned by the function until the job is created")
+	}
+
+	SetJobPayloadOwner(configMap, job)
+	if !metav1.IsControlledBy(configMap, job) {
+		t.Errorf("expected ConfigMap to be owned by the job")
+	}
+}
+
+func Test_JobName_Truncated(t *testing.T) {
+	function := newJobFunction()
+	function.Spec.Name = strings.Repeat("a", 63)
+
+	if name := JobName(function, "abc12345"); len(name) > 63 {
+		t.Errorf("expected job name to fit a label value, got %d characters", len(name))
+	}
+}
+
+func Test_syncJobFunction_DeletesDeployment(t *testing.T) {
+	function := newJobFunction()
+	ownerRef := *metav1.NewControllerRef(function, faasv1.SchemeGroupVersion.WithKind(faasKind))
+	meta := metav1.ObjectMeta{
+		Name:            "resize",
+		Namespace:       "openfaas-fn",
+		OwnerReferences: []metav1.OwnerReference{ownerRef},
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: meta}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: meta}
+	pdb := &policyv1beta1.PodDisruptionBudget{ObjectMeta: meta}
+
+	kube := fake.NewSimpleClientset(
+		deployment,
+		&corev1.Service{ObjectMeta: meta},
+		hpa,
+		pdb,
+	)
+	c, kubeInformerFactory := newTestController(kube)
+	kubeInformerFactory.Apps().V1().Deployments().Informer().GetIndexer().Add(deployment)
+	kubeInformerFactory.Autoscaling().V2beta2().HorizontalPodAutoscalers().Informer().GetIndexer().Add(hpa)
+	kubeInformerFactory.Policy().V1beta1().PodDisruptionBudgets().Informer().GetIndexer().Add(pdb)
+
+	if err := c.syncJobFunction(function); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := kube.AppsV1().Deployments("openfaas-fn").Get("resize", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected deployment to be deleted")
+	}
+	if _, err := kube.CoreV1().Services("openfaas-fn").Get("resize", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected service to be deleted")
+	}
+	if _, err := kube.AutoscalingV2beta2().HorizontalPodAutoscalers("openfaas-fn").Get("resize", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected HPA to be deleted")
+	}
+	if _, err := kube.PolicyV1beta1().PodDisruptionBudgets("openfaas-fn").Get("resize", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected PDB to be deleted")
+	}
+}
+
+func Test_deleteFinishedJobs(t *testing.T) {
+	function := newJobFunction()
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	newJob := func(name string, condition batchv1.JobConditionType, finished time.Time) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "openfaas-fn",
+				Labels:          map[string]string{"faas_function": "resize", LabelJobID: name},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(function, faasv1.SchemeGroupVersion.WithKind(faasKind))},
+			},
+		}
+		if len(condition) > 0 {
+			job.Status.Conditions = []batchv1.JobCondition{
+				{Type: condition, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(finished)},
+			}
+		}
+		return job
+	}
+
+	expired := now.Add(-time.Second * (jobTTLSecondsAfterFinished + 1))
+	jobs := []*batchv1.Job{
+		newJob("succeeded", batchv1.JobComplete, expired),
+		newJob("failed", batchv1.JobFailed, expired),
+		newJob("recent", batchv1.JobComplete, now.Add(-time.Hour)),
+		newJob("running", "", time.Time{}),
+	}
+
+	kube := fake.NewSimpleClientset()
+	c, kubeInformerFactory := newTestController(kube)
+	for _, job := range jobs {
+		kube.BatchV1().Jobs("openfaas-fn").Create(job)
+		kubeInformerFactory.Batch().V1().Jobs().Informer().GetIndexer().Add(job)
+	}
+
+	if err := c.deleteFinishedJobs(function, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"succeeded", "failed"} {
+		if _, err := kube.BatchV1().Jobs("openfaas-fn").Get(name, metav1.GetOptions{}); err == nil {
+			t.Errorf("expected the %s job to be deleted after the TTL", name)
+		}
+	}
+	for _, name := range []string{"recent", "running"} {
+		if _, err := kube.BatchV1().Jobs("openfaas-fn").Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the %s job to be kept: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	glog "k8s.io/klog"
+)
+
+const (
+	// NetworkPolicyOpen is the operator network policy mode that only creates a
+	// NetworkPolicy for functions with a network section in their spec
+	NetworkPolicyOpen = "open"
+	// NetworkPolicyGatewayOnly is the operator network policy mode that only allows
+	// the OpenFaaS system namespace to call functions without a network section
+	NetworkPolicyGatewayOnly = "gateway-only"
+
+	// NetworkIngressOpen allows any pod to call the function
+	NetworkIngressOpen = "open"
+	// NetworkIngressGateway only allows the gateway and the operator to call the function
+	NetworkIngressGateway = "gateway"
+)
+
+// systemNamespaceLabels selects the namespace of the gateway and the operator,
+// the label is set by the OpenFaaS namespaces.yml
+var systemNamespaceLabels = map[string]string{"role": "openfaas-system"}
+
+// needsNetworkPolicy returns true when the Function has a network section
+// or the operator restricts the ingress of all functions to the gateway
+func needsNetworkPolicy(function *faasv1.Function, mode string) bool {
+	return function.Spec.Network != nil || mode == NetworkPolicyGatewayOnly
+}
+
+// newNetworkPolicy creates a new NetworkPolicy for a Function resource from the network
+// section of its spec and the network policy mode of the operator. It also sets the
+// appropriate OwnerReferences on the resource so handleObject can discover the Function
+// resource that 'owns' it.
+func newNetworkPolicy(function *faasv1.Function, mode string) (*networkingv1.NetworkPolicy, error) {
+	network := function.Spec.Network
+	if network == nil {
+		network = &faasv1.FunctionNetwork{}
+	}
+
+	ingress := network.Ingress
+	if len(ingress) == 0 {
+		ingress = NetworkIngressOpen
+		if mode == NetworkPolicyGatewayOnly {
+			ingress = NetworkIngressGateway
+		}
+	}
+
+	spec := networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{
+			MatchLabels: map[string]string{"faas_function": function.Spec.Name},
+		},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+	}
+
+	switch ingress {
+	case NetworkIngressOpen:
+		spec.Ingress = []networkingv1.NetworkPolicyIngressRule{{}}
+	case NetworkIngressGateway:
+		spec.Ingress = []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: systemNamespaceLabels,
+						},
+					},
+				},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("network ingress must be %s or %s, got: %q",
+			NetworkIngressOpen, NetworkIngressGateway, ingress)
+	}
+
+	if egress := network.Egress; egress != nil {
+		rules, err := makeEgressRules(egress)
+		if err != nil {
+			return nil, err
+		}
+
+		spec.PolicyTypes = append(spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		spec.Egress = rules
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      function.Spec.Name,
+			Namespace: function.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(function, schema.GroupVersionKind{
+					Group:   faasv1.SchemeGroupVersion.Group,
+					Version: faasv1.SchemeGroupVersion.Version,
+					Kind:    faasKind,
+				}),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// makeEgressRules creates the egress rules of a function, an empty list denies all egress
+func makeEgressRules(egress *faasv1.FunctionEgress) ([]networkingv1.NetworkPolicyEgressRule, error) {
+	rules := []networkingv1.NetworkPolicyEgressRule{}
+
+	peers := []networkingv1.NetworkPolicyPeer{}
+	for _, cidr := range egress.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("network egress CIDR %q is invalid: %v", cidr, err)
+		}
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+		})
+	}
+
+	for i := range egress.Namespaces {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: egress.Namespaces[i].DeepCopy(),
+		})
+	}
+
+	if len(peers) > 0 {
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{To: peers})
+	}
+
+	if egress.DNS {
+		udp := corev1.ProtocolUDP
+		tcp := corev1.ProtocolTCP
+		port := intstr.FromInt(53)
+
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &port},
+				{Protocol: &tcp, Port: &port},
+			},
+		})
+	}
+
+	return rules, nil
+}
+
+// syncNetworkPolicy creates or updates the NetworkPolicy of a Function
+// and deletes it when the Function no longer needs one
+func (c *Controller) syncNetworkPolicy(function *faasv1.Function) error {
+	policyClient := c.kubeclientset.NetworkingV1().NetworkPolicies(function.Namespace)
+
+	existing, err := c.networkPoliciesLister.NetworkPolicies(function.Namespace).Get(function.Spec.Name)
+	if errors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+
+	if !needsNetworkPolicy(function, c.networkPolicyMode) {
+		if existing != nil && metav1.IsControlledBy(existing, function) {
+			glog.Infof("Deleting NetworkPolicy for '%s'", function.Spec.Name)
+			err := policyClient.Delete(function.Spec.Name, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	policy, err := newNetworkPolicy(function, c.networkPolicyMode)
+	if err != nil {
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		return nil
+	}
+
+	if existing == nil {
+		glog.Infof("Creating NetworkPolicy for '%s'", function.Spec.Name)
+		_, err := policyClient.Create(policy)
+		return err
+	}
+
+	if !metav1.IsControlledBy(existing, function) {
+		msg := fmt.Sprintf(MessageResourceExists, existing.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if equality.Semantic.DeepEqual(existing.Spec, policy.Spec) {
+		return nil
+	}
+
+	glog.Infof("Updating NetworkPolicy for '%s'", function.Spec.Name)
+	updated := existing.DeepCopy()
+	updated.Spec = policy.Spec
+	_, err = policyClient.Update(updated)
+	return err
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newNetworkFunction(network *faasv1.FunctionNetwork) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas-fn",
+			UID:       "nodeinfo-uid",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:    "nodeinfo",
+			Image:   "functions/nodeinfo",
+			Network: network,
+		},
+	}
+}
+
+func Test_newNetworkPolicy_GatewayOnlyMode(t *testing.T) {
+	policy, err := newNetworkPolicy(newNetworkFunction(nil), NetworkPolicyGatewayOnly)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if policy.Spec.PodSelector.MatchLabels["faas_function"] != "nodeinfo" {
+		t.Errorf("expected faas_function pod selector, got: %v", policy.Spec.PodSelector.MatchLabels)
+	}
+
+	if len(policy.Spec.PolicyTypes) != 1 || policy.Spec.PolicyTypes[0] != networkingv1.PolicyTypeIngress {
+		t.Errorf("expected only the ingress policy type, got: %v", policy.Spec.PolicyTypes)
+	}
+
+	if len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].From) != 1 {
+		t.Fatalf("expected one ingress rule with one peer, got: %v", policy.Spec.Ingress)
+	}
+
+	selector := policy.Spec.Ingress[0].From[0].NamespaceSelector
+	if selector == nil || selector.MatchLabels["role"] != "openfaas-system" {
+		t.Errorf("expected ingress from the openfaas-system namespace, got: %v", selector)
+	}
+}
+
+func Test_newNetworkPolicy_OpenIngress(t *testing.T) {
+	function := newNetworkFunction(&faasv1.FunctionNetwork{Ingress: NetworkIngressOpen})
+
+	policy, err := newNetworkPolicy(function, NetworkPolicyGatewayOnly)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].From) != 0 {
+		t.Errorf("expected a single ingress rule allowing all peers, got: %v", policy.Spec.Ingress)
+	}
+}
+
+func Test_newNetworkPolicy_Egress(t *testing.T) {
+	function := newNetworkFunction(&faasv1.FunctionNetwork{
+		Ingress: NetworkIngressGateway,
+		Egress: &faasv1.FunctionEgress{
+			CIDRs: []string{"10.0.0.0/8"},
+			Namespaces: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"role": "database"}},
+			},
+			DNS: true,
+		},
+	})
+
+	policy, err := newNetworkPolicy(function, NetworkPolicyOpen)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(policy.Spec.PolicyTypes) != 2 || policy.Spec.PolicyTypes[1] != networkingv1.PolicyTypeEgress {
+		t.Errorf("expected ingress and egress policy types, got: %v", policy.Spec.PolicyTypes)
+	}
+
+	if len(policy.Spec.Egress) != 2 {
+		t.Fatalf("expected two egress rules, got: %d", len(policy.Spec.Egress))
+	}
+
+	peers := policy.Spec.Egress[0].To
+	if len(peers) != 2 {
+		t.Fatalf("expected two egress peers, got: %d", len(peers))
+	}
+	if peers[0].IPBlock == nil || peers[0].IPBlock.CIDR != "10.0.0.0/8" {
+		t.Errorf("expected CIDR 10.0.0.0/8, got: %v", peers[0].IPBlock)
+	}
+	if peers[1].NamespaceSelector == nil || peers[1].NamespaceSelector.MatchLabels["role"] != "database" {
+		t.Errorf("expected namespace selector role=database, got: %v", peers[1].NamespaceSelector)
+	}
+
+	ports := policy.Spec.Egress[1].Ports
+	if len(ports) != 2 || *ports[0].Protocol != corev1.ProtocolUDP || ports[0].Port.IntValue() != 53 {
+		t.Errorf("expected DNS egress on port 53, got: %v", ports)
+	}
+}
+
+func Test_newNetworkPolicy_DenyAllEgress(t *testing.T) {
+	function := newNetworkFunction(&faasv1.FunctionNetwork{Egress: &faasv1.FunctionEgress{}})
+
+	policy, err := newNetworkPolicy(function, NetworkPolicyOpen)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(policy.Spec.PolicyTypes) != 2 || len(policy.Spec.Egress) != 0 {
+		t.Errorf("expected egress policy type without rules, got: %v %v", policy.Spec.PolicyTypes, policy.Spec.Egress)
+	}
+}
+
+func Test_newNetworkPolicy_InvalidSpec(t *testing.T) {
+	scenarios := []struct {
+		name    string
+		network *faasv1.FunctionNetwork
+	}{
+		{"unknown ingress", &faasv1.FunctionNetwork{Ingress: "internet"}},
+		{"invalid CIDR", &faasv1.FunctionNetwork{Egress: &faasv1.FunctionEgress{CIDRs: []string{"10.0.0.1"}}}},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if _, err := newNetworkPolicy(newNetworkFunction(s.network), NetworkPolicyOpen); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func Test_syncNetworkPolicy(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	c, kubeInformerFactory := newTestController(kube)
+	policyClient := kube.NetworkingV1().NetworkPolicies("openfaas-fn")
+	policyIndexer := kubeInformerFactory.Networking().V1().NetworkPolicies().Informer().GetIndexer()
+
+	function := newNetworkFunction(nil)
+	if err := c.syncNetworkPolicy(function); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := policyClient.Get("nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Fatalf("expected no NetworkPolicy in open mode without a network section")
+	}
+
+	function.Spec.Network = &faasv1.FunctionNetwork{Ingress: NetworkIngressGateway}
+	if err := c.syncNetworkPolicy(function); err != nil {
+		t.Fatalf("unexpected error creating NetworkPolicy: %v", err)
+	}
+
+	created, err := policyClient.Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected NetworkPolicy to be created: %v", err)
+	}
+	policyIndexer.Add(created)
+
+	function.Spec.Network = nil
+	if err := c.syncNetworkPolicy(function); err != nil {
+		t.Fatalf("unexpected error deleting NetworkPolicy: %v", err)
+	}
+
+	if _, err := policyClient.Get("nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected NetworkPolicy to be deleted")
+	}
+}
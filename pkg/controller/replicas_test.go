@@ -53,6 +53,21 @@ func Test_Replicas(t *testing.T) {
 			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(3)}},
 			int32p(3),
 		},
+		{
+			"return HPA min replicas when autoscaling is configured and deployment does not exist",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Autoscaling: &faasv1.FunctionAutoscaling{MinReplicas: int32p(3), MaxReplicas: 10}}},
+			nil,
+			int32p(3),
+		},
+		{
+			"return existing replicas when autoscaling is configured and deployment has replicas less than min label",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{
+				Labels:      &map[string]string{LabelMinReplicas: "2"},
+				Autoscaling: &faasv1.FunctionAutoscaling{MinReplicas: int32p(2), MaxReplicas: 10},
+			}},
+			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(1)}},
+			int32p(1),
+		},
 		{
 			"return zero replicas when label is present and deployment has zero replicas",
 			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: &map[string]string{LabelMinReplicas: "2"}}},
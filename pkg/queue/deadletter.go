@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// DeadLetter is a request which failed after all of its attempts
+type DeadLetter struct {
+	Request *Request `json:"request"`
+	// StatusCode is the status of the last attempt, zero when the function could not be reached
+	StatusCode int `json:"statusCode,omitempty"`
+	// Error describes the failure of the last attempt
+	Error string `json:"error,omitempty"`
+	// Attempts is the number of times the function was invoked
+	Attempts int `json:"attempts"`
+	// FailedAt is the time of the last attempt
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// DeadLetters keeps the most recent failed requests in memory
+type DeadLetters struct {
+	lock    sync.RWMutex
+	size    int
+	letters []DeadLetter
+}
+
+// NewDeadLetters returns a dead-letter list which holds up to size requests,
+// the oldest requests are dropped once the list is full
+func NewDeadLetters(size int) *DeadLetters {
+	return &DeadLetters{
+		size: size,
+	}
+}
+
+// Add appends a failed request to the list
+func (d *DeadLetters) Add(letter DeadLetter) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.letters = append(d.letters, letter)
+	if len(d.letters) > d.size {
+		d.letters = d.letters[len(d.letters)-d.size:]
+	}
+}
+
+// List returns the failed requests of a function, or of all functions when the name is empty
+func (d *DeadLetters) List(functionName string) []DeadLetter {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	letters := []DeadLetter{}
+	for _, letter := range d.letters {
+		if len(functionName) == 0 || letter.Request.Function == functionName {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
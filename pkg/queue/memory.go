@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MemoryQueue is a bounded Queue held in memory, requests
+// which have not been processed are lost on restart
+type MemoryQueue struct {
+	requests chan *Request
+
+	lock   sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewMemoryQueue returns a Queue which holds up to size requests in memory
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{
+		requests: make(chan *Request, size),
+		done:     make(chan struct{}),
+	}
+}
+
+// Enqueue adds a request to the queue or returns ErrQueueFull when it's at capacity
+func (q *MemoryQueue) Enqueue(req *Request) error {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if q.closed {
+		return errors.New("queue is closed")
+	}
+
+	select {
+	case q.requests <- req:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dequeue blocks until a request is available. Requests left in a closed
+// queue are still returned so that they can be processed before shutdown.
+func (q *MemoryQueue) Dequeue(stopCh <-chan struct{}) (*Request, bool) {
+	select {
+	case req := <-q.requests:
+		return req, true
+	default:
+	}
+
+	select {
+	case req := <-q.requests:
+		return req, true
+	case <-q.done:
+		return nil, false
+	case <-stopCh:
+		return nil, false
+	}
+}
+
+// Len returns the number of requests waiting in the queue
+func (q *MemoryQueue) Len() int {
+	return len(q.requests)
+}
+
+// Close stops accepting new requests
+func (q *MemoryQueue) Close(ctx context.Context) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	return nil
+}
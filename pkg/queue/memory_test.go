@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_MemoryQueue_EnqueueDequeue(t *testing.T) {
+	q := NewMemoryQueue(2)
+
+	if err := q.Enqueue(&Request{CallID: "call-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&Request{CallID: "call-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(&Request{CallID: "call-3"}); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got: %v", err)
+	}
+
+	req, ok := q.Dequeue(nil)
+	if !ok || req.CallID != "call-1" {
+		t.Fatalf("expected call-1, got: %v", req)
+	}
+}
+
+func Test_MemoryQueue_DrainsAfterClose(t *testing.T) {
+	q := NewMemoryQueue(2)
+	q.Enqueue(&Request{CallID: "call-1"})
+	q.Close(context.Background())
+
+	if err := q.Enqueue(&Request{CallID: "call-2"}); err == nil {
+		t.Fatalf("expected an error enqueuing to a closed queue")
+	}
+
+	if req, ok := q.Dequeue(nil); !ok || req.CallID != "call-1" {
+		t.Fatalf("expected the pending request to be dequeued, got: %v", req)
+	}
+
+	if _, ok := q.Dequeue(nil); ok {
+		t.Fatalf("expected an empty closed queue to stop the worker")
+	}
+}
+
+func Test_MemoryQueue_DequeueStops(t *testing.T) {
+	q := NewMemoryQueue(1)
+	stopCh := make(chan struct{})
+	close(stopCh)
+
+	if _, ok := q.Dequeue(stopCh); ok {
+		t.Fatalf("expected dequeue to return when stopped")
+	}
+}
+
+func Test_DeadLetters_KeepsMostRecent(t *testing.T) {
+	d := NewDeadLetters(2)
+	d.Add(DeadLetter{Request: &Request{CallID: "call-1", Function: "nodeinfo"}})
+	d.Add(DeadLetter{Request: &Request{CallID: "call-2", Function: "figlet"}})
+	d.Add(DeadLetter{Request: &Request{CallID: "call-3", Function: "nodeinfo"}})
+
+	all := d.List("")
+	if len(all) != 2 || all[0].Request.CallID != "call-2" || all[1].Request.CallID != "call-3" {
+		t.Errorf("expected call-2 and call-3, got: %v", all)
+	}
+
+	nodeinfo := d.List("nodeinfo")
+	if len(nodeinfo) != 1 || nodeinfo[0].Request.CallID != "call-3" {
+		t.Errorf("expected call-3 for nodeinfo, got: %v", nodeinfo)
+	}
+}
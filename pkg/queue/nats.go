@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	glog "k8s.io/klog"
+)
+
+const (
+	// DefaultNATSSubject is the subject used by the OpenFaaS gateway and queue-worker
+	DefaultNATSSubject = "faas-request"
+	// natsQueueGroup load balances the requests between the replicas of the operator
+	natsQueueGroup = "openfaas-operator"
+	natsBufferSize = 64
+)
+
+// NATSQueue is a Queue which publishes requests to a NATS subject, requests are
+// consumed through a queue group so that each request is processed by one worker
+type NATSQueue struct {
+	conn     *nats.Conn
+	subject  string
+	sub      *nats.Subscription
+	messages chan *nats.Msg
+	// closed is closed once the connection has been drained and closed
+	closed chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewNATSQueue connects to the NATS server at address e.g. nats://nats.openfaas:4222
+// and subscribes to the subject
+func NewNATSQueue(address, subject string) (*NATSQueue, error) {
+	closed := make(chan struct{})
+	conn, err := nats.Connect(address,
+		nats.Name(natsQueueGroup),
+		nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan *nats.Msg, natsBufferSize)
+	sub, err := conn.ChanQueueSubscribe(subject, natsQueueGroup, messages)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &NATSQueue{
+		conn:     conn,
+		subject:  subject,
+		sub:      sub,
+		messages: messages,
+		closed:   closed,
+	}, nil
+}
+
+// Enqueue publishes the request to the subject
+func (q *NATSQueue) Enqueue(req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return q.conn.Publish(q.subject, data)
+}
+
+// Dequeue blocks until a request is received from the subject. The requests received
+// before the queue was closed are still returned so that they can be processed before shutdown.
+func (q *NATSQueue) Dequeue(stopCh <-chan struct{}) (*Request, bool) {
+	for {
+		var msg *nats.Msg
+		select {
+		case msg = <-q.messages:
+		default:
+			select {
+			case msg = <-q.messages:
+			case <-q.closed:
+				return nil, false
+			case <-stopCh:
+				return nil, false
+			}
+		}
+
+		req := &Request{}
+		if err := json.Unmarshal(msg.Data, req); err != nil {
+			glog.Errorf("Discarding invalid message on %s: %v", q.subject, err)
+			continue
+		}
+		return req, true
+	}
+}
+
+// Close unsubscribes from the subject and flushes the published requests, it returns
+// once the messages in flight have been received, the drain timed out or ctx is done
+func (q *NATSQueue) Close(ctx context.Context) error {
+	var err error
+	q.closeOnce.Do(func() {
+		if err = q.conn.Drain(); err != nil {
+			return
+		}
+		select {
+		case <-q.closed:
+		case <-ctx.Done():
+			q.conn.Close()
+			err = ctx.Err()
+		}
+	})
+	return err
+}
@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrQueueFull is returned when a request can't be enqueued because the queue is at capacity
+var ErrQueueFull = errors.New("queue is full")
+
+// Request is an asynchronous invocation of a function
+type Request struct {
+	// CallID identifies the invocation in the callback and the dead-letter list
+	CallID string `json:"callId"`
+	// Function is the name of the function to invoke
+	Function string `json:"function"`
+	// Method is the HTTP method of the invocation
+	Method string `json:"method"`
+	// Path is the path after the function name e.g. /users/1
+	Path string `json:"path,omitempty"`
+	// QueryString is the raw query of the invocation without the leading ?
+	QueryString string `json:"queryString,omitempty"`
+	// Header holds the headers of the invocation
+	Header http.Header `json:"header,omitempty"`
+	// Body is the payload of the invocation
+	Body []byte `json:"body,omitempty"`
+	// CallbackURL receives the result of the invocation when set
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// EnqueuedAt is the time the request was accepted
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// Queue holds asynchronous invocations until they are processed by a worker
+type Queue interface {
+	// Enqueue adds a request to the queue
+	Enqueue(req *Request) error
+	// Dequeue blocks until a request is available, it returns false
+	// when the stop channel is closed or the queue has been closed
+	Dequeue(stopCh <-chan struct{}) (*Request, bool)
+	// Close stops accepting requests and releases the resources of the queue,
+	// it gives up waiting for the requests in flight when ctx is done
+	Close(ctx context.Context) error
+}
@@ -0,0 +1,215 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	glog "k8s.io/klog"
+)
+
+// Worker processes the requests of a Queue by invoking the functions through
+// the function proxy and posting the results to the callback URLs
+type Worker struct {
+	queue       Queue
+	invoker     http.Handler
+	client      *http.Client
+	deadLetters *DeadLetters
+	backoff     wait.Backoff
+}
+
+// result is the outcome of one attempt to invoke a function
+type result struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	duration   time.Duration
+}
+
+// NewWorker returns a Worker which invokes functions with the invoker, usually the router
+// serving /function/{name}, and retries failed invocations and callbacks with the backoff
+func NewWorker(queue Queue, invoker http.Handler, client *http.Client, deadLetters *DeadLetters, backoff wait.Backoff) *Worker {
+	return &Worker{
+		queue:       queue,
+		invoker:     invoker,
+		client:      client,
+		deadLetters: deadLetters,
+		backoff:     backoff,
+	}
+}
+
+// Run starts the given number of workers and blocks until stopCh is closed,
+// it returns once the requests being processed have completed
+func (w *Worker) Run(workers int, stopCh <-chan struct{}) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				req, ok := w.queue.Dequeue(stopCh)
+				if !ok {
+					return
+				}
+				w.process(req)
+			}
+		}()
+	}
+
+	glog.Infof("Started %d async workers", workers)
+	wg.Wait()
+	glog.Info("Shutting down async workers")
+}
+
+// process invokes the function of the request until it succeeds or the attempts
+// of the backoff are exhausted, failed requests are added to the dead-letter list
+func (w *Worker) process(req *Request) {
+	attempts := 0
+	var res *result
+
+	// the condition never returns an error, so only wait.ErrWaitTimeout is returned
+	err := wait.ExponentialBackoff(w.backoff, func() (bool, error) {
+		attempts++
+		res = w.invoke(req)
+		return !retryable(res.statusCode), nil
+	})
+
+	glog.V(2).Infof("Async invocation %s of %s returned %d after %d attempts",
+		req.CallID, req.Function, res.statusCode, attempts)
+
+	if err != nil || res.statusCode >= http.StatusInternalServerError {
+		w.deadLetters.Add(DeadLetter{
+			Request:    req,
+			StatusCode: res.statusCode,
+			Error:      string(res.body),
+			Attempts:   attempts,
+			FailedAt:   time.Now(),
+		})
+	}
+
+	if len(req.CallbackURL) > 0 {
+		if err := w.callback(req, res); err != nil {
+			glog.Errorf("Callback to %s for %s failed: %v", req.CallbackURL, req.CallID, err)
+		}
+	}
+}
+
+// invoke makes one attempt to invoke the function of the request
+func (w *Worker) invoke(req *Request) *result {
+	url := fmt.Sprintf("/function/%s%s", req.Function, req.Path)
+	if len(req.QueryString) > 0 {
+		url = url + "?" + req.QueryString
+	}
+
+	start := time.Now()
+
+	r, err := http.NewRequest(req.Method, url, bytes.NewReader(req.Body))
+	if err != nil {
+		return &result{
+			statusCode: http.StatusBadRequest,
+			body:       []byte(err.Error()),
+		}
+	}
+
+	for k, v := range req.Header {
+		r.Header[k] = append([]string{}, v...)
+	}
+	r.Header.Set("X-Call-Id", req.CallID)
+	r.Header.Set("X-Start-Time", strconv.FormatInt(start.UnixNano(), 10))
+
+	rec := newResponseRecorder()
+	w.invoker.ServeHTTP(rec, r)
+
+	return &result{
+		statusCode: rec.statusCode,
+		header:     rec.header,
+		body:       rec.body.Bytes(),
+		duration:   time.Since(start),
+	}
+}
+
+// callback posts the result of the invocation to the callback URL of the request
+func (w *Worker) callback(req *Request, res *result) error {
+	var lastErr error
+
+	err := wait.ExponentialBackoff(w.backoff, func() (bool, error) {
+		r, err := http.NewRequest(http.MethodPost, req.CallbackURL, bytes.NewReader(res.body))
+		if err != nil {
+			return false, err
+		}
+
+		if contentType := res.header.Get("Content-Type"); len(contentType) > 0 {
+			r.Header.Set("Content-Type", contentType)
+		}
+		r.Header.Set("X-Call-Id", req.CallID)
+		r.Header.Set("X-Function-Name", req.Function)
+		r.Header.Set("X-Function-Status", strconv.Itoa(res.statusCode))
+		r.Header.Set("X-Duration-Seconds", fmt.Sprintf("%f", res.duration.Seconds()))
+
+		response, err := w.client.Do(r)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		response.Body.Close()
+
+		if response.StatusCode >= http.StatusInternalServerError {
+			lastErr = fmt.Errorf("unexpected status code %d", response.StatusCode)
+			return false, nil
+		}
+		return true, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+// retryable returns true for the status codes of transient failures
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// responseRecorder is an http.ResponseWriter which keeps the response in memory
+type responseRecorder struct {
+	statusCode  int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		statusCode: http.StatusOK,
+		header:     http.Header{},
+	}
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.statusCode = statusCode
+	r.wroteHeader = true
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(data)
+}
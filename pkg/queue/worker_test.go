@@ -0,0 +1,199 @@
+package queue
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var testBackoff = wait.Backoff{
+	Duration: time.Millisecond,
+	Factor:   2,
+	Steps:    3,
+}
+
+func newTestWorker(invoker http.Handler) *Worker {
+	return NewWorker(NewMemoryQueue(10), invoker, http.DefaultClient, NewDeadLetters(10), testBackoff)
+}
+
+func Test_Worker_InvokesFunctionThroughProxyPath(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := newTestWorker(invoker)
+	w.process(&Request{
+		CallID:      "call-1",
+		Function:    "nodeinfo",
+		Method:      http.MethodPost,
+		Path:        "/users/1",
+		QueryString: "verbose=true",
+		Header:      http.Header{"X-Custom": []string{"value"}},
+		Body:        []byte("payload"),
+	})
+
+	if got.URL.Path != "/function/nodeinfo/users/1" {
+		t.Errorf("expected path /function/nodeinfo/users/1, got: %s", got.URL.Path)
+	}
+	if got.URL.RawQuery != "verbose=true" {
+		t.Errorf("expected query verbose=true, got: %s", got.URL.RawQuery)
+	}
+	if got.Method != http.MethodPost {
+		t.Errorf("expected method POST, got: %s", got.Method)
+	}
+	if got.Header.Get("X-Custom") != "value" || got.Header.Get("X-Call-Id") != "call-1" {
+		t.Errorf("expected the request headers and call id, got: %v", got.Header)
+	}
+	if string(body) != "payload" {
+		t.Errorf("expected body payload, got: %s", string(body))
+	}
+}
+
+func Test_Worker_RetriesTransientFailures(t *testing.T) {
+	var attempts int32
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := newTestWorker(invoker)
+	w.process(&Request{CallID: "call-1", Function: "nodeinfo", Method: http.MethodGet})
+
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if letters := w.deadLetters.List(""); len(letters) != 0 {
+		t.Errorf("expected no dead letters, got: %v", letters)
+	}
+}
+
+func Test_Worker_AddsDeadLetterAfterAllAttempts(t *testing.T) {
+	var attempts int32
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("Can't reach service"))
+	})
+
+	w := newTestWorker(invoker)
+	w.process(&Request{CallID: "call-1", Function: "nodeinfo", Method: http.MethodGet})
+
+	if attempts != int32(testBackoff.Steps) {
+		t.Errorf("expected %d attempts, got %d", testBackoff.Steps, attempts)
+	}
+
+	letters := w.deadLetters.List("nodeinfo")
+	if len(letters) != 1 {
+		t.Fatalf("expected one dead letter, got: %v", letters)
+	}
+	if letters[0].StatusCode != http.StatusBadGateway || letters[0].Attempts != testBackoff.Steps {
+		t.Errorf("expected status 502 after %d attempts, got: %+v", testBackoff.Steps, letters[0])
+	}
+	if letters[0].Error != "Can't reach service" {
+		t.Errorf("expected the response body as error, got: %s", letters[0].Error)
+	}
+}
+
+func Test_Worker_DoesNotRetryClientErrors(t *testing.T) {
+	var attempts int32
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	w := newTestWorker(invoker)
+	w.process(&Request{CallID: "call-1", Function: "nodeinfo", Method: http.MethodGet})
+
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+	if letters := w.deadLetters.List(""); len(letters) != 0 {
+		t.Errorf("expected no dead letters, got: %v", letters)
+	}
+}
+
+func Test_Worker_PostsResultToCallback(t *testing.T) {
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("result"))
+	})
+
+	var callbacks int32
+	received := make(chan *http.Request, 1)
+	var body []byte
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first callback fails and is retried
+		if atomic.AddInt32(&callbacks, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer callback.Close()
+
+	w := newTestWorker(invoker)
+	w.process(&Request{CallID: "call-1", Function: "nodeinfo", Method: http.MethodGet, CallbackURL: callback.URL})
+
+	select {
+	case r := <-received:
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got: %s", r.Method)
+		}
+		if r.Header.Get("X-Call-Id") != "call-1" || r.Header.Get("X-Function-Status") != "201" ||
+			r.Header.Get("X-Function-Name") != "nodeinfo" || r.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("unexpected callback headers: %v", r.Header)
+		}
+		if string(body) != "result" {
+			t.Errorf("expected body result, got: %s", string(body))
+		}
+	default:
+		t.Fatalf("expected the callback to be received")
+	}
+}
+
+func Test_Worker_RunProcessesQueue(t *testing.T) {
+	invoked := make(chan string, 2)
+	invoker := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invoked <- r.Header.Get("X-Call-Id")
+	})
+
+	w := newTestWorker(invoker)
+	w.queue.Enqueue(&Request{CallID: "call-1", Function: "nodeinfo", Method: http.MethodGet})
+	w.queue.Enqueue(&Request{CallID: "call-2", Function: "nodeinfo", Method: http.MethodGet})
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Run(2, stopCh)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-invoked:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for the requests to be processed")
+		}
+	}
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timed out waiting for the workers to stop")
+	}
+}
@@ -0,0 +1,370 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationRateLimit is the function annotation that sets the rate of requests allowed
+	// by the proxy as a number of requests per second, minute or hour, for example 100/m
+	AnnotationRateLimit = "com.openfaas.rate-limit"
+	// AnnotationBurst is the function annotation that sets the number of requests allowed
+	// at once above the rate, it defaults to the rate per second rounded up
+	AnnotationBurst = "com.openfaas.rate-limit.burst"
+	// AnnotationKey is the function annotation that sets who the rate applies to, the
+	// whole function (default), each client IP or each value of a header such as header:X-Api-Key
+	AnnotationKey = "com.openfaas.rate-limit.key"
+
+	// KeyFunction shares a single bucket between all the callers of a function
+	KeyFunction = "function"
+	// KeyIP gives a bucket to each client IP address
+	KeyIP = "ip"
+	// KeyHeaderPrefix gives a bucket to each value of the header named after the prefix
+	KeyHeaderPrefix = "header:"
+
+	// pruneInterval is how often the buckets which have refilled are removed
+	pruneInterval = time.Minute
+)
+
+var (
+	rateLimitRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_rate_limit_requests_total",
+		Help: "Requests checked against the rate limit of a function by result, allowed or limited",
+	}, []string{"function_name", "result"})
+
+	rateLimitBuckets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_rate_limit_buckets",
+		Help: "Token buckets held for the callers of a function",
+	}, []string{"function_name"})
+
+	rateLimitRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_rate_limit_rate",
+		Help: "Requests per second allowed by the rate limit of a function",
+	}, []string{"function_name"})
+
+	rateLimitBurst = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_rate_limit_burst",
+		Help: "Requests allowed at once by the rate limit of a function",
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitRequests, rateLimitBuckets, rateLimitRate, rateLimitBurst)
+}
+
+// Policy is the rate limit of a function
+type Policy struct {
+	// Rate is the number of requests allowed per second
+	Rate rate.Limit
+	// Burst is the number of requests allowed at once
+	Burst int
+	// Key is KeyFunction, KeyIP or a header prefixed with KeyHeaderPrefix
+	Key string
+}
+
+// key returns the bucket of the caller of the request
+func (p Policy) key(r *http.Request, trustedProxies []*net.IPNet) string {
+	switch {
+	case p.Key == KeyIP:
+		return clientIP(r, trustedProxies)
+	case strings.HasPrefix(p.Key, KeyHeaderPrefix):
+		// callers without the header share a bucket
+		return r.Header.Get(strings.TrimPrefix(p.Key, KeyHeaderPrefix))
+	}
+	return ""
+}
+
+// clientIP returns the IP address of the caller. The X-Forwarded-For and X-Real-Ip headers
+// are only read from trusted proxies, as any caller can set them, and X-Forwarded-For is
+// read from the right so that the addresses added by the caller are skipped.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	if !isTrusted(net.ParseIP(remoteIP), trustedProxies) {
+		return remoteIP
+	}
+
+	forwarded := []string{}
+	for _, value := range r.Header["X-Forwarded-For"] {
+		for _, address := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(address))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			break
+		}
+		if !isTrusted(ip, trustedProxies) || i == 0 {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ip != nil {
+		return ip.String()
+	}
+	return remoteIP
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies reads a comma separated list of the IP addresses and CIDR ranges of
+// the proxies in front of the operator, such as 10.0.0.0/8,192.168.1.10
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	trustedProxies := []*net.IPNet{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trustedProxies = append(trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", entry)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	return trustedProxies, nil
+}
+
+// GetPolicy reads the rate limit from the function annotations, nil is returned
+// for functions without a rate limit
+func GetPolicy(function *faasv1.Function) (*Policy, error) {
+	if function.Spec.Annotations == nil {
+		return nil, nil
+	}
+	annotations := *function.Spec.Annotations
+
+	value, ok := annotations[AnnotationRateLimit]
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+
+	limit, err := parseRate(value)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		Rate:  limit,
+		Burst: int(math.Ceil(float64(limit))),
+		Key:   KeyFunction,
+	}
+
+	if value, ok := annotations[AnnotationBurst]; ok && len(value) > 0 {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("%s must be a number greater than zero", AnnotationBurst)
+		}
+		policy.Burst = burst
+	}
+
+	if value, ok := annotations[AnnotationKey]; ok && len(value) > 0 {
+		header := strings.TrimPrefix(value, KeyHeaderPrefix)
+		if value != KeyFunction && value != KeyIP && (header == value || len(header) == 0) {
+			return nil, fmt.Errorf("%s must be %s, %s or %s<name>", AnnotationKey, KeyFunction, KeyIP, KeyHeaderPrefix)
+		}
+		policy.Key = value
+	}
+
+	return policy, nil
+}
+
+// parseRate reads a number of requests per second, minute or hour such as 10/s or 100/m,
+// a number without a unit is per second
+func parseRate(value string) (rate.Limit, error) {
+	count, unit := value, "s"
+	if i := strings.Index(value, "/"); i >= 0 {
+		count, unit = value[:i], value[i+1:]
+	}
+
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+	requests, err := strconv.ParseFloat(count, 64)
+	if err != nil || requests <= 0 || per == 0 {
+		return 0, fmt.Errorf("%s must be a number of requests greater than zero per s, m or h, for example 100/m", AnnotationRateLimit)
+	}
+	return rate.Limit(requests / per), nil
+}
+
+// Limiter enforces the rate limits of the functions with a token bucket for each
+// caller, the limits are kept in sync with the annotations of the functions
+type Limiter struct {
+	clock clock.PassiveClock
+	// trustedProxies set the client IP of the requests they forward
+	trustedProxies []*net.IPNet
+
+	lock      sync.Mutex
+	functions map[string]*functionLimit
+}
+
+type functionLimit struct {
+	policy    Policy
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiter returns a Limiter which reads the rate limits of the functions from the informer,
+// the client IP of the requests from the trusted proxies is read from their headers
+func NewLimiter(functionsInformer faasinformers.FunctionInformer, trustedProxies []*net.IPNet, clock clock.PassiveClock) *Limiter {
+	l := &Limiter{
+		clock:          clock,
+		trustedProxies: trustedProxies,
+		functions:      map[string]*functionLimit{},
+	}
+
+	functionsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if function, ok := obj.(*faasv1.Function); ok {
+				l.updateFunction(function)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if function, ok := new.(*faasv1.Function); ok {
+				l.updateFunction(function)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if function, ok := obj.(*faasv1.Function); ok {
+				l.update(function.Spec.Name, nil)
+			}
+		},
+	})
+
+	return l
+}
+
+func (l *Limiter) updateFunction(function *faasv1.Function) {
+	policy, err := GetPolicy(function)
+	if err != nil {
+		glog.Warningf("Function %s rate limit error, the function is not rate limited: %v", function.Spec.Name, err)
+	}
+	l.update(function.Spec.Name, policy)
+}
+
+// update sets the rate limit of a function, the buckets of the callers are
+// kept unless the limit has changed
+func (l *Limiter) update(functionName string, policy *Policy) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if policy == nil {
+		if _, ok := l.functions[functionName]; ok {
+			delete(l.functions, functionName)
+			rateLimitBuckets.DeleteLabelValues(functionName)
+			rateLimitRate.DeleteLabelValues(functionName)
+			rateLimitBurst.DeleteLabelValues(functionName)
+		}
+		return
+	}
+
+	if fn, ok := l.functions[functionName]; ok && fn.policy == *policy {
+		return
+	}
+
+	glog.Infof("Function %s rate limit: %v requests per second, burst %d, key %s", functionName, float64(policy.Rate), policy.Burst, policy.Key)
+	l.functions[functionName] = &functionLimit{
+		policy:    *policy,
+		buckets:   map[string]*bucket{},
+		lastPrune: l.clock.Now(),
+	}
+	rateLimitBuckets.WithLabelValues(functionName).Set(0)
+	rateLimitRate.WithLabelValues(functionName).Set(float64(policy.Rate))
+	rateLimitBurst.WithLabelValues(functionName).Set(float64(policy.Burst))
+}
+
+// Allow takes a token from the bucket of the caller of the request, when the bucket
+// is empty false is returned with the time until the next token
+func (l *Limiter) Allow(functionName string, r *http.Request) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	fn, ok := l.functions[functionName]
+	if !ok {
+		return true, 0
+	}
+
+	now := l.clock.Now()
+	if now.Sub(fn.lastPrune) >= pruneInterval {
+		fn.prune(now)
+	}
+
+	key := fn.policy.key(r, l.trustedProxies)
+	b, ok := fn.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(fn.policy.Rate, fn.policy.Burst)}
+		fn.buckets[key] = b
+	}
+	b.lastSeen = now
+	rateLimitBuckets.WithLabelValues(functionName).Set(float64(len(fn.buckets)))
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		rateLimitRequests.WithLabelValues(functionName, "limited").Inc()
+		return false, delay
+	}
+
+	rateLimitRequests.WithLabelValues(functionName, "allowed").Inc()
+	return true, 0
+}
+
+// prune removes the buckets which have refilled since they were last used,
+// they are the same as a new bucket so callers can't tell the difference
+func (fn *functionLimit) prune(now time.Time) {
+	refill := time.Duration(float64(fn.policy.Burst) / float64(fn.policy.Rate) * float64(time.Second))
+	for key, b := range fn.buckets {
+		if now.Sub(b.lastSeen) >= refill {
+			delete(fn.buckets, key)
+		}
+	}
+	fn.lastPrune = now
+}
@@ -0,0 +1,388 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func newTestFunction(name string, annotations map[string]string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+		},
+	}
+}
+
+func newTestLimiter(functions ...*faasv1.Function) (*Limiter, *clock.FakeClock) {
+	faasInformerFactory := informers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+	fakeClock := clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewLimiter(faasInformerFactory.Openfaas().V1().Functions(), nil, fakeClock)
+
+	// the informer is not started, the events are simulated by the tests
+	for _, function := range functions {
+		l.updateFunction(function)
+	}
+	return l, fakeClock
+}
+
+func newRequest(remoteAddr string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil)
+	r.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	return r
+}
+
+func Test_GetPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *Policy
+		wantErr     bool
+	}{
+		{
+			name: "no rate limit",
+		},
+		{
+			name:        "requests per second by default",
+			annotations: map[string]string{AnnotationRateLimit: "10"},
+			expected:    &Policy{Rate: 10, Burst: 10, Key: KeyFunction},
+		},
+		{
+			name:        "requests per minute with a burst of one",
+			annotations: map[string]string{AnnotationRateLimit: "30/m"},
+			expected:    &Policy{Rate: 0.5, Burst: 1, Key: KeyFunction},
+		},
+		{
+			name: "burst and header key",
+			annotations: map[string]string{
+				AnnotationRateLimit: "3600/h",
+				AnnotationBurst:     "20",
+				AnnotationKey:       "header:X-Api-Key",
+			},
+			expected: &Policy{Rate: 1, Burst: 20, Key: "header:X-Api-Key"},
+		},
+		{
+			name:        "ip key",
+			annotations: map[string]string{AnnotationRateLimit: "5/s", AnnotationKey: KeyIP},
+			expected:    &Policy{Rate: 5, Burst: 5, Key: KeyIP},
+		},
+		{
+			name:        "invalid unit",
+			annotations: map[string]string{AnnotationRateLimit: "10/d"},
+			wantErr:     true,
+		},
+		{
+			name:        "zero rate",
+			annotations: map[string]string{AnnotationRateLimit: "0"},
+			wantErr:     true,
+		},
+		{
+			name:        "zero burst",
+			annotations: map[string]string{AnnotationRateLimit: "10", AnnotationBurst: "0"},
+			wantErr:     true,
+		},
+		{
+			name:        "header key without a name",
+			annotations: map[string]string{AnnotationRateLimit: "10", AnnotationKey: "header:"},
+			wantErr:     true,
+		},
+		{
+			name:        "unknown key",
+			annotations: map[string]string{AnnotationRateLimit: "10", AnnotationKey: "user"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := GetPolicy(newTestFunction("nodeinfo", tc.annotations))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (policy == nil) != (tc.expected == nil) || (policy != nil && *policy != *tc.expected) {
+				t.Errorf("expected policy %+v, got %+v", tc.expected, policy)
+			}
+		})
+	}
+}
+
+func Test_Limiter_AllowsBurstThenLimits(t *testing.T) {
+	rateLimitRequests.Reset()
+	l, fakeClock := newTestLimiter(newTestFunction("nodeinfo", map[string]string{
+		AnnotationRateLimit: "1/s",
+		AnnotationBurst:     "2",
+	}))
+	r := newRequest("10.0.0.1:1234", nil)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("nodeinfo", r); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+
+	ok, retryAfter := l.Allow("nodeinfo", r)
+	if ok {
+		t.Fatalf("expected the request over the burst to be limited")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("expected to retry after 1s, got %s", retryAfter)
+	}
+
+	fakeClock.Step(time.Second)
+	if ok, _ := l.Allow("nodeinfo", r); !ok {
+		t.Errorf("expected a request to be allowed once a token was added")
+	}
+
+	if got := testutil.ToFloat64(rateLimitRequests.WithLabelValues("nodeinfo", "allowed")); got != 3 {
+		t.Errorf("expected 3 allowed requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(rateLimitRequests.WithLabelValues("nodeinfo", "limited")); got != 1 {
+		t.Errorf("expected 1 limited request, got %v", got)
+	}
+}
+
+func Test_Limiter_FunctionsWithoutLimit(t *testing.T) {
+	l, _ := newTestLimiter(newTestFunction("nodeinfo", nil))
+
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil)); !ok {
+			t.Fatalf("expected functions without a rate limit to be allowed")
+		}
+	}
+}
+
+func Test_Limiter_BucketPerKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		key   string
+		first *http.Request
+		other *http.Request
+	}{
+		{
+			name:  "client IP",
+			key:   KeyIP,
+			first: newRequest("10.0.0.1:1234", nil),
+			other: newRequest("10.0.0.2:1234", nil),
+		},
+		{
+			name:  "header",
+			key:   "header:X-Api-Key",
+			first: newRequest("10.0.0.1:1234", map[string]string{"X-Api-Key": "alice"}),
+			other: newRequest("10.0.0.1:1234", map[string]string{"X-Api-Key": "bob"}),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, _ := newTestLimiter(newTestFunction("nodeinfo", map[string]string{
+				AnnotationRateLimit: "1/m",
+				AnnotationKey:       tc.key,
+			}))
+
+			if ok, _ := l.Allow("nodeinfo", tc.first); !ok {
+				t.Fatalf("expected the first request to be allowed")
+			}
+			if ok, _ := l.Allow("nodeinfo", tc.first); ok {
+				t.Errorf("expected the second request of the same caller to be limited")
+			}
+			if ok, _ := l.Allow("nodeinfo", tc.other); !ok {
+				t.Errorf("expected another caller to have its own bucket")
+			}
+		})
+	}
+}
+
+func Test_Limiter_ReloadsOnFunctionChange(t *testing.T) {
+	faas := faasfake.NewSimpleClientset()
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+	l := NewLimiter(faasInformerFactory.Openfaas().V1().Functions(), nil, clock.RealClock{})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	faasInformerFactory.Start(stopCh)
+	faasInformerFactory.WaitForCacheSync(stopCh)
+
+	function := newTestFunction("nodeinfo", map[string]string{AnnotationRateLimit: "1/h"})
+	if _, err := faas.OpenfaasV1().Functions("openfaas-fn").Create(function); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return l.policy("nodeinfo") != nil })
+
+	l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil))
+	if ok, _ := l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil)); ok {
+		t.Fatalf("expected the request to be limited")
+	}
+
+	function = function.DeepCopy()
+	(*function.Spec.Annotations)[AnnotationRateLimit] = "100/s"
+	if _, err := faas.OpenfaasV1().Functions("openfaas-fn").Update(function); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { p := l.policy("nodeinfo"); return p != nil && p.Rate == rate.Limit(100) })
+
+	if ok, _ := l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil)); !ok {
+		t.Errorf("expected the new rate limit to apply")
+	}
+
+	if err := faas.OpenfaasV1().Functions("openfaas-fn").Delete("nodeinfo", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return l.policy("nodeinfo") == nil })
+}
+
+func Test_Limiter_KeepsBucketsOnUnrelatedChanges(t *testing.T) {
+	function := newTestFunction("nodeinfo", map[string]string{AnnotationRateLimit: "1/h"})
+	l, _ := newTestLimiter(function)
+
+	l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil))
+
+	updated := function.DeepCopy()
+	updated.Spec.Image = "functions/nodeinfo:v2"
+	l.updateFunction(updated)
+
+	if ok, _ := l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil)); ok {
+		t.Errorf("expected the bucket to be kept when the rate limit has not changed")
+	}
+}
+
+func Test_Limiter_PrunesRefilledBuckets(t *testing.T) {
+	l, fakeClock := newTestLimiter(newTestFunction("nodeinfo", map[string]string{
+		AnnotationRateLimit: "1/s",
+		AnnotationKey:       KeyIP,
+	}))
+
+	l.Allow("nodeinfo", newRequest("10.0.0.1:1234", nil))
+	l.Allow("nodeinfo", newRequest("10.0.0.2:1234", nil))
+
+	fakeClock.Step(pruneInterval)
+	l.Allow("nodeinfo", newRequest("10.0.0.3:1234", nil))
+
+	if got := testutil.ToFloat64(rateLimitBuckets.WithLabelValues("nodeinfo")); got != 1 {
+		t.Errorf("expected the refilled buckets to be removed, got %v buckets", got)
+	}
+}
+
+// policy returns the rate limit of a function
+func (l *Limiter) policy(functionName string) *Policy {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if fn, ok := l.functions[functionName]; ok {
+		policy := fn.policy
+		return &policy
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the informer")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		request  *http.Request
+		expected string
+	}{
+		{
+			name:     "direct caller",
+			request:  newRequest("203.0.113.7:1234", nil),
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "headers of an untrusted caller are ignored",
+			request:  newRequest("203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-Ip": "198.51.100.1"}),
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "forwarded by a trusted proxy",
+			request:  newRequest("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}),
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "addresses set by the caller are skipped",
+			request:  newRequest("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 192.168.1.10"}),
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "only trusted proxies",
+			request:  newRequest("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "10.0.0.7, 10.0.0.6"}),
+			expected: "10.0.0.7",
+		},
+		{
+			name:     "real IP of a trusted proxy",
+			request:  newRequest("192.168.1.10:1234", map[string]string{"X-Real-Ip": "198.51.100.1"}),
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "trusted proxy without headers",
+			request:  newRequest("10.0.0.5:1234", nil),
+			expected: "10.0.0.5",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if ip := clientIP(tc.request, trustedProxies); ip != tc.expected {
+				t.Errorf("expected client IP %s, got %s", tc.expected, ip)
+			}
+		})
+	}
+}
+
+func Test_ParseTrustedProxies(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies("")
+	if err != nil || len(trustedProxies) != 0 {
+		t.Errorf("expected no trusted proxy, got %v %v", trustedProxies, err)
+	}
+
+	trustedProxies, err = ParseTrustedProxies("10.0.0.0/8,192.168.1.10,fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trustedProxies) != 3 || trustedProxies[1].String() != "192.168.1.10/32" {
+		t.Errorf("unexpected trusted proxies: %v", trustedProxies)
+	}
+
+	for _, value := range []string{"proxy", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("expected an error for %s", value)
+		}
+	}
+}
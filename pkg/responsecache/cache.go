@@ -0,0 +1,417 @@
+package responsecache
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationCache is the function annotation that enables the response cache with the
+	// time to live of the responses as a duration, for example 30s
+	AnnotationCache = "com.openfaas.cache"
+	// AnnotationVary is the function annotation holding a comma separated list of request
+	// headers which select different responses, for example Accept,Authorization
+	AnnotationVary = "com.openfaas.cache.vary"
+	// AnnotationMaxSize is the function annotation that limits the memory used by the cached
+	// responses of the function as a quantity, the least recently used responses are evicted
+	AnnotationMaxSize = "com.openfaas.cache.max-size"
+
+	defaultMaxSize = 10 * 1024 * 1024
+	// maxEntries bounds the number of responses of a function, the size is the actual limit
+	maxEntries = 1 << 20
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_cache_requests_total",
+		Help: "Cacheable requests of a function by result, hit or miss",
+	}, []string{"function_name", "result"})
+
+	cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_cache_evictions_total",
+		Help: "Responses of a function evicted from the cache to stay under its size",
+	}, []string{"function_name"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_cache_entries",
+		Help: "Responses of a function held in the cache",
+	}, []string{"function_name"})
+
+	cacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_cache_bytes",
+		Help: "Size of the responses of a function held in the cache",
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests, cacheEvictions, cacheEntries, cacheBytes)
+}
+
+// Policy is the response cache configuration of a function
+type Policy struct {
+	// TTL is how long responses are cached unless the function sets a max-age
+	TTL time.Duration
+	// Vary lists the canonical request headers which are part of the cache key
+	Vary []string
+	// MaxSize is the maximum size of the cached responses in bytes
+	MaxSize int64
+}
+
+// GetPolicy reads the response cache from the function annotations, nil is returned
+// for functions without the cache annotation
+func GetPolicy(function *faasv1.Function) (*Policy, error) {
+	if function.Spec.Annotations == nil {
+		return nil, nil
+	}
+	annotations := *function.Spec.Annotations
+
+	value, ok := annotations[AnnotationCache]
+	if !ok || len(value) == 0 {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("%s must be a duration greater than zero", AnnotationCache)
+	}
+
+	policy := &Policy{
+		TTL:     ttl,
+		Vary:    []string{},
+		MaxSize: defaultMaxSize,
+	}
+
+	if value, ok := annotations[AnnotationVary]; ok && len(value) > 0 {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); len(header) > 0 {
+				policy.Vary = append(policy.Vary, http.CanonicalHeaderKey(header))
+			}
+		}
+		sort.Strings(policy.Vary)
+	}
+
+	if value, ok := annotations[AnnotationMaxSize]; ok && len(value) > 0 {
+		size, err := resource.ParseQuantity(value)
+		if err != nil || size.Value() <= 0 {
+			return nil, fmt.Errorf("%s must be a quantity greater than zero", AnnotationMaxSize)
+		}
+		policy.MaxSize = size.Value()
+	}
+
+	return policy, nil
+}
+
+// Entry is a cached response
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Stored is when the response was cached
+	Stored  time.Time
+	Expires time.Time
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for name, values := range e.Header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
+
+// Cache holds the responses of the functions with a cache annotation, the cache of a
+// function is emptied when its spec changes, for example with a new image
+type Cache struct {
+	clock clock.PassiveClock
+
+	lock      sync.Mutex
+	functions map[string]*functionCache
+	// generation changes with each new cache of a function so that the responses
+	// of requests made before an invalidation are not stored
+	generation uint64
+}
+
+type functionCache struct {
+	policy     Policy
+	spec       faasv1.FunctionSpec
+	generation uint64
+	entries    *lru.Cache
+	size       int64
+}
+
+// NewCache returns a Cache which reads the cache policies of the functions from the informer
+func NewCache(functionsInformer faasinformers.FunctionInformer, clock clock.PassiveClock) *Cache {
+	c := &Cache{
+		clock:     clock,
+		functions: map[string]*functionCache{},
+	}
+
+	functionsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if function, ok := obj.(*faasv1.Function); ok {
+				c.update(function)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if function, ok := new.(*faasv1.Function); ok {
+				c.update(function)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if function, ok := obj.(*faasv1.Function); ok {
+				c.remove(function.Spec.Name)
+			}
+		},
+	})
+
+	return c
+}
+
+// update replaces the cache of a function when its spec has changed
+func (c *Cache) update(function *faasv1.Function) {
+	functionName := function.Spec.Name
+	policy, err := GetPolicy(function)
+	if err != nil {
+		glog.Warningf("Function %s cache error, the responses are not cached: %v", functionName, err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if fn, ok := c.functions[functionName]; ok && policy != nil && reflect.DeepEqual(fn.spec, function.Spec) {
+		return
+	}
+
+	c.removeLocked(functionName)
+	if policy == nil {
+		return
+	}
+
+	c.generation++
+	fn := &functionCache{policy: *policy, spec: *function.Spec.DeepCopy(), generation: c.generation}
+	fn.entries, _ = lru.NewWithEvict(maxEntries, func(key, value interface{}) {
+		fn.size -= value.(*Entry).size()
+	})
+	c.functions[functionName] = fn
+	glog.Infof("Function %s responses cached for %s", functionName, policy.TTL)
+}
+
+func (c *Cache) remove(functionName string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.removeLocked(functionName)
+}
+
+// removeLocked drops the cache of a function, the lock must be held
+func (c *Cache) removeLocked(functionName string) {
+	fn, ok := c.functions[functionName]
+	if !ok {
+		return
+	}
+
+	if fn.entries.Len() > 0 {
+		glog.Infof("Function %s cache invalidated, %d responses removed", functionName, fn.entries.Len())
+	}
+	delete(c.functions, functionName)
+	cacheEntries.DeleteLabelValues(functionName)
+	cacheBytes.DeleteLabelValues(functionName)
+}
+
+// Policy returns the cache policy of a function along with the generation of its cache which
+// must be given to Set, nil is returned for functions without a cache
+func (c *Cache) Policy(functionName string) (*Policy, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn, ok := c.functions[functionName]
+	if !ok {
+		return nil, 0
+	}
+	policy := fn.policy
+	return &policy, fn.generation
+}
+
+// Key returns the cache key of a request, made of the path, the query and the vary headers
+func Key(policy *Policy, r *http.Request) string {
+	key := strings.Builder{}
+	key.WriteString(r.URL.Path)
+	key.WriteString("?")
+	key.WriteString(r.URL.RawQuery)
+	for _, header := range policy.Vary {
+		key.WriteString("\n")
+		key.WriteString(header)
+		key.WriteString(": ")
+		key.WriteString(strings.Join(r.Header[header], ","))
+	}
+	return key.String()
+}
+
+// Get returns the response cached for the key, expired responses are removed
+func (c *Cache) Get(functionName, key string) (*Entry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn, ok := c.functions[functionName]
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := fn.entries.Get(key)
+	if ok && c.clock.Now().Before(value.(*Entry).Expires) {
+		cacheRequests.WithLabelValues(functionName, "hit").Inc()
+		return value.(*Entry), true
+	}
+
+	if ok {
+		fn.entries.Remove(key)
+		fn.record(functionName)
+	}
+	cacheRequests.WithLabelValues(functionName, "miss").Inc()
+	return nil, false
+}
+
+// Set caches a response of a function for maxAge, the least recently used responses are
+// evicted to make room for it. Responses larger than the cache and responses of a previous
+// generation of the cache are not stored.
+func (c *Cache) Set(functionName string, generation uint64, key string, entry *Entry, maxAge time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fn, ok := c.functions[functionName]
+	if !ok || fn.generation != generation {
+		return
+	}
+
+	entry.Stored = c.clock.Now()
+	entry.Expires = entry.Stored.Add(maxAge)
+
+	size := entry.size()
+	if size > fn.policy.MaxSize {
+		return
+	}
+
+	fn.entries.Remove(key)
+	for fn.size+size > fn.policy.MaxSize && fn.entries.Len() > 0 {
+		fn.entries.RemoveOldest()
+		cacheEvictions.WithLabelValues(functionName).Inc()
+	}
+
+	fn.entries.Add(key, entry)
+	fn.size += size
+	fn.record(functionName)
+}
+
+func (fn *functionCache) record(functionName string) {
+	cacheEntries.WithLabelValues(functionName).Set(float64(fn.entries.Len()))
+	cacheBytes.WithLabelValues(functionName).Set(float64(fn.size))
+}
+
+// MaxAge returns how long a response can be cached from its Cache-Control header, s-maxage
+// and max-age replace the time to live of the function. False is returned for responses
+// which must not be cached, including responses which vary on headers which are not part
+// of the cache key.
+func (p *Policy) MaxAge(header http.Header) (time.Duration, bool) {
+	if len(header.Get("Set-Cookie")) > 0 {
+		return 0, false
+	}
+
+	for _, values := range header["Vary"] {
+		for _, name := range strings.Split(values, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if len(name) > 0 && !p.varies(name) {
+				return 0, false
+			}
+		}
+	}
+
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[directive]; ok {
+			return 0, false
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return p.TTL, true
+}
+
+// Cacheable returns whether the response of a request can be shared through the cache, requests
+// with credentials are only cached when the Authorization or Cookie header is part of the cache key
+func (p *Policy) Cacheable(r *http.Request) bool {
+	for _, header := range []string{"Authorization", "Cookie"} {
+		if len(r.Header[header]) > 0 && !p.varies(header) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Policy) varies(header string) bool {
+	for _, name := range p.Vary {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
+
+// Directives returns whether a request can be served from the cache and whether its
+// response can be stored, from the Cache-Control and Pragma headers of the request
+func Directives(r *http.Request) (lookup bool, store bool) {
+	directives := parseCacheControl(r.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return false, false
+	}
+	if _, ok := directives["no-cache"]; ok || r.Header.Get("Pragma") == "no-cache" {
+		// the response is fetched again and replaces the cached response
+		return false, true
+	}
+	return true, true
+}
+
+// parseCacheControl returns the directives of a Cache-Control header with their values
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) == 0 {
+			continue
+		}
+		name, arg := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = arg
+	}
+	return directives
+}
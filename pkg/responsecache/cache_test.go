@@ -0,0 +1,305 @@
+package responsecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func newTestFunction(name string, annotations map[string]string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+		},
+	}
+}
+
+func newTestCache(functions ...*faasv1.Function) (*Cache, *clock.FakeClock) {
+	faasInformerFactory := informers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+	fakeClock := clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := NewCache(faasInformerFactory.Openfaas().V1().Functions(), fakeClock)
+
+	// the informer is not started, the events are simulated by the tests
+	for _, function := range functions {
+		c.update(function)
+	}
+	return c, fakeClock
+}
+
+func set(c *Cache, functionName, key, body string, maxAge time.Duration) {
+	_, generation := c.Policy(functionName)
+	c.Set(functionName, generation, key, &Entry{StatusCode: http.StatusOK, Header: http.Header{}, Body: []byte(body)}, maxAge)
+}
+
+func get(c *Cache, functionName, key string) string {
+	entry, ok := c.Get(functionName, key)
+	if !ok {
+		return ""
+	}
+	return string(entry.Body)
+}
+
+func Test_GetPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    *Policy
+		wantErr     bool
+	}{
+		{
+			name: "no cache",
+		},
+		{
+			name:        "ttl with defaults",
+			annotations: map[string]string{AnnotationCache: "30s"},
+			expected:    &Policy{TTL: time.Second * 30, Vary: []string{}, MaxSize: defaultMaxSize},
+		},
+		{
+			name: "vary and max size",
+			annotations: map[string]string{
+				AnnotationCache:   "1m",
+				AnnotationVary:    "x-api-key, Accept",
+				AnnotationMaxSize: "1Mi",
+			},
+			expected: &Policy{TTL: time.Minute, Vary: []string{"Accept", "X-Api-Key"}, MaxSize: 1024 * 1024},
+		},
+		{
+			name:        "invalid ttl",
+			annotations: map[string]string{AnnotationCache: "true"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid max size",
+			annotations: map[string]string{AnnotationCache: "1m", AnnotationMaxSize: "0"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := GetPolicy(newTestFunction("nodeinfo", tc.annotations))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(policy, tc.expected) {
+				t.Errorf("expected policy %+v, got %+v", tc.expected, policy)
+			}
+		})
+	}
+}
+
+func Test_Policy_MaxAge(t *testing.T) {
+	policy := &Policy{TTL: time.Minute, Vary: []string{"Accept"}}
+
+	cases := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "ttl of the function", header: http.Header{}, expected: time.Minute, ok: true},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=10"}}, expected: time.Second * 10, ok: true},
+		{name: "s-maxage over max-age", header: http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, expected: time.Second * 20, ok: true},
+		{name: "max-age of zero", header: http.Header{"Cache-Control": {"max-age=0"}}},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store"}}},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"No-Cache"}}},
+		{name: "private", header: http.Header{"Cache-Control": {"private, max-age=60"}}},
+		{name: "cookies", header: http.Header{"Set-Cookie": {"session=1"}}},
+		{name: "vary on a key header", header: http.Header{"Vary": {"accept"}}, expected: time.Minute, ok: true},
+		{name: "vary on another header", header: http.Header{"Vary": {"Accept, Accept-Encoding"}}},
+		{name: "vary on everything", header: http.Header{"Vary": {"*"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			maxAge, ok := policy.MaxAge(tc.header)
+			if ok != tc.ok || maxAge != tc.expected {
+				t.Errorf("expected %s %v, got %s %v", tc.expected, tc.ok, maxAge, ok)
+			}
+		})
+	}
+}
+
+func Test_Policy_Cacheable(t *testing.T) {
+	cases := []struct {
+		name     string
+		vary     []string
+		header   http.Header
+		expected bool
+	}{
+		{name: "anonymous", header: http.Header{"Accept": {"text/plain"}}, expected: true},
+		{name: "authorization", header: http.Header{"Authorization": {"Bearer token"}}},
+		{name: "cookie", header: http.Header{"Cookie": {"session=1"}}},
+		{name: "authorization in the key", vary: []string{"Authorization"}, header: http.Header{"Authorization": {"Bearer token"}}, expected: true},
+		{name: "cookie not in the key", vary: []string{"Authorization"}, header: http.Header{"Authorization": {"Bearer token"}, "Cookie": {"session=1"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := &Policy{TTL: time.Minute, Vary: tc.vary}
+			r := httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil)
+			r.Header = tc.header
+			if got := policy.Cacheable(r); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func Test_Directives(t *testing.T) {
+	cases := []struct {
+		header http.Header
+		lookup bool
+		store  bool
+	}{
+		{header: http.Header{}, lookup: true, store: true},
+		{header: http.Header{"Cache-Control": {"no-cache"}}, lookup: false, store: true},
+		{header: http.Header{"Pragma": {"no-cache"}}, lookup: false, store: true},
+		{header: http.Header{"Cache-Control": {"no-store"}}, lookup: false, store: false},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil)
+		r.Header = tc.header
+		if lookup, store := Directives(r); lookup != tc.lookup || store != tc.store {
+			t.Errorf("%v: expected lookup %v and store %v, got %v and %v", tc.header, tc.lookup, tc.store, lookup, store)
+		}
+	}
+}
+
+func Test_Key(t *testing.T) {
+	policy := &Policy{Vary: []string{"X-Api-Key"}}
+	request := func(path, apiKey string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("X-Api-Key", apiKey)
+		r.Header.Set("User-Agent", apiKey)
+		return r
+	}
+
+	same := Key(policy, request("/function/nodeinfo?q=1", "alice"))
+	if Key(policy, request("/function/nodeinfo?q=1", "alice")) != same {
+		t.Errorf("expected the same key for the same request")
+	}
+	if Key(policy, request("/function/nodeinfo?q=2", "alice")) == same {
+		t.Errorf("expected the query to be part of the key")
+	}
+	if Key(policy, request("/function/nodeinfo?q=1", "bob")) == same {
+		t.Errorf("expected the vary header to be part of the key")
+	}
+	if !strings.Contains(same, "X-Api-Key: alice") || strings.Contains(same, "User-Agent") {
+		t.Errorf("expected only the vary headers in the key, got %q", same)
+	}
+}
+
+func Test_Cache_GetAndExpire(t *testing.T) {
+	cacheRequests.Reset()
+	c, fakeClock := newTestCache(newTestFunction("nodeinfo", map[string]string{AnnotationCache: "1m"}))
+
+	if got := get(c, "nodeinfo", "/"); got != "" {
+		t.Fatalf("expected a miss for an empty cache, got %q", got)
+	}
+
+	set(c, "nodeinfo", "/", "cached", time.Minute)
+	if got := get(c, "nodeinfo", "/"); got != "cached" {
+		t.Errorf("expected a hit, got %q", got)
+	}
+
+	fakeClock.Step(time.Minute)
+	if got := get(c, "nodeinfo", "/"); got != "" {
+		t.Errorf("expected the response to expire, got %q", got)
+	}
+
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("nodeinfo", "hit")); got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("nodeinfo", "miss")); got != 2 {
+		t.Errorf("expected 2 misses, got %v", got)
+	}
+	if got := testutil.ToFloat64(cacheEntries.WithLabelValues("nodeinfo")); got != 0 {
+		t.Errorf("expected the expired response to be removed, got %v entries", got)
+	}
+}
+
+func Test_Cache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(newTestFunction("nodeinfo", map[string]string{
+		AnnotationCache:   "1m",
+		AnnotationMaxSize: "10",
+	}))
+
+	set(c, "nodeinfo", "a", "aaaa", time.Minute)
+	set(c, "nodeinfo", "b", "bbbb", time.Minute)
+	get(c, "nodeinfo", "a")
+	set(c, "nodeinfo", "c", "cccc", time.Minute)
+
+	if get(c, "nodeinfo", "b") != "" {
+		t.Errorf("expected the least recently used response to be evicted")
+	}
+	if get(c, "nodeinfo", "a") != "aaaa" || get(c, "nodeinfo", "c") != "cccc" {
+		t.Errorf("expected the recently used responses to be kept")
+	}
+	if got := testutil.ToFloat64(cacheBytes.WithLabelValues("nodeinfo")); got != 8 {
+		t.Errorf("expected 8 bytes in the cache, got %v", got)
+	}
+
+	set(c, "nodeinfo", "large", "larger than the cache", time.Minute)
+	if get(c, "nodeinfo", "large") != "" {
+		t.Errorf("expected a response larger than the cache not to be stored")
+	}
+}
+
+func Test_Cache_InvalidatedWhenSpecChanges(t *testing.T) {
+	function := newTestFunction("nodeinfo", map[string]string{AnnotationCache: "1m"})
+	c, _ := newTestCache(function)
+
+	set(c, "nodeinfo", "/", "v1", time.Minute)
+	_, staleGeneration := c.Policy("nodeinfo")
+
+	// the informer resyncs the same spec
+	c.update(function.DeepCopy())
+	if get(c, "nodeinfo", "/") != "v1" {
+		t.Fatalf("expected the cache to be kept when the spec has not changed")
+	}
+
+	updated := function.DeepCopy()
+	updated.Spec.Image = "functions/nodeinfo:v2"
+	c.update(updated)
+	if get(c, "nodeinfo", "/") != "" {
+		t.Errorf("expected the cache to be emptied for a new image")
+	}
+
+	// a response of the previous image arrives after the update
+	c.Set("nodeinfo", staleGeneration, "/", &Entry{StatusCode: http.StatusOK, Body: []byte("v1")}, time.Minute)
+	if get(c, "nodeinfo", "/") != "" {
+		t.Errorf("expected a response of the previous generation not to be stored")
+	}
+
+	withoutCache := updated.DeepCopy()
+	delete(*withoutCache.Spec.Annotations, AnnotationCache)
+	c.update(withoutCache)
+	if policy, _ := c.Policy("nodeinfo"); policy != nil {
+		t.Errorf("expected no cache once the annotation is removed, got %+v", policy)
+	}
+}
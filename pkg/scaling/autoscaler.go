@@ -0,0 +1,389 @@
+package scaling
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	appsinformer "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationScaleTarget is the function annotation that enables the autoscaler,
+	// its value is the target load per replica e.g. 50
+	AnnotationScaleTarget = "com.openfaas.scale.target"
+	// AnnotationScaleType is the function annotation that sets the load metric
+	// used by the autoscaler, either rps (default) or inflight
+	AnnotationScaleType = "com.openfaas.scale.type"
+	// LabelMaxReplicas is the function label that sets the maximum replicas
+	LabelMaxReplicas = "com.openfaas.scale.max"
+	// LabelScaleFactor is the function label that sets the maximum scale up step
+	// as a percentage of the max replicas, zero disables the autoscaler
+	LabelScaleFactor = "com.openfaas.scale.factor"
+
+	// ScaleTypeRPS scales functions on the requests per second received by each replica
+	ScaleTypeRPS = "rps"
+	// ScaleTypeInflight scales functions on the requests being processed by each replica
+	ScaleTypeInflight = "inflight"
+
+	defaultMinReplicas = 1
+	defaultMaxReplicas = 20
+	defaultScaleFactor = 20
+)
+
+var desiredReplicasGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "operator_autoscaler_desired_replicas",
+	Help: "Replicas recommended by the autoscaler for a function",
+}, []string{"function_name"})
+
+func init() {
+	prometheus.MustRegister(desiredReplicasGauge)
+}
+
+// Autoscaler sets the replicas of functions from the load measured by the function proxy
+type Autoscaler struct {
+	kube      kubernetes.Interface
+	namespace string
+	tracker   *Tracker
+	clock     clock.PassiveClock
+	interval  time.Duration
+	// stabilizationWindow is the period over which the highest recommendation
+	// is used when scaling down to prevent flapping
+	stabilizationWindow time.Duration
+
+	functionsLister   listers.FunctionLister
+	functionsSynced   cache.InformerSynced
+	deploymentsLister appslisters.DeploymentLister
+	deploymentsSynced cache.InformerSynced
+
+	// states is only accessed from the reconcile loop
+	states map[string]*autoscalerState
+}
+
+// autoscalerState holds the samples and recommendations of a function between reconciles
+type autoscalerState struct {
+	lastRequests    uint64
+	lastSample      time.Time
+	lastReplicas    int32
+	recommendations []recommendation
+}
+
+type recommendation struct {
+	replicas  int32
+	timestamp time.Time
+}
+
+// scalingPolicy is the autoscaling configuration of a function
+type scalingPolicy struct {
+	scaleType   string
+	target      float64
+	minReplicas int32
+	maxReplicas int32
+	factor      int32
+}
+
+// NewAutoscaler returns an Autoscaler that reconciles the replicas of the functions
+// in the given namespace every interval
+func NewAutoscaler(
+	kube kubernetes.Interface,
+	namespace string,
+	functionsInformer faasinformers.FunctionInformer,
+	deploymentsInformer appsinformer.DeploymentInformer,
+	tracker *Tracker,
+	clock clock.PassiveClock,
+	interval time.Duration,
+	stabilizationWindow time.Duration) *Autoscaler {
+
+	return &Autoscaler{
+		kube:                kube,
+		namespace:           namespace,
+		tracker:             tracker,
+		clock:               clock,
+		interval:            interval,
+		stabilizationWindow: stabilizationWindow,
+		functionsLister:     functionsInformer.Lister(),
+		functionsSynced:     functionsInformer.Informer().HasSynced,
+		deploymentsLister:   deploymentsInformer.Lister(),
+		deploymentsSynced:   deploymentsInformer.Informer().HasSynced,
+		states:              map[string]*autoscalerState{},
+	}
+}
+
+// Run waits for the informer caches to sync and then reconciles
+// the function replicas until stopCh is closed
+func (a *Autoscaler) Run(stopCh <-chan struct{}) error {
+	if ok := cache.WaitForCacheSync(stopCh, a.functionsSynced, a.deploymentsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	glog.Infof("Starting autoscaler with interval %s", a.interval)
+	wait.Until(a.reconcile, a.interval, stopCh)
+
+	return nil
+}
+
+func (a *Autoscaler) reconcile() {
+	functions, err := a.functionsLister.Functions(a.namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("autoscaler failed to list functions: %v", err))
+		return
+	}
+
+	active := map[string]bool{}
+	for _, function := range functions {
+		active[function.Spec.Name] = true
+		if err := a.reconcileFunction(function); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+
+	for functionName := range a.states {
+		if !active[functionName] {
+			delete(a.states, functionName)
+			desiredReplicasGauge.DeleteLabelValues(functionName)
+		}
+	}
+}
+
+func (a *Autoscaler) reconcileFunction(function *faasv1.Function) error {
+	functionName := function.Spec.Name
+
+	policy, ok, err := getScalingPolicy(function)
+	if err != nil {
+		delete(a.states, functionName)
+		return fmt.Errorf("function %s has an invalid autoscaling configuration: %v", functionName, err)
+	}
+	if !ok {
+		delete(a.states, functionName)
+		return nil
+	}
+
+	deployment, err := a.deploymentsLister.Deployments(function.Namespace).Get(functionName)
+	if err != nil {
+		return fmt.Errorf("autoscaler failed to get deployment %s: %v", functionName, err)
+	}
+
+	current := int32(1)
+	if deployment.Spec.Replicas != nil {
+		current = *deployment.Spec.Replicas
+	}
+
+	// functions scaled to zero are left to the idler and the scale from zero proxy
+	if current == 0 {
+		delete(a.states, functionName)
+		return nil
+	}
+
+	now := a.clock.Now()
+	stats := a.tracker.Stats(functionName)
+
+	state, ok := a.states[functionName]
+	if !ok {
+		// the first reconcile only takes a sample as the request rate
+		// needs two samples, the current replicas are kept for a full
+		// stabilization window to avoid scaling down after a restart
+		a.states[functionName] = &autoscalerState{
+			lastRequests:    stats.Requests,
+			lastSample:      now,
+			lastReplicas:    current,
+			recommendations: []recommendation{{replicas: current, timestamp: now}},
+		}
+		return nil
+	}
+
+	// the replicas have been changed outside of the autoscaler e.g. through the
+	// replicas API, keep the new value for a full stabilization window
+	if state.lastReplicas != current {
+		glog.V(2).Infof("Function %s replicas changed from %d to %d outside of the autoscaler",
+			functionName, state.lastReplicas, current)
+
+		state.lastReplicas = current
+		state.recommendations = []recommendation{{replicas: current, timestamp: now}}
+	}
+
+	load := float64(stats.Inflight)
+	if policy.scaleType == ScaleTypeRPS {
+		load = 0
+		if elapsed := now.Sub(state.lastSample).Seconds(); elapsed > 0 && stats.Requests >= state.lastRequests {
+			load = float64(stats.Requests-state.lastRequests) / elapsed
+		}
+	}
+	state.lastRequests = stats.Requests
+	state.lastSample = now
+
+	desired := desiredReplicas(load, policy)
+	desiredReplicasGauge.WithLabelValues(functionName).Set(float64(desired))
+
+	state.recommendations = append(state.recommendations, recommendation{replicas: desired, timestamp: now})
+	state.recommendations = pruneRecommendations(state.recommendations, now.Add(-a.stabilizationWindow))
+
+	next := nextReplicas(current, desired, state.recommendations, policy)
+	if next == current {
+		return nil
+	}
+
+	glog.Infof("Function %s load %.2f %s, scaling from %d to %d replicas",
+		functionName, load, policy.scaleType, current, next)
+
+	if err := scaleDeployment(a.kube, function.Namespace, functionName, next); err != nil {
+		return err
+	}
+	state.lastReplicas = next
+
+	return nil
+}
+
+// desiredReplicas returns the replicas required to keep the load of each replica at the target
+func desiredReplicas(load float64, policy scalingPolicy) int32 {
+	desired := int32(math.Ceil(load / policy.target))
+
+	if desired < policy.minReplicas {
+		desired = policy.minReplicas
+	}
+	if desired > policy.maxReplicas {
+		desired = policy.maxReplicas
+	}
+
+	return desired
+}
+
+// nextReplicas returns the replicas to set on the deployment. Scaling up is limited to
+// a step of factor percent of the max replicas, scaling down uses the highest
+// recommendation within the stabilization window.
+func nextReplicas(current, desired int32, recommendations []recommendation, policy scalingPolicy) int32 {
+	if desired > current {
+		step := int32(math.Ceil(float64(policy.maxReplicas) * float64(policy.factor) / 100))
+		if step < 1 {
+			step = 1
+		}
+		if desired > current+step {
+			return current + step
+		}
+		return desired
+	}
+
+	next := desired
+	for _, r := range recommendations {
+		if r.replicas > next {
+			next = r.replicas
+		}
+	}
+	if next > current {
+		return current
+	}
+
+	return next
+}
+
+// pruneRecommendations removes the recommendations made at or before the given time
+func pruneRecommendations(recommendations []recommendation, since time.Time) []recommendation {
+	pruned := recommendations[:0]
+	for _, r := range recommendations {
+		if r.timestamp.After(since) {
+			pruned = append(pruned, r)
+		}
+	}
+	return pruned
+}
+
+// getScalingPolicy reads the autoscaling configuration from the function annotations and labels
+func getScalingPolicy(function *faasv1.Function) (scalingPolicy, bool, error) {
+	// functions with a HorizontalPodAutoscaler are scaled by Kubernetes
+	if function.Spec.Autoscaling != nil {
+		return scalingPolicy{}, false, nil
+	}
+
+	annotations := map[string]string{}
+	if function.Spec.Annotations != nil {
+		annotations = *function.Spec.Annotations
+	}
+
+	targetValue, ok := annotations[AnnotationScaleTarget]
+	if !ok || len(targetValue) == 0 {
+		return scalingPolicy{}, false, nil
+	}
+
+	target, err := strconv.ParseFloat(targetValue, 64)
+	if err != nil || target <= 0 {
+		return scalingPolicy{}, false, fmt.Errorf("%s must be a number greater than zero", AnnotationScaleTarget)
+	}
+
+	policy := scalingPolicy{
+		scaleType:   ScaleTypeRPS,
+		target:      target,
+		minReplicas: defaultMinReplicas,
+		maxReplicas: defaultMaxReplicas,
+		factor:      defaultScaleFactor,
+	}
+
+	if scaleType, ok := annotations[AnnotationScaleType]; ok && len(scaleType) > 0 {
+		if scaleType != ScaleTypeRPS && scaleType != ScaleTypeInflight {
+			return scalingPolicy{}, false, fmt.Errorf("%s must be %s or %s", AnnotationScaleType, ScaleTypeRPS, ScaleTypeInflight)
+		}
+		policy.scaleType = scaleType
+	}
+
+	functionLabels := map[string]string{}
+	if function.Spec.Labels != nil {
+		functionLabels = *function.Spec.Labels
+	}
+
+	if min, ok, err := parseReplicasLabel(functionLabels, controller.LabelMinReplicas); err != nil {
+		return scalingPolicy{}, false, err
+	} else if ok && min > 0 {
+		policy.minReplicas = min
+	}
+
+	if max, ok, err := parseReplicasLabel(functionLabels, LabelMaxReplicas); err != nil {
+		return scalingPolicy{}, false, err
+	} else if ok && max > 0 {
+		policy.maxReplicas = max
+	}
+
+	if factor, ok, err := parseReplicasLabel(functionLabels, LabelScaleFactor); err != nil {
+		return scalingPolicy{}, false, err
+	} else if ok {
+		if factor == 0 {
+			return scalingPolicy{}, false, nil
+		}
+		if factor > 100 {
+			factor = 100
+		}
+		policy.factor = factor
+	}
+
+	if policy.maxReplicas < policy.minReplicas {
+		policy.maxReplicas = policy.minReplicas
+	}
+
+	return policy, true, nil
+}
+
+func parseReplicasLabel(labels map[string]string, key string) (int32, bool, error) {
+	value, ok := labels[key]
+	if !ok || len(value) == 0 {
+		return 0, false, nil
+	}
+
+	r, err := strconv.Atoi(value)
+	if err != nil || r < 0 {
+		return 0, false, fmt.Errorf("%s must be a positive integer", key)
+	}
+
+	return int32(r), true, nil
+}
@@ -0,0 +1,203 @@
+package scaling
+
+import (
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+type testAutoscaler struct {
+	*Autoscaler
+	kube        *fake.Clientset
+	clock       *clock.FakeClock
+	deployments cache.Indexer
+}
+
+func newTestAutoscaler(function *faasv1.Function, deployment *appsv1.Deployment) *testAutoscaler {
+	clk := clock.NewFakeClock(time.Now())
+	kube := fake.NewSimpleClientset(deployment)
+	faas := faasfake.NewSimpleClientset(function)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+
+	deploymentsInformer := kubeInformerFactory.Apps().V1().Deployments()
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+
+	deploymentsInformer.Informer().GetIndexer().Add(deployment)
+	functionsInformer.Informer().GetIndexer().Add(function)
+
+	autoscaler := NewAutoscaler(kube,
+		testNamespace,
+		functionsInformer,
+		deploymentsInformer,
+		NewTracker(clk),
+		clk,
+		time.Second*10,
+		time.Minute*5,
+	)
+
+	return &testAutoscaler{
+		Autoscaler:  autoscaler,
+		kube:        kube,
+		clock:       clk,
+		deployments: deploymentsInformer.Informer().GetIndexer(),
+	}
+}
+
+// step sends the given number of requests and advances the clock by one interval
+func (a *testAutoscaler) step(t *testing.T, requests int) int32 {
+	for i := 0; i < requests; i++ {
+		a.tracker.Begin("nodeinfo")
+		a.tracker.End("nodeinfo")
+	}
+
+	a.clock.Step(a.interval)
+	a.reconcile()
+
+	// keep the lister in sync with the fake clientset
+	replicas := getTestReplicas(t, a.kube, "nodeinfo")
+	a.deployments.Update(newTestDeployment("nodeinfo", replicas))
+
+	return replicas
+}
+
+func Test_Autoscaler_ScalesOnRequestsPerSecond(t *testing.T) {
+	function := newTestFunction("nodeinfo",
+		map[string]string{AnnotationScaleTarget: "10"},
+		map[string]string{LabelMaxReplicas: "10", LabelScaleFactor: "50"})
+
+	a := newTestAutoscaler(function, newTestDeployment("nodeinfo", 1))
+
+	// first reconcile takes the initial sample
+	if replicas := a.step(t, 0); replicas != 1 {
+		t.Fatalf("expected 1 replica after the first sample, got %d", replicas)
+	}
+
+	// 40 rps requires 4 replicas, within a step of 50% of max replicas
+	if replicas := a.step(t, 400); replicas != 4 {
+		t.Fatalf("expected 4 replicas at 40 rps, got %d", replicas)
+	}
+
+	// 200 rps requires 20 replicas, limited by a step of 5 and max replicas of 10
+	if replicas := a.step(t, 2000); replicas != 9 {
+		t.Fatalf("expected 9 replicas after one step at 200 rps, got %d", replicas)
+	}
+	if replicas := a.step(t, 2000); replicas != 10 {
+		t.Fatalf("expected max 10 replicas at 200 rps, got %d", replicas)
+	}
+}
+
+func Test_Autoscaler_ScalesOnInflightRequests(t *testing.T) {
+	function := newTestFunction("nodeinfo",
+		map[string]string{AnnotationScaleTarget: "5", AnnotationScaleType: ScaleTypeInflight},
+		map[string]string{controller.LabelMinReplicas: "2"})
+
+	a := newTestAutoscaler(function, newTestDeployment("nodeinfo", 2))
+	a.step(t, 0)
+
+	for i := 0; i < 15; i++ {
+		a.tracker.Begin("nodeinfo")
+	}
+
+	if replicas := a.step(t, 0); replicas != 3 {
+		t.Fatalf("expected 3 replicas with 15 requests inflight, got %d", replicas)
+	}
+}
+
+func Test_Autoscaler_ScaleDownStabilization(t *testing.T) {
+	function := newTestFunction("nodeinfo",
+		map[string]string{AnnotationScaleTarget: "10"},
+		map[string]string{})
+
+	a := newTestAutoscaler(function, newTestDeployment("nodeinfo", 1))
+	a.step(t, 0)
+
+	if replicas := a.step(t, 500); replicas != 5 {
+		t.Fatalf("expected 5 replicas at 50 rps, got %d", replicas)
+	}
+
+	// the load drops, replicas are kept for the stabilization window
+	for elapsed := time.Duration(0); elapsed < a.stabilizationWindow-a.interval; elapsed += a.interval {
+		if replicas := a.step(t, 0); replicas != 5 {
+			t.Fatalf("expected 5 replicas within the stabilization window after %s, got %d", elapsed, replicas)
+		}
+	}
+
+	if replicas := a.step(t, 0); replicas != 1 {
+		t.Fatalf("expected 1 replica after the stabilization window, got %d", replicas)
+	}
+}
+
+func Test_Autoscaler_KeepsReplicasSetByReplicasAPI(t *testing.T) {
+	function := newTestFunction("nodeinfo",
+		map[string]string{AnnotationScaleTarget: "10"},
+		map[string]string{})
+
+	a := newTestAutoscaler(function, newTestDeployment("nodeinfo", 1))
+	a.step(t, 0)
+	a.clock.Step(a.stabilizationWindow)
+	a.step(t, 0)
+
+	// scale through the replicas API
+	if err := scaleDeployment(a.kube, testNamespace, "nodeinfo", 4); err != nil {
+		t.Fatal(err)
+	}
+	a.deployments.Update(newTestDeployment("nodeinfo", 4))
+
+	if replicas := a.step(t, 0); replicas != 4 {
+		t.Fatalf("expected replicas set through the API to be kept, got %d", replicas)
+	}
+
+	a.clock.Step(a.stabilizationWindow)
+	if replicas := a.step(t, 0); replicas != 1 {
+		t.Fatalf("expected 1 replica after the stabilization window, got %d", replicas)
+	}
+}
+
+func Test_Autoscaler_SkipsFunctions(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		function *faasv1.Function
+		replicas int32
+	}{
+		{
+			"without target annotation",
+			newTestFunction("nodeinfo", map[string]string{}, map[string]string{}),
+			1,
+		},
+		{
+			"with scale factor of zero",
+			newTestFunction("nodeinfo",
+				map[string]string{AnnotationScaleTarget: "1"},
+				map[string]string{LabelScaleFactor: "0"}),
+			1,
+		},
+		{
+			"scaled to zero",
+			newTestFunction("nodeinfo", map[string]string{AnnotationScaleTarget: "1"}, map[string]string{}),
+			0,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			a := newTestAutoscaler(s.function, newTestDeployment("nodeinfo", s.replicas))
+			a.step(t, 0)
+
+			if replicas := a.step(t, 1000); replicas != s.replicas {
+				t.Errorf("expected replicas to stay at %d, got %d", s.replicas, replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package scaling
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	appsinformer "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationZeroDuration is the function annotation that enables scale to zero,
+	// its value is the idle duration after which the function is scaled down e.g. 15m
+	AnnotationZeroDuration = "com.openfaas.scale.zero-duration"
+)
+
+// Idler scales functions to zero replicas when they have not been invoked
+// for longer than the duration set in the AnnotationZeroDuration annotation
+type Idler struct {
+	kube      kubernetes.Interface
+	namespace string
+	tracker   *Tracker
+	clock     clock.PassiveClock
+	interval  time.Duration
+
+	functionsLister   listers.FunctionLister
+	functionsSynced   cache.InformerSynced
+	deploymentsLister appslisters.DeploymentLister
+	deploymentsSynced cache.InformerSynced
+}
+
+// NewIdler returns an Idler that checks the functions in the given namespace
+// for inactivity every interval
+func NewIdler(
+	kube kubernetes.Interface,
+	namespace string,
+	functionsInformer faasinformers.FunctionInformer,
+	deploymentsInformer appsinformer.DeploymentInformer,
+	tracker *Tracker,
+	clock clock.PassiveClock,
+	interval time.Duration) *Idler {
+
+	idler := &Idler{
+		kube:              kube,
+		namespace:         namespace,
+		tracker:           tracker,
+		clock:             clock,
+		interval:          interval,
+		functionsLister:   functionsInformer.Lister(),
+		functionsSynced:   functionsInformer.Informer().HasSynced,
+		deploymentsLister: deploymentsInformer.Lister(),
+		deploymentsSynced: deploymentsInformer.Informer().HasSynced,
+	}
+
+	functionsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if function, ok := obj.(*faasv1.Function); ok {
+				tracker.forget(function.Spec.Name)
+			}
+		},
+	})
+
+	return idler
+}
+
+// Run waits for the informer caches to sync and then checks the functions
+// for inactivity until stopCh is closed
+func (i *Idler) Run(stopCh <-chan struct{}) error {
+	if ok := cache.WaitForCacheSync(stopCh, i.functionsSynced, i.deploymentsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	glog.Infof("Starting idler with interval %s", i.interval)
+	wait.Until(i.reconcile, i.interval, stopCh)
+
+	return nil
+}
+
+// reconcile scales to zero all functions that have been idle for longer than their zero duration
+func (i *Idler) reconcile() {
+	functions, err := i.functionsLister.Functions(i.namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("idler failed to list functions: %v", err))
+		return
+	}
+
+	for _, function := range functions {
+		if err := i.reconcileFunction(function); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+}
+
+func (i *Idler) reconcileFunction(function *faasv1.Function) error {
+	functionName := function.Spec.Name
+
+	idleDuration, ok, err := zeroDuration(function)
+	if err != nil {
+		return fmt.Errorf("function %s has an invalid %s annotation: %v", functionName, AnnotationZeroDuration, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if minReplicas(function) > 0 {
+		glog.V(3).Infof("Function %s has %s set, skipping scale to zero", functionName, controller.LabelMinReplicas)
+		return nil
+	}
+
+	deployment, err := i.deploymentsLister.Deployments(function.Namespace).Get(functionName)
+	if err != nil {
+		return fmt.Errorf("idler failed to get deployment %s: %v", functionName, err)
+	}
+
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		return nil
+	}
+
+	idle := i.clock.Since(i.tracker.observe(functionName))
+	if idle < idleDuration {
+		return nil
+	}
+
+	glog.Infof("Function %s idle for %s, scaling to zero", functionName, idle.Round(time.Second))
+	return scaleDeployment(i.kube, function.Namespace, functionName, 0)
+}
+
+// scaleDeployment sets the replicas of the function deployment
+func scaleDeployment(kube kubernetes.Interface, namespace, functionName string, replicas int32) error {
+	deployment, err := kube.AppsV1().Deployments(namespace).Get(functionName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("function %s get error: %v", functionName, err)
+	}
+
+	deployment.Spec.Replicas = &replicas
+	if _, err := kube.AppsV1().Deployments(namespace).Update(deployment); err != nil {
+		return fmt.Errorf("function %s update error: %v", functionName, err)
+	}
+
+	return nil
+}
+
+// zeroDuration returns the idle duration after which the function can be scaled to zero
+func zeroDuration(function *faasv1.Function) (time.Duration, bool, error) {
+	if function.Spec.Annotations == nil {
+		return 0, false, nil
+	}
+
+	value, ok := (*function.Spec.Annotations)[AnnotationZeroDuration]
+	if !ok || len(value) == 0 {
+		return 0, false, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false, err
+	}
+	if duration <= 0 {
+		return 0, false, fmt.Errorf("duration must be greater than zero")
+	}
+
+	return duration, true, nil
+}
+
+// minReplicas returns the value of the min replicas label or zero when it's not set
+func minReplicas(function *faasv1.Function) int32 {
+	if function.Spec.Labels == nil {
+		return 0
+	}
+
+	return parseMinReplicas(*function.Spec.Labels)
+}
+
+// parseMinReplicas returns the value of the min replicas label or zero when it's not set
+func parseMinReplicas(labels map[string]string) int32 {
+	value, ok := labels[controller.LabelMinReplicas]
+	if !ok {
+		return 0
+	}
+
+	r, err := strconv.Atoi(value)
+	if err != nil || r < 0 {
+		return 0
+	}
+
+	return int32(r)
+}
@@ -0,0 +1,137 @@
+package scaling
+
+import (
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "openfaas-fn"
+
+func newTestFunction(name string, annotations, labels map[string]string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+			Labels:      &labels,
+		},
+	}
+}
+
+func newTestDeployment(name string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+		},
+	}
+}
+
+func newTestIdler(function *faasv1.Function, deployment *appsv1.Deployment, clk clock.PassiveClock) (*Idler, *fake.Clientset) {
+	kube := fake.NewSimpleClientset(deployment)
+	faas := faasfake.NewSimpleClientset(function)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+
+	deploymentsInformer := kubeInformerFactory.Apps().V1().Deployments()
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+
+	deploymentsInformer.Informer().GetIndexer().Add(deployment)
+	functionsInformer.Informer().GetIndexer().Add(function)
+
+	idler := NewIdler(kube, testNamespace, functionsInformer, deploymentsInformer, NewTracker(clk), clk, time.Minute)
+	return idler, kube
+}
+
+func getTestReplicas(t *testing.T, kube *fake.Clientset, name string) int32 {
+	deployment, err := kube.AppsV1().Deployments(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting deployment: %v", err)
+	}
+	return *deployment.Spec.Replicas
+}
+
+func Test_Idler_ScalesIdleFunctionToZero(t *testing.T) {
+	clk := clock.NewFakeClock(time.Now())
+	function := newTestFunction("nodeinfo", map[string]string{AnnotationZeroDuration: "15m"}, nil)
+	idler, kube := newTestIdler(function, newTestDeployment("nodeinfo", 1), clk)
+
+	idler.reconcile()
+	if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 1 {
+		t.Fatalf("expected replicas to be 1 before the idle duration, got %d", replicas)
+	}
+
+	clk.Step(10 * time.Minute)
+	idler.tracker.Record("nodeinfo")
+
+	clk.Step(10 * time.Minute)
+	idler.reconcile()
+	if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 1 {
+		t.Fatalf("expected replicas to be 1 after a recent invocation, got %d", replicas)
+	}
+
+	clk.Step(5 * time.Minute)
+	idler.reconcile()
+	if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 0 {
+		t.Errorf("expected replicas to be 0 after the idle duration, got %d", replicas)
+	}
+}
+
+func Test_Idler_SkipsFunctions(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+	}{
+		{
+			"without zero duration annotation",
+			map[string]string{},
+			nil,
+		},
+		{
+			"with invalid zero duration annotation",
+			map[string]string{AnnotationZeroDuration: "forever"},
+			nil,
+		},
+		{
+			"with min replicas label",
+			map[string]string{AnnotationZeroDuration: "15m"},
+			map[string]string{controller.LabelMinReplicas: "1"},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			clk := clock.NewFakeClock(time.Now())
+			function := newTestFunction("nodeinfo", s.annotations, s.labels)
+			idler, kube := newTestIdler(function, newTestDeployment("nodeinfo", 2), clk)
+
+			idler.reconcile()
+			clk.Step(time.Hour)
+			idler.reconcile()
+
+			if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 2 {
+				t.Errorf("expected replicas to be 2, got %d", replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package scaling
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	glog "k8s.io/klog"
+)
+
+// ErrScaleTimeout is returned when a function has no ready endpoints
+// within the timeout of the scaler
+var ErrScaleTimeout = fmt.Errorf("timed out waiting for function to become ready")
+
+var coldStartHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "operator_cold_start_duration_seconds",
+	Help:    "Time taken to scale a function from zero replicas to a ready endpoint",
+	Buckets: []float64{0.5, 1, 2, 3, 5, 8, 13, 21, 34, 55},
+}, []string{"function_name"})
+
+func init() {
+	prometheus.MustRegister(coldStartHistogram)
+}
+
+// Scaler scales functions from zero replicas when they are invoked
+type Scaler struct {
+	kube      kubernetes.Interface
+	namespace string
+	clock     clock.PassiveClock
+
+	deploymentsLister appslisters.DeploymentLister
+	endpointsLister   corelisters.EndpointsLister
+
+	// timeout is the maximum time to wait for a ready endpoint
+	timeout time.Duration
+	// interval is the time between endpoint readiness checks
+	interval time.Duration
+
+	lock     sync.Mutex
+	inflight map[string]*scaleCall
+}
+
+// scaleCall holds the result of a scale from zero shared by concurrent callers
+type scaleCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewScaler returns a Scaler which waits up to timeout for a function to become ready
+func NewScaler(
+	kube kubernetes.Interface,
+	namespace string,
+	deploymentsLister appslisters.DeploymentLister,
+	endpointsLister corelisters.EndpointsLister,
+	clock clock.PassiveClock,
+	timeout time.Duration) *Scaler {
+
+	return &Scaler{
+		kube:              kube,
+		namespace:         namespace,
+		clock:             clock,
+		deploymentsLister: deploymentsLister,
+		endpointsLister:   endpointsLister,
+		timeout:           timeout,
+		interval:          time.Millisecond * 50,
+		inflight:          map[string]*scaleCall{},
+	}
+}
+
+// Ready blocks until the function has at least one ready endpoint, scaling
+// the function deployment to min replicas when it's scaled to zero.
+// Concurrent calls for the same function share a single scale up, the error
+// of the context is returned when it's done before the function is ready.
+func (s *Scaler) Ready(ctx context.Context, functionName string) error {
+	if s.hasReadyEndpoints(functionName) {
+		return nil
+	}
+
+	s.lock.Lock()
+	call, ok := s.inflight[functionName]
+	if !ok {
+		call = &scaleCall{done: make(chan struct{})}
+		s.inflight[functionName] = call
+
+		go func() {
+			call.err = s.scaleFromZero(functionName)
+
+			s.lock.Lock()
+			delete(s.inflight, functionName)
+			s.lock.Unlock()
+
+			close(call.done)
+		}()
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scaler) scaleFromZero(functionName string) error {
+	deployment, err := s.deploymentsLister.Deployments(s.namespace).Get(functionName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// let the proxy handle functions that do not exist
+			return nil
+		}
+		return err
+	}
+
+	start := s.clock.Now()
+	scaled := false
+
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		replicas := parseMinReplicas(deployment.Spec.Template.Labels)
+		if replicas == 0 {
+			replicas = 1
+		}
+
+		glog.Infof("Function %s is scaled to zero, scaling to %d replicas", functionName, replicas)
+		if err := scaleDeployment(s.kube, s.namespace, functionName, replicas); err != nil {
+			return err
+		}
+		scaled = true
+	}
+
+	err = wait.PollImmediate(s.interval, s.timeout, func() (bool, error) {
+		return s.hasReadyEndpoints(functionName), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return ErrScaleTimeout
+	}
+	if err != nil {
+		return err
+	}
+
+	if scaled {
+		duration := s.clock.Since(start)
+		coldStartHistogram.WithLabelValues(functionName).Observe(duration.Seconds())
+		glog.Infof("Function %s scaled from zero in %fs", functionName, duration.Seconds())
+	}
+
+	return nil
+}
+
+func (s *Scaler) hasReadyEndpoints(functionName string) bool {
+	endpoints, err := s.endpointsLister.Endpoints(s.namespace).Get(functionName)
+	if err != nil {
+		return false
+	}
+
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
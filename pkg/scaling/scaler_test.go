@@ -0,0 +1,163 @@
+package scaling
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestEndpoints(name string, addresses int) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{}
+	for i := 0; i < addresses; i++ {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: "10.0.0.1"})
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Subsets: []corev1.EndpointSubset{subset},
+	}
+}
+
+func newTestScaler(kube *fake.Clientset, timeout time.Duration) (*Scaler, cache.Indexer, cache.Indexer) {
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	deploymentsInformer := kubeInformerFactory.Apps().V1().Deployments()
+	endpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
+
+	scaler := NewScaler(kube,
+		testNamespace,
+		deploymentsInformer.Lister(),
+		endpointsInformer.Lister(),
+		clock.RealClock{},
+		timeout,
+	)
+	scaler.interval = time.Millisecond
+
+	return scaler, deploymentsInformer.Informer().GetIndexer(), endpointsInformer.Informer().GetIndexer()
+}
+
+func countDeploymentUpdates(kube *fake.Clientset) int {
+	updates := 0
+	for _, action := range kube.Actions() {
+		if action.Matches("update", "deployments") {
+			updates++
+		}
+	}
+	return updates
+}
+
+func Test_Scaler_Ready_WithEndpoints(t *testing.T) {
+	deployment := newTestDeployment("nodeinfo", 1)
+	kube := fake.NewSimpleClientset(deployment)
+	scaler, deployments, endpoints := newTestScaler(kube, time.Second)
+	deployments.Add(deployment)
+	endpoints.Add(newTestEndpoints("nodeinfo", 1))
+
+	if err := scaler.Ready(context.Background(), "nodeinfo"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if updates := countDeploymentUpdates(kube); updates != 0 {
+		t.Errorf("expected no deployment updates, got %d", updates)
+	}
+}
+
+func Test_Scaler_Ready_ScalesFromZeroOnce(t *testing.T) {
+	deployment := newTestDeployment("nodeinfo", 0)
+	deployment.Spec.Template.Labels = map[string]string{controller.LabelMinReplicas: "2"}
+
+	kube := fake.NewSimpleClientset(deployment)
+	scaler, deployments, endpoints := newTestScaler(kube, time.Second*5)
+	deployments.Add(deployment)
+
+	// make an endpoint ready once the deployment has been scaled up
+	kube.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			endpoints.Add(newTestEndpoints("nodeinfo", 1))
+		}()
+		return false, nil, nil
+	})
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- scaler.Ready(context.Background(), "nodeinfo")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+
+	if updates := countDeploymentUpdates(kube); updates != 1 {
+		t.Errorf("expected a single deployment update, got %d", updates)
+	}
+
+	if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 2 {
+		t.Errorf("expected replicas to be scaled to min replicas 2, got %d", replicas)
+	}
+}
+
+func Test_Scaler_Ready_Timeout(t *testing.T) {
+	deployment := newTestDeployment("nodeinfo", 0)
+	kube := fake.NewSimpleClientset(deployment)
+	scaler, deployments, _ := newTestScaler(kube, time.Millisecond*20)
+	deployments.Add(deployment)
+
+	if err := scaler.Ready(context.Background(), "nodeinfo"); err != ErrScaleTimeout {
+		t.Fatalf("expected error %v, got: %v", ErrScaleTimeout, err)
+	}
+
+	if replicas := getTestReplicas(t, kube, "nodeinfo"); replicas != 1 {
+		t.Errorf("expected replicas to be scaled to 1, got %d", replicas)
+	}
+}
+
+func Test_Scaler_Ready_Cancelled(t *testing.T) {
+	deployment := newTestDeployment("nodeinfo", 0)
+	kube := fake.NewSimpleClientset(deployment)
+	scaler, deployments, _ := newTestScaler(kube, time.Second*5)
+	deployments.Add(deployment)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	start := time.Now()
+	if err := scaler.Ready(ctx, "nodeinfo"); err != context.DeadlineExceeded {
+		t.Fatalf("expected error %v, got: %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the caller to return once its context is done, waited %s", elapsed)
+	}
+}
+
+func Test_Scaler_Ready_FunctionNotFound(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	scaler, _, _ := newTestScaler(kube, time.Millisecond*20)
+
+	if err := scaler.Ready(context.Background(), "nodeinfo"); err != nil {
+		t.Fatalf("expected no error for a missing function, got: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package scaling
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// Tracker records the invocations of each function as seen by the function proxy
+type Tracker struct {
+	clock clock.PassiveClock
+
+	lock      sync.RWMutex
+	functions map[string]*invocations
+}
+
+// Stats is a snapshot of the invocations of a function
+type Stats struct {
+	// Inflight is the number of requests currently being processed
+	Inflight int64
+	// Requests is the total number of requests received
+	Requests uint64
+	// LastInvocation is the time of the last request
+	LastInvocation time.Time
+}
+
+type invocations struct {
+	inflight       int64
+	requests       uint64
+	lastInvocation time.Time
+}
+
+// NewTracker returns a Tracker which reads the current time from the given clock
+func NewTracker(clock clock.PassiveClock) *Tracker {
+	return &Tracker{
+		clock:     clock,
+		functions: map[string]*invocations{},
+	}
+}
+
+// Record marks the function as invoked at the current time
+func (t *Tracker) Record(functionName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.get(functionName).lastInvocation = t.clock.Now()
+}
+
+// Begin records the start of a request for a function,
+// End must be called once the request has completed
+func (t *Tracker) Begin(functionName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	fn := t.get(functionName)
+	fn.inflight++
+	fn.requests++
+	fn.lastInvocation = t.clock.Now()
+}
+
+// End records the completion of a request for a function
+func (t *Tracker) End(functionName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	fn := t.get(functionName)
+	if fn.inflight > 0 {
+		fn.inflight--
+	}
+	fn.lastInvocation = t.clock.Now()
+}
+
+// LastInvocation returns the time of the last recorded invocation for a function
+func (t *Tracker) LastInvocation(functionName string) (time.Time, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	fn, ok := t.functions[functionName]
+	if !ok {
+		return time.Time{}, false
+	}
+	return fn.lastInvocation, true
+}
+
+// Stats returns a snapshot of the invocations of a function
+func (t *Tracker) Stats(functionName string) Stats {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	fn, ok := t.functions[functionName]
+	if !ok {
+		return Stats{}
+	}
+
+	return Stats{
+		Inflight:       fn.inflight,
+		Requests:       fn.requests,
+		LastInvocation: fn.lastInvocation,
+	}
+}
+
+// observe returns the time of the last invocation for a function. When the function
+// has never been invoked the current time is stored, so that the idle period of a
+// function starts from the first time it is observed and not from the zero time.
+// Functions with requests inflight are always considered active.
+func (t *Tracker) observe(functionName string) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	fn, ok := t.functions[functionName]
+	if !ok {
+		fn = t.get(functionName)
+		fn.lastInvocation = t.clock.Now()
+	}
+	if fn.inflight > 0 {
+		return t.clock.Now()
+	}
+	return fn.lastInvocation
+}
+
+// forget removes a function from the tracker
+func (t *Tracker) forget(functionName string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.functions, functionName)
+}
+
+// get returns the invocations of a function, the lock must be held by the caller
+func (t *Tracker) get(functionName string) *invocations {
+	fn, ok := t.functions[functionName]
+	if !ok {
+		fn = &invocations{}
+		t.functions[functionName] = fn
+	}
+	return fn
+}
@@ -0,0 +1,351 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	clientset "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+	"github.com/robfig/cron/v3"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationSchedule is the function annotation holding a cron expression e.g. */5 * * * *
+	AnnotationSchedule = "com.openfaas.schedule"
+	// AnnotationTimezone is the timezone of the schedule e.g. Europe/London, defaults to UTC
+	AnnotationTimezone = "com.openfaas.schedule.timezone"
+	// AnnotationPayload is the body sent to the function on each scheduled invocation
+	AnnotationPayload = "com.openfaas.schedule.payload"
+	// AnnotationConcurrencyPolicy is either ConcurrencyAllow (default) or ConcurrencyForbid
+	AnnotationConcurrencyPolicy = "com.openfaas.schedule.concurrency"
+
+	// ConcurrencyAllow starts a scheduled invocation while the previous one is still running
+	ConcurrencyAllow = "allow"
+	// ConcurrencyForbid skips a scheduled invocation while the previous one is still running
+	ConcurrencyForbid = "forbid"
+
+	// maxMissedRuns bounds the search for the latest missed run of a schedule
+	maxMissedRuns = 100000
+)
+
+var parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Scheduler invokes functions through the function proxy on the cron
+// schedule set in their AnnotationSchedule annotation
+type Scheduler struct {
+	client    clientset.Interface
+	namespace string
+	invoker   http.Handler
+	clock     clock.PassiveClock
+	interval  time.Duration
+	// missedRunDeadline is how late a run missed during a restart can still be started
+	missedRunDeadline time.Duration
+
+	functionsLister listers.FunctionLister
+	functionsSynced cache.InformerSynced
+
+	lock    sync.Mutex
+	entries map[string]*entry
+	// inflight is the number of scheduled invocations in progress for each function
+	inflight map[string]int
+	// running tracks the invocations in progress, so that Run can wait for them
+	running sync.WaitGroup
+}
+
+// entry is the schedule of a function
+type entry struct {
+	expression string
+	timezone   string
+	schedule   cron.Schedule
+	// last is the time of the last scheduled run
+	last time.Time
+}
+
+// NewScheduler returns a Scheduler which checks the schedules of the functions
+// in the namespace every interval and invokes them with the invoker, usually
+// the router serving /function/{name}
+func NewScheduler(
+	client clientset.Interface,
+	namespace string,
+	functionsInformer faasinformers.FunctionInformer,
+	invoker http.Handler,
+	clock clock.PassiveClock,
+	interval time.Duration,
+	missedRunDeadline time.Duration) *Scheduler {
+
+	return &Scheduler{
+		client:            client,
+		namespace:         namespace,
+		invoker:           invoker,
+		clock:             clock,
+		interval:          interval,
+		missedRunDeadline: missedRunDeadline,
+		functionsLister:   functionsInformer.Lister(),
+		functionsSynced:   functionsInformer.Informer().HasSynced,
+		entries:           map[string]*entry{},
+		inflight:          map[string]int{},
+	}
+}
+
+// Run waits for the informer cache to sync and checks the schedules until stopCh
+// is closed, it returns once the scheduled invocations in progress have completed
+func (s *Scheduler) Run(stopCh <-chan struct{}) error {
+	if ok := cache.WaitForCacheSync(stopCh, s.functionsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	glog.Infof("Starting scheduler with interval %s", s.interval)
+	wait.Until(s.reconcile, s.interval, stopCh)
+
+	s.running.Wait()
+	return nil
+}
+
+// reconcile starts the runs of the functions which are due and records them in
+// the status of the functions
+func (s *Scheduler) reconcile() {
+	functions, err := s.functionsLister.Functions(s.namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("scheduler failed to list functions: %v", err))
+		return
+	}
+
+	// the status is updated once the lock is released so that the invocations
+	// which complete don't wait for the API server
+	for _, run := range s.startRuns(functions) {
+		if err := s.updateStatus(run.function, run.scheduled); err != nil {
+			runtime.HandleError(err)
+		}
+	}
+}
+
+// scheduledRun is a run of a function which is recorded in its status
+type scheduledRun struct {
+	function  *faasv1.Function
+	scheduled time.Time
+}
+
+// startRuns starts the runs of the functions which are due, it returns the runs
+// which are started or skipped
+func (s *Scheduler) startRuns(functions []*faasv1.Function) []scheduledRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	runs := []scheduledRun{}
+	scheduled := map[string]bool{}
+	for _, function := range functions {
+		if _, ok := annotation(function, AnnotationSchedule); !ok {
+			continue
+		}
+
+		scheduled[function.Name] = true
+		due, err := s.reconcileFunction(function)
+		if err != nil {
+			runtime.HandleError(err)
+			continue
+		}
+		if !due.IsZero() {
+			runs = append(runs, scheduledRun{function: function, scheduled: due})
+		}
+	}
+
+	for name := range s.entries {
+		if !scheduled[name] {
+			delete(s.entries, name)
+		}
+	}
+	return runs
+}
+
+// reconcileFunction starts the run of a function when it is due, it returns the time
+// of the run which is started or skipped, or zero when no run is due
+func (s *Scheduler) reconcileFunction(function *faasv1.Function) (time.Time, error) {
+	now := s.clock.Now()
+
+	e, err := s.getEntry(function, now)
+	if err != nil {
+		delete(s.entries, function.Name)
+		return time.Time{}, fmt.Errorf("function %s has an invalid schedule: %v", function.Spec.Name, err)
+	}
+
+	// find the latest run that is due, runs missed while the operator
+	// was not running are started once when within the deadline
+	due := time.Time{}
+	for next, i := e.schedule.Next(e.last), 0; !next.After(now) && i < maxMissedRuns; next, i = e.schedule.Next(next), i+1 {
+		due = next
+	}
+	if due.IsZero() {
+		return due, nil
+	}
+
+	e.last = due
+
+	if late := now.Sub(due); late > s.missedRunDeadline {
+		glog.Warningf("Function %s missed its run at %s by %s, skipping", function.Spec.Name, due, late.Round(time.Second))
+		return due, nil
+	}
+
+	policy, ok := annotation(function, AnnotationConcurrencyPolicy)
+	if ok && policy != ConcurrencyAllow && policy != ConcurrencyForbid {
+		return time.Time{}, fmt.Errorf("function %s has an invalid %s annotation: %s", function.Spec.Name, AnnotationConcurrencyPolicy, policy)
+	}
+	if policy == ConcurrencyForbid && s.inflight[function.Name] > 0 {
+		glog.Infof("Function %s is still running, skipping its run at %s", function.Spec.Name, due)
+		return due, nil
+	}
+
+	payload, _ := annotation(function, AnnotationPayload)
+
+	s.inflight[function.Name]++
+	s.running.Add(1)
+	go func(name string) {
+		defer s.running.Done()
+		s.invoke(function.Spec.Name, payload, due)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.inflight[name]--; s.inflight[name] <= 0 {
+			delete(s.inflight, name)
+		}
+	}(function.Name)
+
+	return due, nil
+}
+
+// getEntry returns the schedule of a function, the schedule is recreated
+// when the expression or the timezone of the function have changed
+func (s *Scheduler) getEntry(function *faasv1.Function, now time.Time) (*entry, error) {
+	expression, _ := annotation(function, AnnotationSchedule)
+	timezone, ok := annotation(function, AnnotationTimezone)
+	if !ok {
+		timezone = "UTC"
+	}
+
+	e, ok := s.entries[function.Name]
+	if ok && e.expression == expression && e.timezone == timezone {
+		return e, nil
+	}
+
+	schedule, err := parseSchedule(expression, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	// new schedules start from the last run recorded in the status so that
+	// runs missed during a restart are detected, or from now for new functions
+	last := now
+	if function.Status.LastScheduleTime != nil {
+		last = function.Status.LastScheduleTime.Time
+	}
+
+	if ok {
+		last = e.last
+	}
+
+	e = &entry{
+		expression: expression,
+		timezone:   timezone,
+		schedule:   schedule,
+		last:       last,
+	}
+	s.entries[function.Name] = e
+	return e, nil
+}
+
+// invoke calls the function through the invoker with the payload of the schedule
+func (s *Scheduler) invoke(functionName, payload string, scheduled time.Time) {
+	req, err := http.NewRequest(http.MethodPost, "/function/"+functionName, bytes.NewBufferString(payload))
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	req.Header.Set("X-Scheduled-Time", scheduled.UTC().Format(time.RFC3339))
+
+	w := &statusRecorder{header: http.Header{}, statusCode: http.StatusOK}
+	s.invoker.ServeHTTP(w, req)
+
+	if w.statusCode >= http.StatusBadRequest {
+		glog.Errorf("Scheduled run of %s at %s returned %d", functionName, scheduled, w.statusCode)
+		return
+	}
+	glog.V(2).Infof("Scheduled run of %s at %s returned %d", functionName, scheduled, w.statusCode)
+}
+
+// updateStatus records the time of the last scheduled run of a function
+func (s *Scheduler) updateStatus(function *faasv1.Function, scheduled time.Time) error {
+	updated := function.DeepCopy()
+	updated.Status.LastScheduleTime = &metav1.Time{Time: scheduled}
+
+	if _, err := s.client.OpenfaasV1().Functions(function.Namespace).UpdateStatus(updated); err != nil {
+		return fmt.Errorf("function %s status update error: %v", function.Spec.Name, err)
+	}
+	return nil
+}
+
+// parseSchedule parses a standard cron expression evaluated in the timezone
+func parseSchedule(expression, timezone string) (cron.Schedule, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := parser.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec, ok := schedule.(*cron.SpecSchedule); ok {
+		spec.Location = location
+	}
+	return schedule, nil
+}
+
+// annotation returns the value of a function annotation
+func annotation(function *faasv1.Function, name string) (string, bool) {
+	if function.Spec.Annotations == nil {
+		return "", false
+	}
+
+	value, ok := (*function.Spec.Annotations)[name]
+	if !ok || len(value) == 0 {
+		return "", false
+	}
+	return value, true
+}
+
+// statusRecorder is an http.ResponseWriter which discards the response body
+type statusRecorder struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.statusCode = statusCode
+	r.wroteHeader = true
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return len(data), nil
+}
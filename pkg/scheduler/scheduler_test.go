@@ -0,0 +1,308 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "openfaas-fn"
+
+// testInvoker records the invocations made by the scheduler
+type testInvoker struct {
+	lock        sync.Mutex
+	invocations []*http.Request
+	payloads    []string
+	// release blocks the invocations until it's closed when set
+	release chan struct{}
+}
+
+func (i *testInvoker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	i.lock.Lock()
+	i.invocations = append(i.invocations, r)
+	i.payloads = append(i.payloads, string(body))
+	release := i.release
+	i.lock.Unlock()
+
+	if release != nil {
+		<-release
+	}
+}
+
+func (i *testInvoker) count() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return len(i.invocations)
+}
+
+type testScheduler struct {
+	*Scheduler
+	clock   *clock.FakeClock
+	faas    *faasfake.Clientset
+	invoker *testInvoker
+}
+
+func newTestFunction(annotations map[string]string, lastScheduleTime *time.Time) *faasv1.Function {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: testNamespace,
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        "nodeinfo",
+			Image:       "functions/nodeinfo",
+			Annotations: &annotations,
+		},
+	}
+	if lastScheduleTime != nil {
+		function.Status.LastScheduleTime = &metav1.Time{Time: *lastScheduleTime}
+	}
+	return function
+}
+
+func newTestScheduler(function *faasv1.Function, now time.Time) *testScheduler {
+	clk := clock.NewFakeClock(now)
+	faas := faasfake.NewSimpleClientset(function)
+
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	functionsInformer.Informer().GetIndexer().Add(function)
+
+	invoker := &testInvoker{}
+
+	return &testScheduler{
+		Scheduler: NewScheduler(faas, testNamespace, functionsInformer, invoker, clk, time.Second, time.Minute*10),
+		clock:     clk,
+		faas:      faas,
+		invoker:   invoker,
+	}
+}
+
+// step advances the clock and waits for the scheduled invocations to complete
+func (s *testScheduler) step(d time.Duration) {
+	s.clock.Step(d)
+	s.reconcile()
+	s.running.Wait()
+}
+
+// waitForInvocations waits for the goroutines of the scheduler to reach the invoker
+func (s *testScheduler) waitForInvocations(count int) {
+	for i := 0; i < 50 && s.invoker.count() < count; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (s *testScheduler) lastScheduleTime(t *testing.T) *metav1.Time {
+	function, err := s.faas.OpenfaasV1().Functions(testNamespace).Get("nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return function.Status.LastScheduleTime
+}
+
+func Test_Scheduler_InvokesOnSchedule(t *testing.T) {
+	start := time.Date(2020, 3, 1, 10, 0, 30, 0, time.UTC)
+	function := newTestFunction(map[string]string{
+		AnnotationSchedule: "*/5 * * * *",
+		AnnotationPayload:  `{"report": "daily"}`,
+	}, nil)
+
+	s := newTestScheduler(function, start)
+
+	s.step(0)
+	if count := s.invoker.count(); count != 0 {
+		t.Fatalf("expected no invocation before the first run, got %d", count)
+	}
+
+	// 10:04:30
+	s.step(time.Minute * 4)
+	if count := s.invoker.count(); count != 0 {
+		t.Fatalf("expected no invocation before 10:05, got %d", count)
+	}
+
+	// 10:05:30
+	s.step(time.Minute)
+	if count := s.invoker.count(); count != 1 {
+		t.Fatalf("expected one invocation at 10:05, got %d", count)
+	}
+
+	r := s.invoker.invocations[0]
+	if r.URL.Path != "/function/nodeinfo" || r.Method != http.MethodPost {
+		t.Errorf("expected POST /function/nodeinfo, got: %s %s", r.Method, r.URL.Path)
+	}
+	if s.invoker.payloads[0] != `{"report": "daily"}` {
+		t.Errorf("expected the payload of the schedule, got: %s", s.invoker.payloads[0])
+	}
+	if scheduled := r.Header.Get("X-Scheduled-Time"); scheduled != "2020-03-01T10:05:00Z" {
+		t.Errorf("expected X-Scheduled-Time 2020-03-01T10:05:00Z, got: %s", scheduled)
+	}
+
+	last := s.lastScheduleTime(t)
+	if last == nil || !last.Time.Equal(time.Date(2020, 3, 1, 10, 5, 0, 0, time.UTC)) {
+		t.Errorf("expected last schedule time 10:05, got: %v", last)
+	}
+
+	// 10:06:30
+	s.step(time.Minute)
+	if count := s.invoker.count(); count != 1 {
+		t.Fatalf("expected a single invocation until 10:10, got %d", count)
+	}
+}
+
+func Test_Scheduler_Timezone(t *testing.T) {
+	// 09:30 in London during daylight saving time
+	start := time.Date(2020, 6, 1, 8, 30, 0, 0, time.UTC)
+	function := newTestFunction(map[string]string{
+		AnnotationSchedule: "0 10 * * *",
+		AnnotationTimezone: "Europe/London",
+	}, nil)
+
+	s := newTestScheduler(function, start)
+	s.step(0)
+
+	s.step(time.Minute * 29)
+	if count := s.invoker.count(); count != 0 {
+		t.Fatalf("expected no invocation before 10:00 in London, got %d", count)
+	}
+
+	s.step(time.Minute)
+	if count := s.invoker.count(); count != 1 {
+		t.Fatalf("expected one invocation at 10:00 in London, got %d", count)
+	}
+}
+
+func Test_Scheduler_RunsMissedRunOnce(t *testing.T) {
+	last := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	function := newTestFunction(map[string]string{AnnotationSchedule: "* * * * *"}, &last)
+
+	// the operator restarts 5 minutes after the last run
+	s := newTestScheduler(function, last.Add(time.Minute*5+time.Second*30))
+	s.step(0)
+
+	if count := s.invoker.count(); count != 1 {
+		t.Fatalf("expected the missed runs to be started once, got %d", count)
+	}
+
+	if scheduled := s.invoker.invocations[0].Header.Get("X-Scheduled-Time"); scheduled != "2020-03-01T10:05:00Z" {
+		t.Errorf("expected the latest missed run at 10:05, got: %s", scheduled)
+	}
+}
+
+func Test_Scheduler_SkipsMissedRunPastDeadline(t *testing.T) {
+	last := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	function := newTestFunction(map[string]string{AnnotationSchedule: "0 * * * *"}, &last)
+
+	// the 11:00 run is missed by more than the deadline of 10 minutes
+	s := newTestScheduler(function, last.Add(time.Minute*75))
+	s.step(0)
+
+	if count := s.invoker.count(); count != 0 {
+		t.Fatalf("expected a run missed past the deadline to be skipped, got %d", count)
+	}
+
+	lastScheduled := s.lastScheduleTime(t)
+	if lastScheduled == nil || !lastScheduled.Time.Equal(last.Add(time.Hour)) {
+		t.Errorf("expected the skipped run to be recorded, got: %v", lastScheduled)
+	}
+}
+
+func Test_Scheduler_ConcurrencyPolicy(t *testing.T) {
+	scenarios := []struct {
+		policy      string
+		invocations int
+	}{
+		{ConcurrencyAllow, 2},
+		{ConcurrencyForbid, 1},
+	}
+
+	for _, sc := range scenarios {
+		t.Run(sc.policy, func(t *testing.T) {
+			start := time.Date(2020, 3, 1, 10, 0, 30, 0, time.UTC)
+			function := newTestFunction(map[string]string{
+				AnnotationSchedule:          "* * * * *",
+				AnnotationConcurrencyPolicy: sc.policy,
+			}, nil)
+
+			s := newTestScheduler(function, start)
+			s.invoker.release = make(chan struct{})
+			s.reconcile()
+
+			s.clock.Step(time.Minute)
+			s.reconcile()
+			s.waitForInvocations(1)
+
+			// the first invocation is still running at the second run
+			s.clock.Step(time.Minute)
+			s.reconcile()
+			s.waitForInvocations(sc.invocations)
+
+			close(s.invoker.release)
+			s.running.Wait()
+
+			if count := s.invoker.count(); count != sc.invocations {
+				t.Fatalf("expected %d invocations, got %d", sc.invocations, count)
+			}
+		})
+	}
+}
+
+func Test_Scheduler_InvalidSchedule(t *testing.T) {
+	function := newTestFunction(map[string]string{AnnotationSchedule: "every minute"}, nil)
+
+	s := newTestScheduler(function, time.Now())
+	s.step(0)
+	s.step(time.Hour)
+
+	if count := s.invoker.count(); count != 0 {
+		t.Fatalf("expected no invocation for an invalid schedule, got %d", count)
+	}
+	if len(s.entries) != 0 {
+		t.Errorf("expected no schedule entry, got: %v", s.entries)
+	}
+}
+
+func Test_Scheduler_UpdatesStatusWithoutLock(t *testing.T) {
+	start := time.Date(2020, 3, 1, 10, 4, 30, 0, time.UTC)
+	function := newTestFunction(map[string]string{AnnotationSchedule: "*/5 * * * *"}, nil)
+	s := newTestScheduler(function, start)
+
+	locked := false
+	s.faas.PrependReactor("update", "functions", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		acquired := make(chan struct{})
+		go func() {
+			s.lock.Lock()
+			s.lock.Unlock()
+			close(acquired)
+		}()
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			locked = true
+		}
+		return false, nil, nil
+	})
+
+	s.step(0)
+	// 10:05:30
+	s.step(time.Minute)
+	if locked {
+		t.Errorf("expected the status to be updated without holding the lock of the scheduler")
+	}
+	if last := s.lastScheduleTime(t); last == nil {
+		t.Errorf("expected the last schedule time to be recorded")
+	}
+}
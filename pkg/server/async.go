@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	glog "k8s.io/klog"
+)
+
+// maxAsyncBodySize is the limit of the bodies of the async invocations and of the messages
+// published to topics, which are kept until they are delivered, it is the default max payload of NATS
+const maxAsyncBodySize = 1000 * 1024
+
+// makeAsyncHandler enqueues invocations made to /async-function/{name} and
+// responds with 202 Accepted and the X-Call-Id of the invocation. Bodies larger
+// than the max body size of the function or than maxAsyncBodySize get 413.
+func makeAsyncHandler(q queue.Queue, policies policyLookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		maxBodySize := int64(maxAsyncBodySize)
+		if policy := policies.Policy(functionName); policy.maxBodySize > 0 && policy.maxBodySize < maxBodySize {
+			maxBodySize = policy.maxBodySize
+		}
+
+		body, ok := readBody(w, r, maxBodySize, functionName)
+		if !ok {
+			return
+		}
+
+		callID := r.Header.Get("X-Call-Id")
+		if len(callID) == 0 {
+			callID = string(uuid.NewUUID())
+		}
+
+		path := ""
+		if params, ok := vars["params"]; ok {
+			path = "/" + params
+		}
+
+		// the credentials of the caller aren't written to the queue nor to the dead letters
+		header := r.Header.Clone()
+		header.Del("X-Callback-Url")
+		header.Del("Authorization")
+
+		req := &queue.Request{
+			CallID:      callID,
+			Function:    functionName,
+			Method:      r.Method,
+			Path:        path,
+			QueryString: r.URL.RawQuery,
+			Header:      header,
+			Body:        body,
+			CallbackURL: r.Header.Get("X-Callback-Url"),
+			EnqueuedAt:  time.Now(),
+		}
+
+		if err := q.Enqueue(req); err != nil {
+			glog.Errorf("Function %s async enqueue error: %v", functionName, err)
+
+			status := http.StatusInternalServerError
+			if err == queue.ErrQueueFull {
+				status = http.StatusServiceUnavailable
+			}
+
+			w.WriteHeader(status)
+			w.Write([]byte(fmt.Sprintf("Unable to enqueue request for %s: %s", functionName, err.Error())))
+			return
+		}
+
+		glog.V(2).Infof("Enqueued async request %s for %s", callID, functionName)
+
+		w.Header().Set("X-Call-Id", callID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// readBody reads the body of the request up to maxBodySize bytes, the larger requests get
+// 413 and false is returned when the body could not be read
+func readBody(w http.ResponseWriter, r *http.Request, maxBodySize int64, target string) ([]byte, bool) {
+	if r.ContentLength > maxBodySize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("Request body exceeds the limit of %d bytes for: %s.", maxBodySize, target)))
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		// the reader stops at the limit when the body is larger
+		if int64(len(body)) == maxBodySize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("Request body exceeds the limit of %d bytes for: %s.", maxBodySize, target)))
+			return nil, false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	return body, true
+}
+
+// makeDeadLetterHandler lists the async invocations which failed after all of
+// their attempts, the list can be filtered with the function query parameter
+func makeDeadLetterHandler(deadLetters *queue.DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := r.URL.Query().Get("function")
+
+		res, err := json.Marshal(deadLetters.List(functionName))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
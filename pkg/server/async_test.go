@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+)
+
+func newAsyncRouter(q queue.Queue) *mux.Router {
+	return newAsyncRouterWithPolicy(q, proxyPolicy{})
+}
+
+func newAsyncRouterWithPolicy(q queue.Queue, policy proxyPolicy) *mux.Router {
+	r := mux.NewRouter()
+	handler := makeAsyncHandler(q, testPolicy(policy))
+	r.HandleFunc("/async-function/{name}", handler)
+	r.HandleFunc("/async-function/{name}/{params:.*}", handler)
+	return r
+}
+
+func Test_makeAsyncHandler_EnqueuesRequest(t *testing.T) {
+	q := queue.NewMemoryQueue(1)
+	router := newAsyncRouter(q)
+
+	req := httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo/users/1?verbose=true", bytes.NewBufferString("payload"))
+	req.Header.Set("X-Callback-Url", "http://callback:8080/")
+	req.Header.Set("X-Custom", "value")
+	req.Header.Set("Authorization", "Basic YWRtaW46c2VjcmV0")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", w.Code)
+	}
+
+	callID := w.Header().Get("X-Call-Id")
+	if len(callID) == 0 {
+		t.Errorf("expected a call id to be generated")
+	}
+
+	queued, ok := q.Dequeue(nil)
+	if !ok {
+		t.Fatalf("expected the request to be enqueued")
+	}
+
+	if queued.CallID != callID || queued.Function != "nodeinfo" || queued.Method != http.MethodPost {
+		t.Errorf("unexpected request: %+v", queued)
+	}
+	if queued.Path != "/users/1" || queued.QueryString != "verbose=true" {
+		t.Errorf("expected path /users/1 and query verbose=true, got: %s %s", queued.Path, queued.QueryString)
+	}
+	if string(queued.Body) != "payload" {
+		t.Errorf("expected body payload, got: %s", string(queued.Body))
+	}
+	if queued.CallbackURL != "http://callback:8080/" || len(queued.Header.Get("X-Callback-Url")) > 0 {
+		t.Errorf("expected the callback url to be moved out of the headers, got: %s %v", queued.CallbackURL, queued.Header)
+	}
+	if queued.Header.Get("X-Custom") != "value" {
+		t.Errorf("expected the headers to be enqueued, got: %v", queued.Header)
+	}
+	if len(queued.Header.Get("Authorization")) > 0 {
+		t.Errorf("expected the Authorization header not to be enqueued, got: %v", queued.Header)
+	}
+}
+
+func Test_makeAsyncHandler_QueueFull(t *testing.T) {
+	q := queue.NewMemoryQueue(1)
+	q.Enqueue(&queue.Request{})
+	router := newAsyncRouter(q)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", w.Code)
+	}
+}
+
+func Test_makeAsyncHandler_BodyTooLarge(t *testing.T) {
+	cases := []struct {
+		name          string
+		policy        proxyPolicy
+		body          string
+		contentLength int64
+		expected      int
+	}{
+		{name: "body within the limit of the function", policy: proxyPolicy{maxBodySize: 8}, body: "payload", contentLength: 7, expected: http.StatusAccepted},
+		{name: "content length over the limit of the function", policy: proxyPolicy{maxBodySize: 4}, body: "payload", contentLength: 7, expected: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over the limit of the function", policy: proxyPolicy{maxBodySize: 4}, body: "payload", contentLength: -1, expected: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over the async limit", body: strings.Repeat("a", maxAsyncBodySize+1), contentLength: -1, expected: http.StatusRequestEntityTooLarge},
+		{name: "function limit over the async limit", policy: proxyPolicy{maxBodySize: 2 * maxAsyncBodySize}, body: strings.Repeat("a", maxAsyncBodySize+1), contentLength: -1, expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := queue.NewMemoryQueue(1)
+			router := newAsyncRouterWithPolicy(q, tc.policy)
+
+			req := httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo", strings.NewReader(tc.body))
+			req.ContentLength = tc.contentLength
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.expected {
+				t.Fatalf("expected status %d, got %d", tc.expected, w.Code)
+			}
+			stopCh := make(chan struct{})
+			close(stopCh)
+			if _, queued := q.Dequeue(stopCh); queued != (tc.expected == http.StatusAccepted) {
+				t.Errorf("expected the request to be enqueued: %v", tc.expected == http.StatusAccepted)
+			}
+		})
+	}
+}
+
+func Test_makeDeadLetterHandler(t *testing.T) {
+	deadLetters := queue.NewDeadLetters(10)
+	deadLetters.Add(queue.DeadLetter{Request: &queue.Request{CallID: "call-1", Function: "nodeinfo"}, StatusCode: 502})
+	deadLetters.Add(queue.DeadLetter{Request: &queue.Request{CallID: "call-2", Function: "figlet"}, StatusCode: 503})
+
+	w := httptest.NewRecorder()
+	makeDeadLetterHandler(deadLetters)(w, httptest.NewRequest(http.MethodGet, "/system/dead-letters?function=figlet", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	letters := []queue.DeadLetter{}
+	if err := json.Unmarshal(w.Body.Bytes(), &letters); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 1 || letters[0].Request.CallID != "call-2" || letters[0].StatusCode != 503 {
+		t.Errorf("expected the dead letter of figlet, got: %+v", letters)
+	}
+}
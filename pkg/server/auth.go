@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/auth"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes"
+	glog "k8s.io/klog"
+)
+
+const defaultSecretMountPath = "/var/openfaas/secrets/"
+
+// authentication checks the credentials of the requests made to the API, the
+// challenges are returned to the clients which don't send valid credentials
+type authentication struct {
+	authenticator auth.Authenticators
+	challenges    []string
+	// functions is true when the invocations of functions are authenticated too
+	functions bool
+}
+
+// newAuthentication reads the authentication of the API from the environment: basic_auth
+// reads the credentials from the secret_mount_path, token_auth reviews bearer tokens with
+// the API server for the token_auth_audiences and function_auth applies the authentication
+// to the invocations of functions. Nil is returned when the API is not authenticated,
+// the methods of a nil authentication leave the handlers as they are.
+func newAuthentication(kube kubernetes.Interface) (*authentication, error) {
+	a := &authentication{
+		functions: os.Getenv("function_auth") == "true",
+	}
+
+	if os.Getenv("basic_auth") == "true" {
+		secretMountPath := defaultSecretMountPath
+		if val, exists := os.LookupEnv("secret_mount_path"); exists && len(val) > 0 {
+			secretMountPath = val
+		}
+
+		basic, err := auth.NewBasicAuthenticator(secretMountPath)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticator = append(a.authenticator, basic)
+		a.challenges = append(a.challenges, `Basic realm="Restricted"`)
+	}
+
+	if os.Getenv("token_auth") == "true" {
+		var audiences []string
+		for _, audience := range strings.Split(os.Getenv("token_auth_audiences"), ",") {
+			if audience = strings.TrimSpace(audience); len(audience) > 0 {
+				audiences = append(audiences, audience)
+			}
+		}
+		a.authenticator = append(a.authenticator, auth.NewTokenAuthenticator(kube, audiences, clock.RealClock{}))
+		a.challenges = append(a.challenges, `Bearer realm="Restricted"`)
+	}
+
+	if len(a.authenticator) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Decorate authenticates the requests of the handler, the user is added to the context
+// of the request. The requests made by the operator itself are not authenticated.
+func (a *authentication) Decorate(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.UserFrom(r.Context()); ok {
+			next(w, r)
+			return
+		}
+
+		user, err := a.authenticator.Authenticate(r)
+		if err != nil {
+			if err != auth.ErrNoCredentials && err != auth.ErrInvalidCredentials {
+				glog.Errorf("Authentication of %s %s error: %v", r.Method, r.URL.Path, err)
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Unable to check the credentials of the request."))
+				return
+			}
+
+			for _, challenge := range a.challenges {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid credentials"))
+			return
+		}
+
+		next(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	}
+}
+
+// DecorateFunction authenticates the invocations of functions when function_auth is set,
+// the credentials of the caller are removed from the request before it reaches the function
+func (a *authentication) DecorateFunction(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil || !a.functions {
+		return next
+	}
+	return a.Decorate(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Authorization")
+		next(w, r)
+	})
+}
+
+// DecorateHandlers authenticates the handlers of the provider API, the health
+// endpoint stays open for the probes of the kubelet
+func (a *authentication) DecorateHandlers(handlers *types.FaaSHandlers) {
+	if a == nil {
+		return
+	}
+	handlers.FunctionProxy = a.DecorateFunction(handlers.FunctionProxy)
+	handlers.FunctionReader = a.Decorate(handlers.FunctionReader)
+	handlers.DeployHandler = a.Decorate(handlers.DeployHandler)
+	handlers.DeleteHandler = a.Decorate(handlers.DeleteHandler)
+	handlers.UpdateHandler = a.Decorate(handlers.UpdateHandler)
+	handlers.ReplicaReader = a.Decorate(handlers.ReplicaReader)
+	handlers.ReplicaUpdater = a.Decorate(handlers.ReplicaUpdater)
+	handlers.InfoHandler = a.Decorate(handlers.InfoHandler)
+	handlers.SecretHandler = a.Decorate(handlers.SecretHandler)
+	handlers.LogHandler = a.Decorate(handlers.LogHandler)
+	handlers.ListNamespaceHandler = a.Decorate(handlers.ListNamespaceHandler)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/auth"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// setEnv sets an environment variable until the returned func restores its previous value
+func setEnv(name, value string) func() {
+	previous, exists := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if exists {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// newTestAuthentication returns the authentication of the admin user with basic auth and
+// tokens, the returned func restores the environment and removes the basic auth secret
+func newTestAuthentication(t *testing.T, functionAuth string) (*authentication, func()) {
+	dir, err := ioutil.TempDir("", "basic-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "basic-auth-user"), []byte("admin"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "basic-auth-password"), []byte("secret"), 0600)
+
+	restoreEnv := []func(){
+		setEnv("basic_auth", "true"),
+		setEnv("secret_mount_path", dir),
+		setEnv("token_auth", "true"),
+		setEnv("function_auth", functionAuth),
+	}
+	teardown := func() {
+		for _, restore := range restoreEnv {
+			restore()
+		}
+		os.RemoveAll(dir)
+	}
+
+	a, err := newAuthentication(fake.NewSimpleClientset())
+	if err != nil {
+		teardown()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return a, teardown
+}
+
+// newStubHandlers sets each handler of the provider to a stub which records the user of the request
+func newStubHandlers(users map[string]string) *types.FaaSHandlers {
+	handlers := &types.FaaSHandlers{}
+	value := reflect.ValueOf(handlers).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		value.Field(i).Set(reflect.ValueOf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			users[name] = ""
+			if user, ok := auth.UserFrom(r.Context()); ok {
+				users[name] = user.Name
+			}
+		})))
+	}
+	return handlers
+}
+
+func Test_newAuthentication_Disabled(t *testing.T) {
+	defer setEnv("basic_auth", "false")()
+	defer setEnv("token_auth", "false")()
+
+	a, err := newAuthentication(fake.NewSimpleClientset())
+	if err != nil || a != nil {
+		t.Fatalf("expected no authentication, got %+v %v", a, err)
+	}
+
+	// a nil authentication leaves the handlers as they are
+	invoked := false
+	a.Decorate(func(w http.ResponseWriter, r *http.Request) { invoked = true })(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/system/functions", nil))
+	if !invoked {
+		t.Errorf("expected the handler to be invoked without authentication")
+	}
+}
+
+func Test_newAuthentication_MissingSecret(t *testing.T) {
+	defer setEnv("basic_auth", "true")()
+	defer setEnv("secret_mount_path", filepath.Join(os.TempDir(), "missing-basic-auth"))()
+
+	if _, err := newAuthentication(fake.NewSimpleClientset()); err == nil {
+		t.Errorf("expected an error when the basic auth secret is not mounted")
+	}
+}
+
+func Test_authentication_DecorateHandlers(t *testing.T) {
+	for _, functionAuth := range []string{"false", "true"} {
+		t.Run("function_auth="+functionAuth, func(t *testing.T) {
+			users := map[string]string{}
+			handlers := newStubHandlers(users)
+			a, teardown := newTestAuthentication(t, functionAuth)
+			defer teardown()
+			a.DecorateHandlers(handlers)
+
+			value := reflect.ValueOf(handlers).Elem()
+			for i := 0; i < value.NumField(); i++ {
+				name := value.Type().Field(i).Name
+				handler := value.Field(i).Interface().(http.HandlerFunc)
+
+				open := name == "HealthHandler" || (name == "FunctionProxy" && functionAuth != "true")
+
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+				if _, invoked := users[name]; invoked != open {
+					t.Errorf("%s: expected the request without credentials to be invoked: %v, got status %d", name, open, w.Code)
+				}
+				if !open {
+					if w.Code != http.StatusUnauthorized {
+						t.Errorf("%s: expected status 401, got %d", name, w.Code)
+					}
+					if got := w.Header()["Www-Authenticate"]; len(got) != 2 {
+						t.Errorf("%s: expected the basic and bearer challenges, got %v", name, got)
+					}
+				}
+
+				delete(users, name)
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.SetBasicAuth("admin", "wrong")
+				handler(httptest.NewRecorder(), r)
+				if _, invoked := users[name]; invoked != open {
+					t.Errorf("%s: expected the request with a wrong password to be invoked: %v", name, open)
+				}
+
+				r = httptest.NewRequest(http.MethodGet, "/", nil)
+				r.SetBasicAuth("admin", "secret")
+				handler(httptest.NewRecorder(), r)
+				if user, invoked := users[name]; !invoked || (!open && user != "admin") {
+					t.Errorf("%s: expected the request with valid credentials to be invoked by admin, got %q", name, user)
+				}
+			}
+		})
+	}
+}
+
+func Test_authentication_InternalRequests(t *testing.T) {
+	a, teardown := newTestAuthentication(t, "true")
+	defer teardown()
+
+	var user *auth.User
+	handler := auth.Internal(a.DecorateFunction(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = auth.UserFrom(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/nodeinfo", nil))
+	if user == nil || user.Name != auth.InternalUser {
+		t.Errorf("expected the invocation of the operator to skip the authentication, got %+v", user)
+	}
+}
+
+func Test_authentication_DecorateFunction_RemovesCredentials(t *testing.T) {
+	a, teardown := newTestAuthentication(t, "true")
+	defer teardown()
+
+	var header http.Header
+	handler := a.DecorateFunction(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/function/nodeinfo", nil)
+	r.SetBasicAuth("admin", "secret")
+	r.Header.Set("X-Api-Key", "key")
+	handler(httptest.NewRecorder(), r)
+
+	if header == nil {
+		t.Fatalf("expected the authenticated request to reach the function")
+	}
+	if got := header.Get("Authorization"); len(got) > 0 {
+		t.Errorf("expected the credentials not to be forwarded to the function, got %q", got)
+	}
+	if got := header.Get("X-Api-Key"); got != "key" {
+		t.Errorf("expected the other headers to be forwarded, got %q", got)
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/auth"
+
+	lru "github.com/hashicorp/golang-lru"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes"
+	glog "k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// The verbs of the API which are authorized
+const (
+	VerbDeploy  = "deploy"
+	VerbDelete  = "delete"
+	VerbScale   = "scale"
+	VerbSecrets = "secrets"
+	VerbLogs    = "logs"
+	VerbInvoke  = "invoke"
+	VerbRead    = "read"
+)
+
+const (
+	// AuthorizerSubjectAccessReview authorizes the requests with the RBAC of the cluster
+	AuthorizerSubjectAccessReview = "rbac"
+	// AuthorizerPolicy authorizes the requests with the rules of a policy file
+	AuthorizerPolicy = "policy"
+
+	// reviewCacheSize is the number of access reviews kept, the decisions
+	// are reviewed again after reviewCacheTTL or when they are evicted
+	reviewCacheSize = 1024
+	reviewCacheTTL  = 10 * time.Second
+)
+
+var verbs = []string{VerbDeploy, VerbDelete, VerbScale, VerbSecrets, VerbLogs, VerbInvoke, VerbRead}
+
+// Authorizer decides whether a user can use a verb of the API in a namespace
+type Authorizer interface {
+	Authorize(user *auth.User, verb, namespace string) (allowed bool, err error)
+}
+
+// authorization authorizes the authenticated requests of the handlers for the
+// namespace of the functions, which is the only namespace served by the operator
+type authorization struct {
+	authorizer Authorizer
+	namespace  string
+}
+
+// newAuthorization reads the authorizer from the authorization environment variable, either
+// rbac or policy with the rules in the authorization_policy file. Nil is returned when
+// the requests are not authorized, all the authenticated users can use the whole API.
+func newAuthorization(kube kubernetes.Interface, namespace string) (*authorization, error) {
+	var authorizer Authorizer
+	switch mode := os.Getenv("authorization"); mode {
+	case "":
+		return nil, nil
+	case AuthorizerSubjectAccessReview:
+		authorizer = NewSubjectAccessReviewAuthorizer(kube, clock.RealClock{})
+	case AuthorizerPolicy:
+		policy, err := ReadPolicyFile(os.Getenv("authorization_policy"))
+		if err != nil {
+			return nil, err
+		}
+		authorizer = policy
+	default:
+		return nil, fmt.Errorf("invalid authorization configured: %s", mode)
+	}
+
+	return &authorization{authorizer: authorizer, namespace: namespace}, nil
+}
+
+// Decorate checks that the user of the request can use the verb in the namespace targeted by the
+// request, the requests for the other namespaces are rejected as the operator only serves the
+// function namespace. The requests of the operator itself and the requests which are not
+// authenticated, for example the invocations of functions without function_auth, are not authorized.
+func (a *authorization) Decorate(verb string, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.UserFrom(r.Context())
+		if !ok || auth.IsInternal(user) {
+			next(w, r)
+			return
+		}
+
+		namespace, err := requestNamespace(verb, r)
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unable to read the request."))
+			return
+		}
+		if len(namespace) == 0 {
+			namespace = a.namespace
+		}
+		if namespace != a.namespace {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Namespace %s is not served, use: %s.", namespace, a.namespace)))
+			return
+		}
+
+		allowed, err := a.authorizer.Authorize(user, verb, namespace)
+		if err != nil {
+			glog.Errorf("Authorization of %s to %s in %s error: %v", user.Name, verb, namespace, err)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Unable to authorize the request."))
+			return
+		}
+
+		if !allowed {
+			glog.V(2).Infof("User %s is not allowed to %s in %s", user.Name, verb, namespace)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("User %s is not allowed to %s in: %s.", user.Name, verb, namespace)))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requestNamespace returns the namespace of the namespace query or of the JSON body of the
+// requests which change the functions and their secrets, empty when the request has none.
+// The query and the body of the invocations belong to the functions so they are not read.
+func requestNamespace(verb string, r *http.Request) (string, error) {
+	if verb == VerbInvoke {
+		return "", nil
+	}
+	if namespace := r.URL.Query().Get("namespace"); len(namespace) > 0 {
+		return namespace, nil
+	}
+	if r.Body == nil || r.Method == http.MethodGet {
+		return "", nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// the handlers reply to the bodies which are not valid
+	target := struct {
+		Namespace string `json:"namespace"`
+	}{}
+	json.Unmarshal(body, &target)
+	return target.Namespace, nil
+}
+
+// DecorateHandlers authorizes the handlers of the provider API with their verb, the
+// functions and their replicas are read with the read verb. The namespaces, the info
+// and the health of the provider are allowed for all the authenticated users.
+func (a *authorization) DecorateHandlers(handlers *types.FaaSHandlers) {
+	if a == nil {
+		return
+	}
+	handlers.FunctionProxy = a.Decorate(VerbInvoke, handlers.FunctionProxy)
+	handlers.FunctionReader = a.Decorate(VerbRead, handlers.FunctionReader)
+	handlers.ReplicaReader = a.Decorate(VerbRead, handlers.ReplicaReader)
+	handlers.DeployHandler = a.Decorate(VerbDeploy, handlers.DeployHandler)
+	handlers.UpdateHandler = a.Decorate(VerbDeploy, handlers.UpdateHandler)
+	handlers.DeleteHandler = a.Decorate(VerbDelete, handlers.DeleteHandler)
+	handlers.ReplicaUpdater = a.Decorate(VerbScale, handlers.ReplicaUpdater)
+	handlers.SecretHandler = a.Decorate(VerbSecrets, handlers.SecretHandler)
+	handlers.LogHandler = a.Decorate(VerbLogs, handlers.LogHandler)
+}
+
+// SubjectAccessReviewAuthorizer authorizes the users with the RBAC of the cluster, the verbs
+// are checked on the functions.openfaas.com resource of the namespace, for example a Role
+// with the invoke verb on functions allows a user to invoke the functions of the namespace.
+// The decisions are cached for a short time so that the API server is not called for
+// each request.
+type SubjectAccessReviewAuthorizer struct {
+	kube    kubernetes.Interface
+	clock   clock.PassiveClock
+	reviews *lru.Cache
+}
+
+type accessReview struct {
+	allowed bool
+	expires time.Time
+}
+
+// NewSubjectAccessReviewAuthorizer creates a SubjectAccessReviewAuthorizer
+func NewSubjectAccessReviewAuthorizer(kube kubernetes.Interface, clock clock.PassiveClock) *SubjectAccessReviewAuthorizer {
+	reviews, _ := lru.New(reviewCacheSize)
+	return &SubjectAccessReviewAuthorizer{
+		kube:    kube,
+		clock:   clock,
+		reviews: reviews,
+	}
+}
+
+// Authorize returns the decision of a SubjectAccessReview
+func (a *SubjectAccessReviewAuthorizer) Authorize(user *auth.User, verb, namespace string) (bool, error) {
+	groups := append([]string{}, user.Groups...)
+	sort.Strings(groups)
+	key := strings.Join([]string{user.Name, user.UID, strings.Join(groups, ","), verb, namespace}, "\x00")
+
+	now := a.clock.Now()
+	if cached, ok := a.reviews.Get(key); ok {
+		review := cached.(*accessReview)
+		if now.Before(review.expires) {
+			return review.allowed, nil
+		}
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for name, values := range user.Extra {
+		extra[name] = values
+	}
+
+	result, err := a.kube.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Name,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     "openfaas.com",
+				Resource:  "functions",
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("subject access review failed: %v", err)
+	}
+
+	a.reviews.Add(key, &accessReview{allowed: result.Status.Allowed, expires: now.Add(reviewCacheTTL)})
+	return result.Status.Allowed, nil
+}
+
+// PolicyRule allows the users and the members of the groups to use the verbs in the
+// namespaces, * matches all the users, verbs or namespaces
+type PolicyRule struct {
+	Users      []string `json:"users,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Namespaces []string `json:"namespaces"`
+	Verbs      []string `json:"verbs"`
+}
+
+// Policy authorizes the users with static rules, a request is allowed when one of the rules allows it
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// ReadPolicyFile reads a policy from a YAML or JSON file
+func ReadPolicyFile(path string) (*Policy, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("authorization_policy must be the path of a policy file")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the policy file: %v", err)
+	}
+
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("unable to parse the policy file %s: %v", path, err)
+	}
+
+	for i, rule := range policy.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("rule %d of the policy must have users or groups", i)
+		}
+		if len(rule.Namespaces) == 0 || len(rule.Verbs) == 0 {
+			return nil, fmt.Errorf("rule %d of the policy must have namespaces and verbs", i)
+		}
+		for _, verb := range rule.Verbs {
+			if verb != "*" && !contains(verbs, verb) {
+				return nil, fmt.Errorf("rule %d of the policy has an invalid verb %s, use one of %s or *", i, verb, strings.Join(verbs, ", "))
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Authorize never returns an error
+func (p *Policy) Authorize(user *auth.User, verb, namespace string) (bool, error) {
+	for _, rule := range p.Rules {
+		subject := matches(rule.Users, user.Name)
+		for _, group := range user.Groups {
+			subject = subject || matches(rule.Groups, group)
+		}
+
+		if subject && matches(rule.Namespaces, namespace) && matches(rule.Verbs, verb) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matches(values []string, value string) bool {
+	return contains(values, "*") || contains(values, value)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-operator/pkg/auth"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const teamsPolicy = `
+rules:
+- groups: ["team-a"]
+  namespaces: ["team-a-fn"]
+  verbs: ["deploy", "delete", "scale", "secrets", "logs", "invoke"]
+- users: ["ci"]
+  namespaces: ["*"]
+  verbs: ["deploy"]
+- users: ["*"]
+  namespaces: ["public-fn"]
+  verbs: ["invoke"]
+`
+
+// writePolicyFile writes the policy to a file which is removed by the returned func
+func writePolicyFile(t *testing.T, policy string) (string, func()) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "policy.yaml")
+	ioutil.WriteFile(path, []byte(policy), 0600)
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// stubAuthorizer allows the verbs in the list
+type stubAuthorizer struct {
+	verbs []string
+	err   error
+}
+
+func (a stubAuthorizer) Authorize(user *auth.User, verb, namespace string) (bool, error) {
+	return contains(a.verbs, verb), a.err
+}
+
+// authorizerFunc authorizes with a func
+type authorizerFunc func(user *auth.User, verb, namespace string) (bool, error)
+
+func (f authorizerFunc) Authorize(user *auth.User, verb, namespace string) (bool, error) {
+	return f(user, verb, namespace)
+}
+
+func withUser(r *http.Request, name string) *http.Request {
+	return r.WithContext(auth.WithUser(r.Context(), &auth.User{Name: name}))
+}
+
+func Test_Policy_Authorize(t *testing.T) {
+	path, removePolicy := writePolicyFile(t, teamsPolicy)
+	defer removePolicy()
+
+	policy, err := ReadPolicyFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alice := &auth.User{Name: "alice", Groups: []string{"system:authenticated", "team-a"}}
+	bob := &auth.User{Name: "bob", Groups: []string{"system:authenticated", "team-b"}}
+	ci := &auth.User{Name: "ci"}
+
+	cases := []struct {
+		name      string
+		user      *auth.User
+		verb      string
+		namespace string
+		expected  bool
+	}{
+		{name: "team member deploys in the team namespace", user: alice, verb: VerbDeploy, namespace: "team-a-fn", expected: true},
+		{name: "team member reads the secrets of the team", user: alice, verb: VerbSecrets, namespace: "team-a-fn", expected: true},
+		{name: "other team deploys in the team namespace", user: bob, verb: VerbDeploy, namespace: "team-a-fn"},
+		{name: "other team reads the secrets of the team", user: bob, verb: VerbSecrets, namespace: "team-a-fn"},
+		{name: "team member deploys in another namespace", user: alice, verb: VerbDeploy, namespace: "team-b-fn"},
+		{name: "user deploys in all the namespaces", user: ci, verb: VerbDeploy, namespace: "team-b-fn", expected: true},
+		{name: "user deletes in all the namespaces", user: ci, verb: VerbDelete, namespace: "team-b-fn"},
+		{name: "everyone invokes the public functions", user: bob, verb: VerbInvoke, namespace: "public-fn", expected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			allowed, err := policy.Authorize(tc.user, tc.verb, tc.namespace)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allowed != tc.expected {
+				t.Errorf("expected allowed %v, got %v", tc.expected, allowed)
+			}
+		})
+	}
+}
+
+func Test_ReadPolicyFile_Invalid(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy string
+	}{
+		{name: "unknown verb", policy: "rules:\n- users: [alice]\n  namespaces: ['*']\n  verbs: [admin]\n"},
+		{name: "no subjects", policy: "rules:\n- namespaces: ['*']\n  verbs: [invoke]\n"},
+		{name: "no namespaces", policy: "rules:\n- users: [alice]\n  verbs: [invoke]\n"},
+		{name: "unknown field", policy: "rules:\n- user: alice\n  namespaces: ['*']\n  verbs: [invoke]\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, removePolicy := writePolicyFile(t, tc.policy)
+			defer removePolicy()
+			if _, err := ReadPolicyFile(path); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+
+	if _, err := ReadPolicyFile(filepath.Join(os.TempDir(), "missing-policy.yaml")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func Test_SubjectAccessReviewAuthorizer(t *testing.T) {
+	reviews := []*authorizationv1.SubjectAccessReview{}
+	kube := fake.NewSimpleClientset()
+	kube.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		reviews = append(reviews, review)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb == VerbInvoke
+		return true, review, nil
+	})
+
+	fakeClock := clock.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := NewSubjectAccessReviewAuthorizer(kube, fakeClock)
+	user := &auth.User{Name: "alice", UID: "1", Groups: []string{"team-a"}, Extra: map[string][]string{"scopes": {"fn"}}}
+
+	if allowed, err := a.Authorize(user, VerbInvoke, "team-a-fn"); err != nil || !allowed {
+		t.Errorf("expected invoke to be allowed, got %v %v", allowed, err)
+	}
+	if allowed, err := a.Authorize(user, VerbSecrets, "team-a-fn"); err != nil || allowed {
+		t.Errorf("expected secrets to be denied, got %v %v", allowed, err)
+	}
+
+	expected := authorizationv1.SubjectAccessReviewSpec{
+		User:   "alice",
+		UID:    "1",
+		Groups: []string{"team-a"},
+		Extra:  map[string]authorizationv1.ExtraValue{"scopes": {"fn"}},
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: "team-a-fn",
+			Verb:      VerbInvoke,
+			Group:     "openfaas.com",
+			Resource:  "functions",
+		},
+	}
+	if !reflect.DeepEqual(reviews[0].Spec, expected) {
+		t.Errorf("expected the review %+v, got %+v", expected, reviews[0].Spec)
+	}
+
+	// the decisions are cached
+	a.Authorize(user, VerbInvoke, "team-a-fn")
+	if len(reviews) != 2 {
+		t.Errorf("expected 2 reviews, got %d", len(reviews))
+	}
+	fakeClock.Step(reviewCacheTTL)
+	a.Authorize(user, VerbInvoke, "team-a-fn")
+	if len(reviews) != 3 {
+		t.Errorf("expected the decision to be reviewed again after the cache TTL, got %d reviews", len(reviews))
+	}
+}
+
+func Test_authorization_Decorate(t *testing.T) {
+	a := &authorization{authorizer: stubAuthorizer{verbs: []string{VerbInvoke}}, namespace: "openfaas-fn"}
+
+	invoked := false
+	next := func(w http.ResponseWriter, r *http.Request) { invoked = true }
+
+	cases := []struct {
+		name     string
+		verb     string
+		request  *http.Request
+		expected int
+	}{
+		{name: "allowed verb", verb: VerbInvoke, request: withUser(httptest.NewRequest(http.MethodPost, "/", nil), "alice"), expected: http.StatusOK},
+		{name: "denied verb", verb: VerbDeploy, request: withUser(httptest.NewRequest(http.MethodPost, "/", nil), "alice"), expected: http.StatusForbidden},
+		{name: "user named as the operator", verb: VerbDeploy, request: withUser(httptest.NewRequest(http.MethodPost, "/", nil), auth.InternalUser), expected: http.StatusForbidden},
+		{name: "not authenticated", verb: VerbDeploy, request: httptest.NewRequest(http.MethodPost, "/", nil), expected: http.StatusOK},
+		{name: "served namespace query", verb: VerbInvoke, request: withUser(httptest.NewRequest(http.MethodGet, "/?namespace=openfaas-fn", nil), "alice"), expected: http.StatusOK},
+		{name: "other namespace query", verb: VerbLogs, request: withUser(httptest.NewRequest(http.MethodGet, "/?namespace=team-b-fn", nil), "alice"), expected: http.StatusBadRequest},
+		{name: "other namespace body", verb: VerbDeploy, request: withUser(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"service":"nodeinfo","namespace":"team-b-fn"}`)), "alice"), expected: http.StatusBadRequest},
+		{name: "query of an invocation", verb: VerbInvoke, request: withUser(httptest.NewRequest(http.MethodPost, "/?namespace=team-b-fn", nil), "alice"), expected: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			invoked = false
+			w := httptest.NewRecorder()
+			a.Decorate(tc.verb, next)(w, tc.request)
+			if w.Code != tc.expected || invoked != (tc.expected == http.StatusOK) {
+				t.Errorf("expected status %d, got %d and invoked %v", tc.expected, w.Code, invoked)
+			}
+		})
+	}
+
+	invoked = false
+	auth.Internal(a.Decorate(VerbDeploy, next)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !invoked {
+		t.Errorf("expected the requests of the operator to be allowed")
+	}
+
+	a.authorizer = stubAuthorizer{err: fmt.Errorf("connection refused")}
+	w := httptest.NewRecorder()
+	a.Decorate(VerbInvoke, next)(w, withUser(httptest.NewRequest(http.MethodPost, "/", nil), "alice"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 for an authorizer error, got %d", w.Code)
+	}
+}
+
+func Test_authorization_Decorate_Namespace(t *testing.T) {
+	namespaces := []string{}
+	a := &authorization{authorizer: authorizerFunc(func(user *auth.User, verb, namespace string) (bool, error) {
+		namespaces = append(namespaces, namespace)
+		return true, nil
+	}), namespace: "openfaas-fn"}
+
+	body := ""
+	next := func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}
+
+	deployment := `{"service":"nodeinfo","namespace":"openfaas-fn"}`
+	w := httptest.NewRecorder()
+	a.Decorate(VerbDeploy, next)(w, withUser(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(deployment)), "alice"))
+	if w.Code != http.StatusOK || body != deployment {
+		t.Errorf("expected the body to be passed to the handler, got status %d and body %q", w.Code, body)
+	}
+
+	a.Decorate(VerbRead, next)(httptest.NewRecorder(), withUser(httptest.NewRequest(http.MethodGet, "/", nil), "alice"))
+	a.Decorate(VerbLogs, next)(httptest.NewRecorder(), withUser(httptest.NewRequest(http.MethodGet, "/?namespace=team-b-fn", nil), "alice"))
+	expected := []string{"openfaas-fn", "openfaas-fn"}
+	if !reflect.DeepEqual(namespaces, expected) {
+		t.Errorf("expected the namespaces %v to be authorized, got %v", expected, namespaces)
+	}
+}
+
+func Test_authorization_DecorateHandlers(t *testing.T) {
+	expected := map[string]string{
+		"FunctionProxy":  VerbInvoke,
+		"FunctionReader": VerbRead,
+		"ReplicaReader":  VerbRead,
+		"DeployHandler":  VerbDeploy,
+		"UpdateHandler":  VerbDeploy,
+		"DeleteHandler":  VerbDelete,
+		"ReplicaUpdater": VerbScale,
+		"SecretHandler":  VerbSecrets,
+		"LogHandler":     VerbLogs,
+	}
+
+	for _, verb := range verbs {
+		t.Run(verb, func(t *testing.T) {
+			users := map[string]string{}
+			handlers := newStubHandlers(users)
+			a := &authorization{authorizer: stubAuthorizer{verbs: []string{verb}}, namespace: "openfaas-fn"}
+			a.DecorateHandlers(handlers)
+
+			value := reflect.ValueOf(handlers).Elem()
+			for i := 0; i < value.NumField(); i++ {
+				name := value.Type().Field(i).Name
+				handler := value.Field(i).Interface().(http.HandlerFunc)
+
+				handlerVerb, authorized := expected[name]
+				allowed := !authorized || handlerVerb == verb
+
+				w := httptest.NewRecorder()
+				handler(w, withUser(httptest.NewRequest(http.MethodGet, "/", nil), "alice"))
+				if _, invoked := users[name]; invoked != allowed {
+					t.Errorf("%s: expected the request to be allowed: %v, got status %d", name, allowed, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func Test_newAuthorization(t *testing.T) {
+	defer setEnv("authorization", "")()
+	if a, err := newAuthorization(fake.NewSimpleClientset(), "openfaas-fn"); a != nil || err != nil {
+		t.Errorf("expected no authorization by default, got %+v %v", a, err)
+	}
+
+	defer setEnv("authorization", AuthorizerPolicy)()
+	path, removePolicy := writePolicyFile(t, teamsPolicy)
+	defer removePolicy()
+	defer setEnv("authorization_policy", path)()
+	a, err := newAuthorization(fake.NewSimpleClientset(), "team-a-fn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := a.authorizer.(*Policy); !ok || a.namespace != "team-a-fn" {
+		t.Errorf("expected the policy authorizer for team-a-fn, got %+v", a)
+	}
+
+	defer setEnv("authorization", AuthorizerSubjectAccessReview)()
+	if a, err := newAuthorization(fake.NewSimpleClientset(), "openfaas-fn"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, ok := a.authorizer.(*SubjectAccessReviewAuthorizer); !ok {
+		t.Errorf("expected the SubjectAccessReview authorizer, got %T", a.authorizer)
+	}
+
+	defer setEnv("authorization", "abac")()
+	if _, err := newAuthorization(fake.NewSimpleClientset(), "openfaas-fn"); err == nil {
+		t.Errorf("expected an error for an invalid authorization")
+	}
+}
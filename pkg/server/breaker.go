@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/breaker"
+)
+
+// outlierRecorder counts the results of the requests to each endpoint of a function
+type outlierRecorder interface {
+	RecordEndpoint(functionName, address string, success bool)
+}
+
+// makeCircuitBreaker rejects the requests of functions with an open circuit with 503 straight
+// away, the other requests are counted as failures when they end with a 5xx status code
+func makeCircuitBreaker(breakers *breaker.Breakers, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 {
+			next(w, r)
+			return
+		}
+
+		done, err := breakers.Allow(functionName)
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(fmt.Sprintf("Circuit breaker is open for: %s.", functionName)))
+			return
+		}
+
+		writer := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(writer, r)
+
+		if r.Context().Err() == context.Canceled {
+			// requests cancelled by the client say nothing about the function
+			return
+		}
+		done(writer.statusCode < http.StatusInternalServerError)
+	}
+}
+
+// outlierTransport counts the result of each attempt made to an endpoint of a function
+type outlierTransport struct {
+	next         http.RoundTripper
+	outliers     outlierRecorder
+	functionName string
+	body         *limitedBody
+}
+
+func (t *outlierTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+
+	if req.Context().Err() == context.Canceled || (t.body != nil && t.body.Exceeded()) {
+		return res, err
+	}
+	t.outliers.RecordEndpoint(t.functionName, req.URL.Host, err == nil && res.StatusCode < http.StatusInternalServerError)
+	return res, err
+}
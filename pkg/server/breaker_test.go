@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/breaker"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+// testOutliers records the results given for each endpoint
+type testOutliers struct {
+	lock    sync.Mutex
+	results map[string][]bool
+}
+
+func (o *testOutliers) RecordEndpoint(functionName, address string, success bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.results[address] = append(o.results[address], success)
+}
+
+func Test_makeCircuitBreaker(t *testing.T) {
+	faasInformerFactory := informers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	functionsInformer.Informer().GetIndexer().Add(newPolicyFunction("failing", map[string]string{
+		breaker.AnnotationConsecutiveFailures: "2",
+	}))
+
+	breakers := breaker.NewBreakers("openfaas-fn", functionsInformer.Lister().Functions("openfaas-fn"), record.NewFakeRecorder(10), clock.RealClock{})
+
+	invoked := 0
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeCircuitBreaker(breakers, func(w http.ResponseWriter, r *http.Request) {
+		invoked++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/failing", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected the status of the function, got %d", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/failing", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 once the circuit is open, got %d", w.Code)
+	}
+	if invoked != 2 {
+		t.Errorf("expected the function not to be invoked while the circuit is open, got %d invocations", invoked)
+	}
+}
+
+func Test_makeProxy_RecordsEndpointResults(t *testing.T) {
+	status := http.StatusOK
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer stub.Close()
+	stubURL, _ := url.Parse(stub.URL)
+
+	outliers := &testOutliers{results: map[string][]bool{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeProxy(testResolver{url: stubURL},
+		testPolicy{timeout: time.Second, retryAttempts: 2, retryBackoff: time.Millisecond}, outliers, time.Second))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+	status = http.StatusBadGateway
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	// each attempt of the retries is recorded
+	expected := []bool{true, false, false}
+	got := outliers.results[stubURL.Host]
+	if len(got) != len(expected) {
+		t.Fatalf("expected results %v for %s, got %v", expected, stubURL.Host, outliers.results)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected results %v, got %v", expected, got)
+		}
+	}
+}
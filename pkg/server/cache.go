@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/responsecache"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+)
+
+// proxyHeaders are set by the proxy for each invocation and are not cached
+var proxyHeaders = []string{"X-Cache", "X-Call-Id", "X-Start-Time", "X-Duration-Seconds"}
+
+// makeResponseCache serves the GET requests of functions with a cache annotation from the
+// cache and caches the 200 responses of the function. Cache hits don't reach the function,
+// they are recorded as invocations for the idler but not as load for the autoscaler.
+func makeResponseCache(responses *responsecache.Cache, tracker *scaling.Tracker, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 || r.Method != http.MethodGet || isStream(r) {
+			next(w, r)
+			return
+		}
+
+		policy, generation := responses.Policy(functionName)
+		if policy == nil || !policy.Cacheable(r) {
+			next(w, r)
+			return
+		}
+
+		lookup, store := responsecache.Directives(r)
+		key := responsecache.Key(policy, r)
+
+		if lookup {
+			if entry, ok := responses.Get(functionName, key); ok {
+				tracker.Record(functionName)
+				writeCachedResponse(w, r, entry)
+				return
+			}
+		}
+
+		if !store {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-Cache", "MISS")
+		writer := &cacheWriter{
+			responseWriter: responseWriter{ResponseWriter: w, statusCode: http.StatusOK},
+			limit:          policy.MaxSize,
+		}
+		next(writer, r)
+
+		if writer.statusCode != http.StatusOK || writer.exceeded || r.Context().Err() != nil {
+			return
+		}
+
+		maxAge, ok := policy.MaxAge(w.Header())
+		if !ok {
+			return
+		}
+
+		header := w.Header().Clone()
+		for _, name := range proxyHeaders {
+			header.Del(name)
+		}
+		responses.Set(functionName, generation, key, &responsecache.Entry{
+			StatusCode: writer.statusCode,
+			Header:     header,
+			Body:       writer.body.Bytes(),
+		}, maxAge)
+	}
+}
+
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, entry *responsecache.Entry) {
+	for name, values := range entry.Header {
+		w.Header()[name] = append([]string{}, values...)
+	}
+	if callID := r.Header.Get("X-Call-Id"); len(callID) > 0 {
+		w.Header().Set("X-Call-Id", callID)
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	w.WriteHeader(entry.StatusCode)
+	w.Write(entry.Body)
+}
+
+// cacheWriter keeps a copy of the body written to the client, up to the limit
+type cacheWriter struct {
+	responseWriter
+	body     bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	if !w.exceeded {
+		if int64(w.body.Len()+len(data)) > w.limit {
+			w.exceeded = true
+			w.body.Reset()
+		} else {
+			w.body.Write(data)
+		}
+	}
+	return w.responseWriter.Write(data)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/responsecache"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_makeResponseCache(t *testing.T) {
+	faas := faasfake.NewSimpleClientset()
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	responses := responsecache.NewCache(functionsInformer, clock.RealClock{})
+	tracker := scaling.NewTracker(clock.RealClock{})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	faasInformerFactory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, functionsInformer.Informer().HasSynced)
+
+	function := newPolicyFunction("cached", map[string]string{responsecache.AnnotationCache: "1m"})
+	if _, err := faas.OpenfaasV1().Functions("openfaas-fn").Create(function); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for policy, _ := responses.Policy("cached"); policy == nil; policy, _ = responses.Policy("cached") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the cache policy")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	invoked := 0
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeResponseCache(responses, tracker, func(w http.ResponseWriter, r *http.Request) {
+		invoked++
+		w.Header().Set("X-Call-Id", "upstream")
+		switch r.URL.Query().Get("status") {
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+
+	invoke := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := invoke(http.MethodGet, "/function/cached", nil)
+	if got := w.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("expected a cache miss, got %q", got)
+	}
+
+	w = invoke(http.MethodGet, "/function/cached", http.Header{"X-Call-Id": {"second"}})
+	if got := w.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("expected a cache hit, got %q", got)
+	}
+	if w.Body.String() != "hello" {
+		t.Errorf("expected the cached body, got %q", w.Body.String())
+	}
+	if got := w.Header().Get("X-Call-Id"); got != "second" {
+		t.Errorf("expected the call id of the request, got %q", got)
+	}
+	if w.Header().Get("Age") == "" {
+		t.Errorf("expected an Age header")
+	}
+	if invoked != 1 {
+		t.Errorf("expected the function to be invoked once, got %d", invoked)
+	}
+	if _, ok := tracker.LastInvocation("cached"); !ok {
+		t.Errorf("expected the cache hit to be recorded as an invocation")
+	}
+
+	invoke(http.MethodPost, "/function/cached", nil)
+	invoke(http.MethodGet, "/function/cached", http.Header{"Cache-Control": {"no-cache"}})
+	if invoked != 3 {
+		t.Errorf("expected POST and no-cache requests to reach the function, got %d invocations", invoked)
+	}
+
+	invoke(http.MethodGet, "/function/cached?status=500", nil)
+	invoke(http.MethodGet, "/function/cached?status=500", nil)
+	if invoked != 5 {
+		t.Errorf("expected errors not to be cached, got %d invocations", invoked)
+	}
+
+	w = invoke(http.MethodGet, "/function/cached", http.Header{"Authorization": {"Bearer token"}})
+	invoke(http.MethodGet, "/function/cached", http.Header{"Cookie": {"session=1"}})
+	if invoked != 7 || len(w.Header().Get("X-Cache")) > 0 {
+		t.Errorf("expected requests with credentials to bypass the cache, got %d invocations", invoked)
+	}
+}
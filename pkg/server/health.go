@@ -2,15 +2,36 @@ package server
 
 import (
 	"net/http"
+	"sync/atomic"
 )
 
+// readiness fails the healthz endpoint once the operator is shutting down
+// so that the Services stop routing requests before the server is stopped
+type readiness struct {
+	shuttingDown int32
+}
+
+func (r *readiness) fail() {
+	atomic.StoreInt32(&r.shuttingDown, 1)
+}
+
+func (r *readiness) ready() bool {
+	return atomic.LoadInt32(&r.shuttingDown) == 0
+}
+
 // makeHealthHandler provides the healthz endpoint
-func makeHealthHandler() http.HandlerFunc {
+func makeHealthHandler(readiness *readiness) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
+		if !readiness.ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Shutting down"))
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	clientset "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	glog "k8s.io/klog"
+)
+
+const (
+	// JobPending is the status of a job which has not started
+	JobPending = "pending"
+	// JobRunning is the status of a job with an active pod
+	JobRunning = "running"
+	// JobSucceeded is the status of a job which completed successfully
+	JobSucceeded = "succeeded"
+	// JobFailed is the status of a job which failed
+	JobFailed = "failed"
+
+	jobIDLength = 8
+
+	// maxJobPayloadSize keeps the payload and the metadata of its ConfigMap within
+	// the 1MiB which Kubernetes allows for a ConfigMap
+	maxJobPayloadSize = 1000 * 1024
+)
+
+// JobStatus is the status of a run of a job function
+type JobStatus struct {
+	ID             string     `json:"id"`
+	Function       string     `json:"function"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	CompletionTime *time.Time `json:"completionTime,omitempty"`
+}
+
+// makeJobCreateHandler runs a job function with the request body as payload
+// and responds with 202 Accepted and the status of the job
+func makeJobCreateHandler(namespace string, client clientset.Interface, kube kubernetes.Interface, factory controller.FunctionFactory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		functionName := mux.Vars(r)["name"]
+
+		function, err := client.OpenfaasV1().Functions(namespace).Get(functionName, metav1.GetOptions{})
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if !controller.IsJob(function) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Function %s is not in %s mode", functionName, controller.FunctionModeJob)))
+			return
+		}
+
+		if r.ContentLength > maxJobPayloadSize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("Job payload exceeds the limit of %d bytes", maxJobPayloadSize)))
+			return
+		}
+
+		payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJobPayloadSize))
+		if err != nil {
+			// the reader stops at the limit when the body is larger
+			if len(payload) == maxJobPayloadSize {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte(fmt.Sprintf("Job payload exceeds the limit of %d bytes", maxJobPayloadSize)))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		secrets := map[string]*corev1.Secret{}
+		for _, secretName := range function.Spec.Secrets {
+			secret, err := kube.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			secrets[secretName] = secret
+		}
+
+		// the payload is created first so that the pod of the Job doesn't wait for it
+		id := utilrand.String(jobIDLength)
+		configMaps := kube.CoreV1().ConfigMaps(namespace)
+		configMap, err := configMaps.Create(controller.NewJobPayload(function, id, payload))
+		if err != nil {
+			glog.Errorf("Function %s job payload create error: %v", functionName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		job, err := kube.BatchV1().Jobs(namespace).Create(controller.NewJob(function, id, secrets, factory))
+		if err != nil {
+			glog.Errorf("Function %s job create error: %v", functionName, err)
+			configMaps.Delete(configMap.Name, &metav1.DeleteOptions{})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		controller.SetJobPayloadOwner(configMap, job)
+		if _, err := configMaps.Update(configMap); err != nil {
+			// the payload would otherwise outlive the Job until the function is deleted
+			glog.Errorf("Function %s job payload owner update error: %v", functionName, err)
+			kube.BatchV1().Jobs(namespace).Delete(job.Name, &metav1.DeleteOptions{})
+			configMaps.Delete(configMap.Name, &metav1.DeleteOptions{})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		glog.Infof("Created job %s for function %s", job.Name, functionName)
+		writeJSON(w, http.StatusAccepted, makeJobStatus(job))
+	}
+}
+
+// makeJobListHandler lists the jobs of a function, the most recent first
+func makeJobListHandler(namespace string, kube kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+
+		selector := labels.SelectorFromSet(labels.Set{"faas_function": functionName}).String()
+		jobs, err := kube.BatchV1().Jobs(namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		statuses := []JobStatus{}
+		for i := range jobs.Items {
+			if _, ok := jobs.Items[i].Labels[controller.LabelJobID]; ok {
+				statuses = append(statuses, makeJobStatus(&jobs.Items[i]))
+			}
+		}
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].CreatedAt.After(statuses[j].CreatedAt)
+		})
+
+		writeJSON(w, http.StatusOK, statuses)
+	}
+}
+
+// makeJobStatusHandler returns the status of a job
+func makeJobStatusHandler(namespace string, kube kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := getJob(w, r, namespace, kube)
+		if !ok {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, makeJobStatus(job))
+	}
+}
+
+// makeJobLogsHandler streams the logs of the pod of a job, which hold what the function
+// wrote to stdout and stderr. The logs are followed until the job completes when the follow
+// query parameter is true, the X-Job-Status header tells whether the job has completed.
+func makeJobLogsHandler(namespace string, kube kubernetes.Interface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := getJob(w, r, namespace, kube)
+		if !ok {
+			return
+		}
+
+		follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+		w.Header().Set("X-Job-Status", jobStatus(job))
+		writeJobLogs(w, r, namespace, kube, job, follow)
+	}
+}
+
+// getJob returns the job of the request or writes the error to the response
+func getJob(w http.ResponseWriter, r *http.Request, namespace string, kube kubernetes.Interface) (*batchv1.Job, bool) {
+	vars := mux.Vars(r)
+	functionName := vars["name"]
+	id := vars["id"]
+
+	selector := labels.SelectorFromSet(labels.Set{
+		"faas_function":       functionName,
+		controller.LabelJobID: id,
+	}).String()
+
+	jobs, err := kube.BatchV1().Jobs(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+
+	if len(jobs.Items) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("Job %s of function %s not found", id, functionName)))
+		return nil, false
+	}
+
+	return &jobs.Items[0], true
+}
+
+// writeJobLogs copies the logs of the latest pod of a job to the response, a followed
+// stream is bounded by the request rather than by the write timeout of the server
+func writeJobLogs(w http.ResponseWriter, r *http.Request, namespace string, kube kubernetes.Interface, job *batchv1.Job, follow bool) {
+	selector := labels.SelectorFromSet(labels.Set{"job-name": job.Name}).String()
+	pods, err := kube.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if len(pods.Items) == 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Job %s has no pods yet", job.Name)))
+		return
+	}
+
+	pod := pods.Items[0]
+	for _, p := range pods.Items[1:] {
+		if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = p
+		}
+	}
+
+	stream, err := kube.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Follow: follow}).Context(r.Context()).Stream()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.IsBadRequest(err) {
+			// the container has not started yet
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+
+	if !follow {
+		io.Copy(w, stream)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func makeJobStatus(job *batchv1.Job) JobStatus {
+	status := JobStatus{
+		ID:        job.Labels[controller.LabelJobID],
+		Function:  job.Labels["faas_function"],
+		Status:    jobStatus(job),
+		CreatedAt: job.CreationTimestamp.Time,
+	}
+	if job.Status.StartTime != nil {
+		status.StartTime = &job.Status.StartTime.Time
+	}
+	if job.Status.CompletionTime != nil {
+		status.CompletionTime = &job.Status.CompletionTime.Time
+	}
+	return status
+}
+
+// jobStatus returns the status of a job from its conditions and pod counts
+func jobStatus(job *batchv1.Job) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return JobSucceeded
+		case batchv1.JobFailed:
+			return JobFailed
+		}
+	}
+
+	if job.Status.Active > 0 {
+		return JobRunning
+	}
+	return JobPending
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	res, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/k8s"
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newJobsRouter(kube kubernetes.Interface, functions ...runtime.Object) *mux.Router {
+	client := faasfake.NewSimpleClientset(functions...)
+	factory := controller.NewFunctionFactory(kube, k8s.DeploymentConfig{})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/system/jobs/{name}", makeJobCreateHandler("openfaas-fn", client, kube, factory)).Methods(http.MethodPost)
+	r.HandleFunc("/system/jobs/{name}", makeJobListHandler("openfaas-fn", kube)).Methods(http.MethodGet)
+	r.HandleFunc("/system/jobs/{name}/{id}", makeJobStatusHandler("openfaas-fn", kube)).Methods(http.MethodGet)
+	r.HandleFunc("/system/jobs/{name}/{id}/logs", makeJobLogsHandler("openfaas-fn", kube)).Methods(http.MethodGet)
+	return r
+}
+
+func newTestJobFunction(name, mode string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:  name,
+			Image: "functions/" + name,
+			Mode:  mode,
+		},
+	}
+}
+
+func Test_makeJobCreateHandler_CreatesJobAndPayload(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	router := newJobsRouter(kube, newTestJobFunction("resize", controller.FunctionModeJob))
+
+	req := httptest.NewRequest(http.MethodPost, "/system/jobs/resize", bytes.NewBufferString("image"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	status := JobStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Function != "resize" || status.Status != JobPending || len(status.ID) == 0 {
+		t.Errorf("unexpected job status: %+v", status)
+	}
+
+	name := "resize-" + status.ID
+	job, err := kube.BatchV1().Jobs("openfaas-fn").Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected job %s to be created: %v", name, err)
+	}
+
+	configMap, err := kube.CoreV1().ConfigMaps("openfaas-fn").Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected payload ConfigMap %s to be created: %v", name, err)
+	}
+	if string(configMap.BinaryData[controller.JobPayloadKey]) != "image" {
+		t.Errorf("expected payload image, got: %s", string(configMap.BinaryData[controller.JobPayloadKey]))
+	}
+	if !metav1.IsControlledBy(configMap, job) {
+		t.Errorf("expected the payload ConfigMap to be owned by the job, got: %v", configMap.OwnerReferences)
+	}
+
+	created := []string{}
+	for _, action := range kube.Actions() {
+		if action.GetVerb() == "create" {
+			created = append(created, action.GetResource().Resource)
+		}
+	}
+	if len(created) != 2 || created[0] != "configmaps" || created[1] != "jobs" {
+		t.Errorf("expected the payload ConfigMap to be created before the job, got: %v", created)
+	}
+}
+
+func Test_makeJobCreateHandler_PayloadTooLarge(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	router := newJobsRouter(kube, newTestJobFunction("resize", controller.FunctionModeJob))
+
+	payload := bytes.Repeat([]byte("a"), maxJobPayloadSize+1)
+	for _, contentLength := range []int64{int64(len(payload)), -1} {
+		req := httptest.NewRequest(http.MethodPost, "/system/jobs/resize", bytes.NewReader(payload))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413 with content length %d, got %d", contentLength, w.Code)
+		}
+	}
+
+	if actions := kube.Actions(); len(actions) != 0 {
+		t.Errorf("expected no job to be created, got: %v", actions)
+	}
+}
+
+func Test_makeJobCreateHandler_DeletesPayloadOnError(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	kube.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("quota exceeded")
+	})
+	router := newJobsRouter(kube, newTestJobFunction("resize", controller.FunctionModeJob))
+
+	req := httptest.NewRequest(http.MethodPost, "/system/jobs/resize", bytes.NewBufferString("image"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d", w.Code)
+	}
+
+	configMaps, err := kube.CoreV1().ConfigMaps("openfaas-fn").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMaps.Items) != 0 {
+		t.Errorf("expected the payload ConfigMap to be deleted, got: %v", configMaps.Items)
+	}
+}
+
+func Test_makeJobCreateHandler_RejectsServiceFunction(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	router := newJobsRouter(kube, newTestJobFunction("nodeinfo", ""))
+
+	req := httptest.NewRequest(http.MethodPost, "/system/jobs/nodeinfo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func Test_makeJobCreateHandler_FunctionNotFound(t *testing.T) {
+	router := newJobsRouter(fake.NewSimpleClientset())
+
+	req := httptest.NewRequest(http.MethodPost, "/system/jobs/resize", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func newTestJob(id string, status batchv1.JobStatus) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "resize-" + id,
+			Namespace: "openfaas-fn",
+			Labels: map[string]string{
+				"faas_function":       "resize",
+				controller.LabelJobID: id,
+			},
+		},
+		Status: status,
+	}
+}
+
+func Test_makeJobStatusHandler(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   batchv1.JobStatus
+		expected string
+	}{
+		{
+			name:     "pending",
+			expected: JobPending,
+		},
+		{
+			name:     "running",
+			status:   batchv1.JobStatus{Active: 1},
+			expected: JobRunning,
+		},
+		{
+			name: "succeeded",
+			status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			}},
+			expected: JobSucceeded,
+		},
+		{
+			name: "failed",
+			status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+			}},
+			expected: JobFailed,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := newJobsRouter(fake.NewSimpleClientset(newTestJob("abc123", tc.status)))
+
+			req := httptest.NewRequest(http.MethodGet, "/system/jobs/resize/abc123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+
+			status := JobStatus{}
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatal(err)
+			}
+			if status.Status != tc.expected || status.ID != "abc123" {
+				t.Errorf("expected status %s, got: %+v", tc.expected, status)
+			}
+		})
+	}
+}
+
+func Test_makeJobStatusHandler_NotFound(t *testing.T) {
+	router := newJobsRouter(fake.NewSimpleClientset(newTestJob("abc123", batchv1.JobStatus{})))
+
+	req := httptest.NewRequest(http.MethodGet, "/system/jobs/resize/other", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func Test_makeJobListHandler(t *testing.T) {
+	router := newJobsRouter(fake.NewSimpleClientset(
+		newTestJob("abc123", batchv1.JobStatus{}),
+		newTestJob("def456", batchv1.JobStatus{Active: 1}),
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/system/jobs/resize", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	statuses := []JobStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("expected 2 jobs, got: %+v", statuses)
+	}
+}
+
+func Test_makeJobLogsHandler_JobStatus(t *testing.T) {
+	router := newJobsRouter(fake.NewSimpleClientset(newTestJob("abc123", batchv1.JobStatus{})))
+
+	req := httptest.NewRequest(http.MethodGet, "/system/jobs/resize/abc123/logs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", w.Code)
+	}
+	if status := w.Header().Get("X-Job-Status"); status != JobPending {
+		t.Errorf("expected X-Job-Status %s, got: %s", JobPending, status)
+	}
+}
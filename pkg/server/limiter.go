@@ -0,0 +1,261 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	glog "k8s.io/klog"
+)
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "operator_function_queue_depth",
+		Help: "Requests waiting for the concurrency limit of a function",
+	}, []string{"function_name"})
+
+	queueRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_function_queue_rejected_total",
+		Help: "Requests rejected because the queue of a function was full",
+	}, []string{"function_name"})
+
+	queueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "operator_function_queue_wait_seconds",
+		Help: "Time spent by requests waiting for the concurrency limit of a function",
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, queueRejected, queueWait)
+}
+
+// errQueueFull is returned when a request can neither be processed nor wait for the limit
+var errQueueFull = errors.New("queue is full")
+
+// replicaCounter returns the number of ready replicas of a function
+type replicaCounter interface {
+	ReadyReplicas(functionName string) int
+}
+
+// concurrencyLimiter limits the requests processed at once by each function,
+// the requests over the limit wait in a bounded first-in first-out queue
+type concurrencyLimiter struct {
+	replicas replicaCounter
+
+	lock      sync.Mutex
+	functions map[string]*functionQueue
+}
+
+type functionQueue struct {
+	inflight int
+	// limit is the limit computed for the last request, it is used to
+	// admit the waiting requests when a request completes
+	limit   int
+	waiting *list.List
+}
+
+func newConcurrencyLimiter(replicas replicaCounter) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		replicas:  replicas,
+		functions: map[string]*functionQueue{},
+	}
+}
+
+// limit returns the number of requests the function can process at once, a per replica
+// limit is multiplied by the ready replicas so that it follows the autoscaler
+func (l *concurrencyLimiter) limit(functionName string, policy proxyPolicy) int {
+	if policy.inflightScope == InflightScopeFunction {
+		return policy.maxInflight
+	}
+
+	replicas := l.replicas.ReadyReplicas(functionName)
+	if replicas < 1 {
+		replicas = 1
+	}
+	return policy.maxInflight * replicas
+}
+
+// Acquire waits until the request can be processed by the function, release must be
+// called once the request has completed. errQueueFull is returned when the queue of
+// the function is full and the error of the context when it is done before the turn
+// of the request.
+func (l *concurrencyLimiter) Acquire(ctx context.Context, functionName string, policy proxyPolicy) (func(), error) {
+	limit := l.limit(functionName, policy)
+
+	l.lock.Lock()
+	fn, ok := l.functions[functionName]
+	if !ok {
+		fn = &functionQueue{waiting: list.New()}
+		l.functions[functionName] = fn
+	}
+	// the waiting requests are admitted first when the limit was raised by new replicas
+	fn.limit = limit
+	l.admit(functionName, fn)
+
+	if fn.inflight < fn.limit && fn.waiting.Len() == 0 {
+		fn.inflight++
+		l.lock.Unlock()
+		return l.releaser(functionName), nil
+	}
+
+	if fn.waiting.Len() >= policy.queueSize {
+		l.forget(functionName, fn)
+		l.lock.Unlock()
+		queueRejected.WithLabelValues(functionName).Inc()
+		return nil, errQueueFull
+	}
+
+	ready := make(chan struct{})
+	element := fn.waiting.PushBack(ready)
+	queueDepth.WithLabelValues(functionName).Set(float64(fn.waiting.Len()))
+	l.lock.Unlock()
+
+	start := time.Now()
+	defer func() {
+		queueWait.WithLabelValues(functionName).Observe(time.Since(start).Seconds())
+	}()
+
+	select {
+	case <-ready:
+		return l.releaser(functionName), nil
+	case <-ctx.Done():
+	}
+
+	l.lock.Lock()
+	select {
+	case <-ready:
+		// the turn of the request came with the end of the context, it is given to the next request
+		l.lock.Unlock()
+		l.release(functionName)
+	default:
+		fn.waiting.Remove(element)
+		queueDepth.WithLabelValues(functionName).Set(float64(fn.waiting.Len()))
+		l.forget(functionName, fn)
+		l.lock.Unlock()
+	}
+	return nil, ctx.Err()
+}
+
+// releaser returns a func which releases the request once
+func (l *concurrencyLimiter) releaser(functionName string) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { l.release(functionName) })
+	}
+}
+
+// release records the completion of a request and admits the waiting requests
+func (l *concurrencyLimiter) release(functionName string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	fn, ok := l.functions[functionName]
+	if !ok {
+		return
+	}
+
+	if fn.inflight > 0 {
+		fn.inflight--
+	}
+
+	l.admit(functionName, fn)
+	l.forget(functionName, fn)
+}
+
+// admit lets the waiting requests in while the function is under its limit, the lock must be held
+func (l *concurrencyLimiter) admit(functionName string, fn *functionQueue) {
+	if fn.waiting.Len() == 0 {
+		return
+	}
+
+	for fn.inflight < fn.limit && fn.waiting.Len() > 0 {
+		ready := fn.waiting.Remove(fn.waiting.Front()).(chan struct{})
+		fn.inflight++
+		close(ready)
+	}
+	queueDepth.WithLabelValues(functionName).Set(float64(fn.waiting.Len()))
+}
+
+// forget removes the state and the queue depth of an idle function, the lock must be held
+func (l *concurrencyLimiter) forget(functionName string, fn *functionQueue) {
+	if fn.inflight == 0 && fn.waiting.Len() == 0 {
+		delete(l.functions, functionName)
+		queueDepth.DeleteLabelValues(functionName)
+	}
+}
+
+// Queued returns the number of requests waiting for the limit of a function
+func (l *concurrencyLimiter) Queued(functionName string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if fn, ok := l.functions[functionName]; ok {
+		return fn.waiting.Len()
+	}
+	return 0
+}
+
+// makeConcurrencyLimiter holds the requests of functions with a max-inflight annotation until
+// the function is under its limit. Requests which can't wait because the queue is full are
+// rejected with 429 and requests which wait longer than the timeout of the function with 504.
+// The admitted requests are proxied with the deadline of their timeout so that the time spent
+// in the queue counts towards it. The invocation tracker wraps the limiter so that waiting
+// requests count as inflight load for the autoscaler, which adds replicas and with them
+// raises a per replica limit.
+func makeConcurrencyLimiter(limiter *concurrencyLimiter, policies policyLookup, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 {
+			next(w, r)
+			return
+		}
+
+		policy := policies.Policy(functionName)
+		if policy.maxInflight == 0 {
+			next(w, r)
+			return
+		}
+
+		// the proxy bounds the request with the same timeout, which can't
+		// extend the deadline of the context it gets from the limiter
+		timeout := policy.timeout
+		if isStream(r) {
+			timeout = policy.streamTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		waitCtx, cancelWait := context.WithTimeout(ctx, policy.timeout)
+		release, err := limiter.Acquire(waitCtx, functionName, policy)
+		cancelWait()
+
+		if err != nil {
+			if r.Context().Err() != nil {
+				// the client has gone, there is no one to respond to
+				return
+			}
+
+			status := http.StatusGatewayTimeout
+			message := fmt.Sprintf("Timed out after %s waiting in the queue for: %s.", policy.timeout, functionName)
+			if err == errQueueFull {
+				status = http.StatusTooManyRequests
+				message = fmt.Sprintf("Queue of %d requests is full for: %s.", policy.queueSize, functionName)
+			}
+
+			glog.V(2).Infof("Function %s request rejected: %s", functionName, message)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write([]byte(message))
+			return
+		}
+		defer release()
+
+		next(w, r.WithContext(ctx))
+	}
+}
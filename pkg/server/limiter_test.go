@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testReplicas reports the same number of ready replicas for every function
+type testReplicas int
+
+func (r testReplicas) ReadyReplicas(functionName string) int {
+	return int(r)
+}
+
+func acquire(t *testing.T, limiter *concurrencyLimiter, functionName string, policy proxyPolicy) func() {
+	release, err := limiter.Acquire(context.Background(), functionName, policy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return release
+}
+
+// acquireAsync waits for the limit in the background, the release func is sent once admitted
+func acquireAsync(limiter *concurrencyLimiter, functionName string, policy proxyPolicy) <-chan func() {
+	admitted := make(chan func(), 1)
+	go func() {
+		release, err := limiter.Acquire(context.Background(), functionName, policy)
+		if err == nil {
+			admitted <- release
+		}
+	}()
+	return admitted
+}
+
+func waitQueued(t *testing.T, limiter *concurrencyLimiter, functionName string, expected int) {
+	deadline := time.Now().Add(time.Second)
+	for limiter.Queued(functionName) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued requests, got %d", expected, limiter.Queued(functionName))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_concurrencyLimiter_QueuesRequestsOverTheLimit(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{maxInflight: 2, inflightScope: InflightScopeFunction, queueSize: 10}
+
+	first := acquire(t, limiter, "nodeinfo", policy)
+	acquire(t, limiter, "nodeinfo", policy)
+
+	admitted := acquireAsync(limiter, "nodeinfo", policy)
+	waitQueued(t, limiter, "nodeinfo", 1)
+
+	select {
+	case <-admitted:
+		t.Fatalf("expected the request to wait while the function is at its limit")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	first()
+	// release is idempotent and must not admit a second request
+	first()
+
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to be admitted after a release")
+	}
+	if queued := limiter.Queued("nodeinfo"); queued != 0 {
+		t.Errorf("expected an empty queue, got %d", queued)
+	}
+}
+
+func Test_concurrencyLimiter_RejectsWhenQueueIsFull(t *testing.T) {
+	queueRejected.Reset()
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 1}
+
+	acquire(t, limiter, "nodeinfo", policy)
+	acquireAsync(limiter, "nodeinfo", policy)
+	waitQueued(t, limiter, "nodeinfo", 1)
+
+	if _, err := limiter.Acquire(context.Background(), "nodeinfo", policy); err != errQueueFull {
+		t.Fatalf("expected errQueueFull, got %v", err)
+	}
+	if got := testutil.ToFloat64(queueRejected.WithLabelValues("nodeinfo")); got != 1 {
+		t.Errorf("expected 1 rejected request, got %v", got)
+	}
+	if got := testutil.ToFloat64(queueDepth.WithLabelValues("nodeinfo")); got != 1 {
+		t.Errorf("expected a queue depth of 1, got %v", got)
+	}
+}
+
+func Test_concurrencyLimiter_LeavesQueueWhenContextIsDone(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 10}
+
+	release := acquire(t, limiter, "nodeinfo", policy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, "nodeinfo", policy); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if queued := limiter.Queued("nodeinfo"); queued != 0 {
+		t.Errorf("expected the request to leave the queue, got %d queued", queued)
+	}
+
+	release()
+	acquire(t, limiter, "nodeinfo", policy)
+}
+
+func Test_concurrencyLimiter_LimitPerReplica(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(3))
+	policy := proxyPolicy{maxInflight: 2, inflightScope: InflightScopeReplica, queueSize: 0}
+
+	for i := 0; i < 6; i++ {
+		acquire(t, limiter, "nodeinfo", policy)
+	}
+	if _, err := limiter.Acquire(context.Background(), "nodeinfo", policy); err != errQueueFull {
+		t.Errorf("expected the limit of 2 requests for each of 3 replicas, got %v", err)
+	}
+
+	// a function scaled to zero is limited as a single replica
+	limiter = newConcurrencyLimiter(testReplicas(0))
+	acquire(t, limiter, "nodeinfo", policy)
+	acquire(t, limiter, "nodeinfo", policy)
+	if _, err := limiter.Acquire(context.Background(), "nodeinfo", policy); err != errQueueFull {
+		t.Errorf("expected the limit of a single replica, got %v", err)
+	}
+}
+
+func Test_makeConcurrencyLimiter(t *testing.T) {
+	queueDepth.Reset()
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer stub.Close()
+	stubURL, _ := url.Parse(stub.URL)
+
+	policy := proxyPolicy{timeout: time.Second, retryAttempts: 1, maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 1}
+	handler := makeConcurrencyLimiter(newConcurrencyLimiter(testReplicas(1)), testPolicy(policy),
+		makeProxy(testResolver{url: stubURL}, testPolicy(policy), nil, time.Second))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", handler)
+
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	invoke := func() {
+		defer wg.Done()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/limited", nil))
+		codes <- w.Code
+	}
+
+	// the first request is processed and the second waits in the queue
+	wg.Add(2)
+	go invoke()
+	<-started
+	go invoke()
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(queueDepth.WithLabelValues("limited")) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/limited", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 when the queue is full, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "limited") {
+		t.Errorf("expected the function in the error, got %q", w.Body.String())
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected the admitted requests to succeed, got %d", code)
+		}
+	}
+}
+
+func Test_makeConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{timeout: time.Millisecond * 50, maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 1}
+	acquire(t, limiter, "timeout", policy)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeConcurrencyLimiter(limiter, testPolicy(policy), func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected the request not to be proxied")
+	}))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/timeout", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 after waiting for the timeout, got %d", w.Code)
+	}
+}
+
+func Test_concurrencyLimiter_DeletesQueueDepthOfIdleFunction(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 1}
+
+	release := acquire(t, limiter, "idle", policy)
+	admitted := acquireAsync(limiter, "idle", policy)
+	waitQueued(t, limiter, "idle", 1)
+	if depth := testutil.ToFloat64(queueDepth.WithLabelValues("idle")); depth != 1 {
+		t.Fatalf("expected a queue depth of 1, got %v", depth)
+	}
+
+	release()
+	(<-admitted)()
+
+	// the series is gone when it can't be deleted again
+	if queueDepth.DeleteLabelValues("idle") {
+		t.Errorf("expected the queue depth of the idle function to be deleted")
+	}
+}
+
+func Test_makeConcurrencyLimiter_DeductsQueueWait(t *testing.T) {
+	limiter := newConcurrencyLimiter(testReplicas(1))
+	policy := proxyPolicy{timeout: time.Second, maxInflight: 1, inflightScope: InflightScopeFunction, queueSize: 1}
+	release := acquire(t, limiter, "slow", policy)
+
+	remaining := make(chan time.Duration, 1)
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeConcurrencyLimiter(limiter, testPolicy(policy), func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		remaining <- time.Until(deadline)
+	}))
+
+	go func() {
+		for limiter.Queued("slow") != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(time.Millisecond * 300)
+		release()
+	}()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/slow", nil))
+
+	if got := <-remaining; got <= 0 || got > time.Millisecond*700 {
+		t.Errorf("expected the time in the queue to be deducted from the timeout of 1s, got %s left", got)
+	}
+}
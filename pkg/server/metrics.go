@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The invocation metrics use the names and labels of the OpenFaaS gateway so that
+// the same dashboards and alert rules work when functions are invoked through the operator
+var (
+	invocationTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_function_invocation_total",
+		Help: "Function metrics",
+	}, []string{"function_name", "code"})
+
+	invocationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gateway_functions_seconds",
+		Help: "Function time taken",
+	}, []string{"function_name", "code"})
+
+	invocationStarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_function_invocation_started",
+		Help: "The total number of function HTTP requests started.",
+	}, []string{"function_name"})
+
+	invocationInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_function_invocation_inflight",
+		Help: "The number of function HTTP requests in progress.",
+	}, []string{"function_name"})
+)
+
+func init() {
+	prometheus.MustRegister(invocationTotal, invocationDuration, invocationStarted, invocationInflight)
+}
+
+// makeInvocationMetrics records the count, duration, status code and inflight
+// requests of the invocations made through the function proxy
+func makeInvocationMetrics(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 {
+			next(w, r)
+			return
+		}
+
+		invocationStarted.WithLabelValues(functionName).Inc()
+		inflight := invocationInflight.WithLabelValues(functionName)
+		inflight.Inc()
+		defer inflight.Dec()
+
+		start := time.Now()
+		writer := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next(writer, r)
+
+		code := strconv.Itoa(writer.statusCode)
+		invocationTotal.WithLabelValues(functionName, code).Inc()
+		invocationDuration.WithLabelValues(functionName, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// responseWriter records the status code written by the next handler, it keeps
+// the http.Flusher and http.Hijacker of the underlying writer for streaming responses
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && !w.wroteHeader {
+		// the response of a protocol upgrade is written to the connection directly
+		w.statusCode = http.StatusSwitchingProtocols
+		w.wroteHeader = true
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newMetricsRouter(t *testing.T, function http.HandlerFunc) (*mux.Router, func()) {
+	stub := httptest.NewServer(function)
+	stubURL, err := url.Parse(stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", makeInvocationMetrics(makeProxy(testResolver{url: stubURL}, testPolicy{timeout: time.Second * 5, retryAttempts: 1}, nil, time.Second)))
+	return r, stub.Close
+}
+
+func invoke(router http.Handler, functionName string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/function/"+functionName, strings.NewReader("payload"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func histogramCount(t *testing.T, observer prometheus.Observer) uint64 {
+	metric := &dto.Metric{}
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func Test_makeInvocationMetrics_CountsByStatusCode(t *testing.T) {
+	router, stop := newMetricsRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") == "true" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	defer stop()
+
+	// the metrics are global, previous runs of the test are cleared
+	invocationTotal.Reset()
+	invocationStarted.Reset()
+	invocationDuration.Reset()
+
+	invoke(router, "metrics-counts")
+	invoke(router, "metrics-counts")
+
+	req := httptest.NewRequest(http.MethodPost, "/function/metrics-counts?fail=true", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(invocationTotal.WithLabelValues("metrics-counts", "200")); got != 2 {
+		t.Errorf("expected 2 invocations with code 200, got %v", got)
+	}
+	if got := testutil.ToFloat64(invocationTotal.WithLabelValues("metrics-counts", "500")); got != 1 {
+		t.Errorf("expected 1 invocation with code 500, got %v", got)
+	}
+	if got := testutil.ToFloat64(invocationStarted.WithLabelValues("metrics-counts")); got != 3 {
+		t.Errorf("expected 3 invocations started, got %v", got)
+	}
+	if got := histogramCount(t, invocationDuration.WithLabelValues("metrics-counts", "200")); got != 2 {
+		t.Errorf("expected 2 durations observed for code 200, got %d", got)
+	}
+}
+
+func Test_makeInvocationMetrics_TracksInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router, stop := newMetricsRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		invoke(router, "metrics-inflight")
+		close(done)
+	}()
+
+	<-started
+	if got := testutil.ToFloat64(invocationInflight.WithLabelValues("metrics-inflight")); got != 1 {
+		t.Errorf("expected 1 inflight invocation, got %v", got)
+	}
+
+	close(release)
+	<-done
+	if got := testutil.ToFloat64(invocationInflight.WithLabelValues("metrics-inflight")); got != 0 {
+		t.Errorf("expected no inflight invocations, got %v", got)
+	}
+}
+
+func Test_makeInvocationMetrics_ExposedWithGatewayNames(t *testing.T) {
+	router, stop := newMetricsRouter(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer stop()
+
+	invoke(router, "metrics-names")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"gateway_function_invocation_total",
+		"gateway_functions_seconds",
+		"gateway_function_invocation_started",
+		"gateway_function_invocation_inflight",
+	} {
+		if !names[name] {
+			t.Errorf("expected metric %s to be registered", name)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+	"github.com/prometheus/client_golang/prometheus"
+	glog "k8s.io/klog"
+)
+
+const (
+	// maxMirrorBodySize is the size of the request bodies kept to be sent to the mirror,
+	// the requests with a larger body are not mirrored
+	maxMirrorBodySize = 1024 * 1024
+	// maxMirrorInflight is the number of requests sent to mirrors at once by the proxy,
+	// the requests over the limit are not mirrored
+	maxMirrorInflight = 100
+
+	mirrorDroppedBusy        = "busy"
+	mirrorDroppedBody        = "body"
+	mirrorDroppedUnavailable = "unavailable"
+)
+
+var (
+	mirrorRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_mirror_requests_total",
+		Help: "The requests copied to the mirror of a function by status code of the mirror and of the function.",
+	}, []string{"function_name", "mirror_name", "code", "function_code"})
+
+	mirrorDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "operator_mirror_duration_seconds",
+		Help: "The duration of the mirrored requests for the function and for its mirror.",
+	}, []string{"function_name", "mirror_name", "target"})
+
+	mirrorDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "operator_mirror_dropped_total",
+		Help: "The sampled requests which were not copied to the mirror of a function.",
+	}, []string{"function_name", "mirror_name", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(mirrorRequests, mirrorDuration, mirrorDropped)
+}
+
+// mirror sends copies of the requests of functions to their mirror function, straight
+// to the endpoints of the mirror, and discards the responses
+type mirror struct {
+	namespace string
+	resolver  endpointResolver
+	policies  policyLookup
+	tracker   *scaling.Tracker
+	transport http.RoundTripper
+	inflight  chan struct{}
+}
+
+func newMirror(namespace string, resolver endpointResolver, policies policyLookup, tracker *scaling.Tracker, dialTimeout time.Duration) *mirror {
+	return &mirror{
+		namespace: namespace,
+		resolver:  resolver,
+		policies:  policies,
+		tracker:   tracker,
+		transport: newProxyTransport(dialTimeout),
+		inflight:  make(chan struct{}, maxMirrorInflight),
+	}
+}
+
+// makeMirror copies a sample of the requests of functions with a mirror annotation to
+// the mirror once the function has responded. The request body is kept while the proxy
+// reads it so the mirror doesn't add latency to the requests of the function.
+func makeMirror(m *mirror, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+		if len(functionName) == 0 || isStream(r) {
+			next(w, r)
+			return
+		}
+
+		policy := m.policies.Policy(functionName)
+		if len(policy.mirror) == 0 || rand.Float64() >= policy.mirrorSampleRate {
+			next(w, r)
+			return
+		}
+		mirrorName := strings.TrimSuffix(policy.mirror, "."+m.namespace)
+
+		var body *mirrorBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &mirrorBody{ReadCloser: r.Body, limit: maxMirrorBodySize}
+			r.Body = body
+		}
+
+		start := time.Now()
+		writer := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(writer, r)
+		duration := time.Since(start)
+
+		if r.Context().Err() != nil {
+			return
+		}
+
+		var data []byte
+		if body != nil {
+			var complete bool
+			data, complete = body.Bytes()
+			if !complete {
+				mirrorDropped.WithLabelValues(functionName, mirrorName, mirrorDroppedBody).Inc()
+				return
+			}
+		}
+
+		m.Send(functionName, mirrorName, r, vars["params"], data, writer.statusCode, duration)
+	}
+}
+
+// Send copies the request to the mirror in the background, the request is dropped
+// when the mirror has no endpoints or too many requests are being mirrored
+func (m *mirror) Send(functionName, mirrorName string, r *http.Request, path string, body []byte, functionCode int, functionDuration time.Duration) {
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		mirrorDropped.WithLabelValues(functionName, mirrorName, mirrorDroppedBusy).Inc()
+		return
+	}
+
+	functionURL, done, err := m.resolver.Resolve(mirrorName)
+	if err != nil {
+		<-m.inflight
+		glog.V(2).Infof("Function %s mirror %s resolve error: %v", functionName, mirrorName, err)
+		mirrorDropped.WithLabelValues(functionName, mirrorName, mirrorDroppedUnavailable).Inc()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.policies.Policy(mirrorName).timeout)
+	req, err := http.NewRequestWithContext(ctx, r.Method, "http://"+functionHost(functionURL)+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		done()
+		<-m.inflight
+		glog.Errorf("Function %s mirror %s request error: %v", functionName, mirrorName, err)
+		return
+	}
+	req.URL.RawQuery = r.URL.RawQuery
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Mirror-Of", functionName)
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+
+	m.tracker.Begin(mirrorName)
+	go func() {
+		defer func() {
+			m.tracker.End(mirrorName)
+			cancel()
+			done()
+			<-m.inflight
+		}()
+
+		start := time.Now()
+		code := "error"
+		res, err := m.transport.RoundTrip(req)
+		if err != nil {
+			glog.V(2).Infof("Function %s mirror %s error: %v", functionName, mirrorName, err)
+		} else {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			code = strconv.Itoa(res.StatusCode)
+		}
+
+		mirrorRequests.WithLabelValues(functionName, mirrorName, code, strconv.Itoa(functionCode)).Inc()
+		mirrorDuration.WithLabelValues(functionName, mirrorName, "function").Observe(functionDuration.Seconds())
+		mirrorDuration.WithLabelValues(functionName, mirrorName, "mirror").Observe(time.Since(start).Seconds())
+	}()
+}
+
+// mirrorBody keeps a copy of the request body read by the proxy, up to the limit.
+// The transport can read the body after the response so the copy is locked.
+type mirrorBody struct {
+	io.ReadCloser
+	lock     sync.Mutex
+	data     bytes.Buffer
+	limit    int
+	exceeded bool
+	eof      bool
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.exceeded {
+		if b.data.Len()+n > b.limit {
+			b.exceeded = true
+			b.data = bytes.Buffer{}
+		} else {
+			b.data.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Bytes returns the copy of the body, complete is false when the body was larger
+// than the limit or was not read to the end
+func (b *mirrorBody) Bytes() (data []byte, complete bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.exceeded || !b.eof {
+		return nil, false
+	}
+	return append([]byte{}, b.data.Bytes()...), true
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// functionsResolver resolves each function to the URL of its own stub
+type functionsResolver map[string]*url.URL
+
+func (r functionsResolver) Resolve(functionName string) (url.URL, func(), error) {
+	functionURL, ok := r[functionName]
+	if !ok {
+		return url.URL{}, nil, fmt.Errorf("no endpoints for %s", functionName)
+	}
+	return *functionURL, func() {}, nil
+}
+
+// functionsPolicy applies a proxy policy to each function
+type functionsPolicy map[string]proxyPolicy
+
+func (p functionsPolicy) Policy(functionName string) proxyPolicy {
+	return p[functionName]
+}
+
+type mirroredRequest struct {
+	method string
+	uri    string
+	body   string
+	header http.Header
+}
+
+// newMirrorRouter returns a router which mirrors the requests of nodeinfo to nodeinfo-v2, the
+// servers of the functions are closed by the returned func
+func newMirrorRouter(policies functionsPolicy) (*mux.Router, chan mirroredRequest, func()) {
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	mirrored := make(chan mirroredRequest, 10)
+	mirrorFunction := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mirrored <- mirroredRequest{method: r.Method, uri: r.RequestURI, body: string(body), header: r.Header}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	functionURL, _ := url.Parse(function.URL)
+	mirrorURL, _ := url.Parse(mirrorFunction.URL)
+	resolver := functionsResolver{"nodeinfo": functionURL, "nodeinfo-v2": mirrorURL}
+
+	m := newMirror("openfaas-fn", resolver, policies, scaling.NewTracker(clock.RealClock{}), time.Second)
+	handler := makeMirror(m, makeProxy(resolver, policies, nil, time.Second))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", handler)
+	router.HandleFunc("/function/{name}/{params:.*}", handler)
+	return router, mirrored, func() {
+		function.Close()
+		mirrorFunction.Close()
+	}
+}
+
+func Test_makeMirror_CopiesRequestsToMirror(t *testing.T) {
+	mirrorRequests.Reset()
+	policy := proxyPolicy{timeout: time.Second * 5, retryAttempts: 1, mirror: "nodeinfo-v2.openfaas-fn", mirrorSampleRate: 1}
+	router, mirrored, closeFunctions := newMirrorRouter(functionsPolicy{"nodeinfo": policy, "nodeinfo-v2": {timeout: time.Second * 5}})
+	defer closeFunctions()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/function/nodeinfo/api/items?page=2", strings.NewReader("payload"))
+	r.Header.Set("X-Api-Key", "secret")
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected the status of the function, got %d", w.Code)
+	}
+
+	var got mirroredRequest
+	select {
+	case got = <-mirrored:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("timed out waiting for the mirrored request")
+	}
+
+	if got.method != http.MethodPost || got.uri != "/api/items?page=2" || got.body != "payload" {
+		t.Errorf("expected a copy of the request, got %s %s %q", got.method, got.uri, got.body)
+	}
+	if got.header.Get("X-Api-Key") != "secret" || got.header.Get("X-Mirror-Of") != "nodeinfo" {
+		t.Errorf("expected the headers of the request and X-Mirror-Of, got %v", got.header)
+	}
+
+	counter := mirrorRequests.WithLabelValues("nodeinfo", "nodeinfo-v2", "500", "201")
+	deadline := time.Now().Add(time.Second * 5)
+	for testutil.ToFloat64(counter) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if got := testutil.ToFloat64(counter); got != 1 {
+		t.Errorf("expected the status codes of the mirror and the function in the metrics, got %v", got)
+	}
+}
+
+func Test_makeMirror_DropsRequestsWhichCantBeCopied(t *testing.T) {
+	mirrorDropped.Reset()
+	policies := functionsPolicy{
+		"nodeinfo": {timeout: time.Second * 5, retryAttempts: 1, mirror: "nodeinfo-v2", mirrorSampleRate: 1},
+		"certinfo": {timeout: time.Second * 5, retryAttempts: 1, mirror: "unknown", mirrorSampleRate: 1},
+	}
+	router, mirrored, closeFunctions := newMirrorRouter(policies)
+	defer closeFunctions()
+
+	body := strings.Repeat("a", maxMirrorBodySize+1)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/function/nodeinfo", strings.NewReader(body)))
+	if got := testutil.ToFloat64(mirrorDropped.WithLabelValues("nodeinfo", "nodeinfo-v2", mirrorDroppedBody)); got != 1 {
+		t.Errorf("expected the request with a large body to be dropped, got %v", got)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/certinfo", nil))
+	if got := testutil.ToFloat64(mirrorDropped.WithLabelValues("certinfo", "unknown", mirrorDroppedUnavailable)); got != 1 {
+		t.Errorf("expected the request to be dropped for a mirror without endpoints, got %v", got)
+	}
+
+	select {
+	case got := <-mirrored:
+		t.Errorf("expected no mirrored requests, got %s %s", got.method, got.uri)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	listers "github.com/openfaas/openfaas-operator/pkg/client/listers/openfaas/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	glog "k8s.io/klog"
+)
+
+const (
+	// AnnotationTimeout is the function annotation that sets the upstream timeout of the proxy
+	// as a duration, for example 2m, it replaces the write_timeout of the operator
+	AnnotationTimeout = "com.openfaas.timeout"
+	// AnnotationRetryAttempts is the function annotation that sets the number of attempts made
+	// by the proxy for idempotent requests which fail to connect or respond with 502, 503 or 504
+	AnnotationRetryAttempts = "com.openfaas.retry.attempts"
+	// AnnotationRetryBackoff is the function annotation that sets the delay before the first
+	// retry as a duration, the delay is doubled after each attempt
+	AnnotationRetryBackoff = "com.openfaas.retry.backoff"
+	// AnnotationMaxBodySize is the function annotation that limits the size of request
+	// bodies as a quantity, for example 10Mi
+	AnnotationMaxBodySize = "com.openfaas.max-body-size"
+	// AnnotationStreamTimeout is the function annotation that sets the maximum duration
+	// of Server-Sent Events streams and WebSocket connections, for example 30m
+	AnnotationStreamTimeout = "com.openfaas.stream-timeout"
+
+	// AnnotationMaxInflight is the function annotation that limits the number of requests
+	// processed at once, the requests over the limit wait in a queue
+	AnnotationMaxInflight = "com.openfaas.max-inflight"
+	// AnnotationMaxInflightScope is the function annotation that sets whether the limit
+	// applies to each replica (default) or to the whole function
+	AnnotationMaxInflightScope = "com.openfaas.max-inflight.scope"
+	// AnnotationQueueSize is the function annotation that sets the number of requests
+	// which can wait for the limit, the requests over the queue size are rejected with 429
+	AnnotationQueueSize = "com.openfaas.max-inflight.queue"
+
+	// AnnotationMirror is the function annotation that names a function which gets a copy
+	// of the requests, the responses of the mirror are discarded
+	AnnotationMirror = "com.openfaas.mirror"
+	// AnnotationMirrorSampleRate is the function annotation that sets the ratio of the
+	// requests copied to the mirror, between 0 and 1, all the requests are copied by default
+	AnnotationMirrorSampleRate = "com.openfaas.mirror.sample-rate"
+
+	// InflightScopeReplica multiplies the limit by the ready replicas of the function
+	InflightScopeReplica = "replica"
+	// InflightScopeFunction applies the limit to the function regardless of its replicas
+	InflightScopeFunction = "function"
+
+	defaultRetryBackoff  = 100 * time.Millisecond
+	defaultStreamTimeout = time.Hour
+	defaultQueueSize     = 100
+)
+
+// proxyPolicy is the timeout, retries and request size limit applied by the proxy
+type proxyPolicy struct {
+	timeout       time.Duration
+	retryAttempts int
+	retryBackoff  time.Duration
+	// maxBodySize is the maximum size of request bodies in bytes, zero means no limit
+	maxBodySize int64
+	// streamTimeout replaces the timeout for event streams and protocol upgrades
+	streamTimeout time.Duration
+	// maxInflight is the maximum number of requests processed at once, zero means no limit
+	maxInflight   int
+	inflightScope string
+	queueSize     int
+	// mirror is the name of the function which gets a copy of the requests, if any
+	mirror           string
+	mirrorSampleRate float64
+}
+
+// policyLookup returns the proxy policy of a function
+type policyLookup interface {
+	Policy(functionName string) proxyPolicy
+}
+
+// functionPolicies reads the proxy policies from the annotations of the functions
+type functionPolicies struct {
+	lister   listers.FunctionNamespaceLister
+	defaults proxyPolicy
+}
+
+func newFunctionPolicies(lister listers.FunctionNamespaceLister, timeout time.Duration) *functionPolicies {
+	return &functionPolicies{
+		lister: lister,
+		defaults: proxyPolicy{
+			timeout:       timeout,
+			retryAttempts: 1,
+			retryBackoff:  defaultRetryBackoff,
+			streamTimeout: defaultStreamTimeout,
+			inflightScope: InflightScopeReplica,
+			queueSize:     defaultQueueSize,
+			// the sample rate only applies to functions with a mirror
+			mirrorSampleRate: 1,
+		},
+	}
+}
+
+// Policy returns the policy of the function, the defaults are used for functions
+// which are not in the cache or have invalid annotations
+func (p *functionPolicies) Policy(functionName string) proxyPolicy {
+	function, err := p.lister.Get(functionName)
+	if err != nil {
+		return p.defaults
+	}
+
+	policy, err := getProxyPolicy(function, p.defaults)
+	if err != nil {
+		glog.Warningf("Function %s proxy policy error, using the defaults: %v", functionName, err)
+		return p.defaults
+	}
+	return policy
+}
+
+// getProxyPolicy reads the proxy policy from the function annotations
+func getProxyPolicy(function *faasv1.Function, defaults proxyPolicy) (proxyPolicy, error) {
+	policy := defaults
+	if function.Spec.Annotations == nil {
+		return policy, nil
+	}
+	annotations := *function.Spec.Annotations
+
+	if value, ok := annotations[AnnotationTimeout]; ok && len(value) > 0 {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return defaults, fmt.Errorf("%s must be a duration greater than zero", AnnotationTimeout)
+		}
+		policy.timeout = timeout
+	}
+
+	if value, ok := annotations[AnnotationRetryAttempts]; ok && len(value) > 0 {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			return defaults, fmt.Errorf("%s must be a number greater than zero", AnnotationRetryAttempts)
+		}
+		policy.retryAttempts = attempts
+	}
+
+	if value, ok := annotations[AnnotationRetryBackoff]; ok && len(value) > 0 {
+		backoff, err := time.ParseDuration(value)
+		if err != nil || backoff < 0 {
+			return defaults, fmt.Errorf("%s must be a positive duration", AnnotationRetryBackoff)
+		}
+		policy.retryBackoff = backoff
+	}
+
+	if value, ok := annotations[AnnotationMaxBodySize]; ok && len(value) > 0 {
+		size, err := resource.ParseQuantity(value)
+		if err != nil || size.Value() <= 0 {
+			return defaults, fmt.Errorf("%s must be a quantity greater than zero", AnnotationMaxBodySize)
+		}
+		policy.maxBodySize = size.Value()
+	}
+
+	if value, ok := annotations[AnnotationStreamTimeout]; ok && len(value) > 0 {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return defaults, fmt.Errorf("%s must be a duration greater than zero", AnnotationStreamTimeout)
+		}
+		policy.streamTimeout = timeout
+	}
+
+	if value, ok := annotations[AnnotationMaxInflight]; ok && len(value) > 0 {
+		maxInflight, err := strconv.Atoi(value)
+		if err != nil || maxInflight < 1 {
+			return defaults, fmt.Errorf("%s must be a number greater than zero", AnnotationMaxInflight)
+		}
+		policy.maxInflight = maxInflight
+	}
+
+	if value, ok := annotations[AnnotationMaxInflightScope]; ok && len(value) > 0 {
+		if value != InflightScopeReplica && value != InflightScopeFunction {
+			return defaults, fmt.Errorf("%s must be %s or %s", AnnotationMaxInflightScope, InflightScopeReplica, InflightScopeFunction)
+		}
+		policy.inflightScope = value
+	}
+
+	if value, ok := annotations[AnnotationQueueSize]; ok && len(value) > 0 {
+		queueSize, err := strconv.Atoi(value)
+		if err != nil || queueSize < 0 {
+			return defaults, fmt.Errorf("%s must be a positive number", AnnotationQueueSize)
+		}
+		policy.queueSize = queueSize
+	}
+
+	if value, ok := annotations[AnnotationMirror]; ok && len(value) > 0 {
+		if value == function.Spec.Name {
+			return defaults, fmt.Errorf("%s must be another function", AnnotationMirror)
+		}
+		policy.mirror = value
+	}
+
+	if value, ok := annotations[AnnotationMirrorSampleRate]; ok && len(value) > 0 {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return defaults, fmt.Errorf("%s must be a number greater than 0 and up to 1", AnnotationMirrorSampleRate)
+		}
+		policy.mirrorSampleRate = rate
+	}
+
+	return policy, nil
+}
+
+// retryTransport retries idempotent requests which fail to connect or respond
+// with 502, 503 or 504, the body of the request is buffered to be sent again
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	backoff  time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.attempts <= 1 || !isIdempotent(req.Method) {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backoff := t.backoff
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		res, err := t.next.RoundTrip(attemptReq)
+		if attempt >= t.attempts || !isRetryable(res, err) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			glog.V(2).Infof("Retrying %s %s after status %d, attempt %d", req.Method, req.URL.Path, res.StatusCode, attempt)
+		} else {
+			glog.V(2).Infof("Retrying %s %s after error: %v, attempt %d", req.Method, req.URL.Path, err, attempt)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodPut,
+		http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// limitedBody fails the reads of a request body after the limit, the reads are
+// made by the transport so exceeded is read atomically by the proxy
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  int32
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	atomic.StoreInt32(&b.exceeded, 1)
+	return n, fmt.Errorf("request body too large")
+}
+
+func (b *limitedBody) Exceeded() bool {
+	return atomic.LoadInt32(&b.exceeded) == 1
+}
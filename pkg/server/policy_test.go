@@ -0,0 +1,285 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/openfaas-operator/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPolicyFunction(name string, annotations map[string]string) *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        name,
+			Image:       "functions/" + name,
+			Annotations: &annotations,
+		},
+	}
+}
+
+func Test_getProxyPolicy(t *testing.T) {
+	defaults := proxyPolicy{timeout: time.Second * 8, retryAttempts: 1, retryBackoff: defaultRetryBackoff}
+
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    proxyPolicy
+		wantErr     bool
+	}{
+		{
+			name:     "defaults without annotations",
+			expected: defaults,
+		},
+		{
+			name: "all annotations",
+			annotations: map[string]string{
+				AnnotationTimeout:       "2m",
+				AnnotationRetryAttempts: "3",
+				AnnotationRetryBackoff:  "500ms",
+				AnnotationMaxBodySize:   "1Mi",
+			},
+			expected: proxyPolicy{timeout: time.Minute * 2, retryAttempts: 3, retryBackoff: time.Millisecond * 500, maxBodySize: 1024 * 1024},
+		},
+		{
+			name:        "max body size in bytes",
+			annotations: map[string]string{AnnotationMaxBodySize: "1024"},
+			expected:    proxyPolicy{timeout: time.Second * 8, retryAttempts: 1, retryBackoff: defaultRetryBackoff, maxBodySize: 1024},
+		},
+		{
+			name: "max inflight for the function without a queue",
+			annotations: map[string]string{
+				AnnotationMaxInflight:      "4",
+				AnnotationMaxInflightScope: InflightScopeFunction,
+				AnnotationQueueSize:        "0",
+			},
+			expected: proxyPolicy{timeout: time.Second * 8, retryAttempts: 1, retryBackoff: defaultRetryBackoff, maxInflight: 4, inflightScope: InflightScopeFunction},
+		},
+		{
+			name: "mirror with a sample rate",
+			annotations: map[string]string{
+				AnnotationMirror:           "nodeinfo-v2",
+				AnnotationMirrorSampleRate: "0.25",
+			},
+			expected: proxyPolicy{timeout: time.Second * 8, retryAttempts: 1, retryBackoff: defaultRetryBackoff, mirror: "nodeinfo-v2", mirrorSampleRate: 0.25},
+		},
+		{
+			name:        "mirror to the function itself",
+			annotations: map[string]string{AnnotationMirror: "nodeinfo"},
+			wantErr:     true,
+		},
+		{
+			name:        "sample rate over 1",
+			annotations: map[string]string{AnnotationMirror: "nodeinfo-v2", AnnotationMirrorSampleRate: "10"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid max inflight scope",
+			annotations: map[string]string{AnnotationMaxInflightScope: "namespace"},
+			wantErr:     true,
+		},
+		{
+			name:        "zero max inflight",
+			annotations: map[string]string{AnnotationMaxInflight: "0"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid timeout",
+			annotations: map[string]string{AnnotationTimeout: "forever"},
+			wantErr:     true,
+		},
+		{
+			name:        "zero attempts",
+			annotations: map[string]string{AnnotationRetryAttempts: "0"},
+			wantErr:     true,
+		},
+		{
+			name:        "negative max body size",
+			annotations: map[string]string{AnnotationMaxBodySize: "-1Mi"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := getProxyPolicy(newPolicyFunction("nodeinfo", tc.annotations), defaults)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if policy != tc.expected {
+				t.Errorf("expected policy %+v, got %+v", tc.expected, policy)
+			}
+		})
+	}
+}
+
+func Test_functionPolicies_DefaultsForUnknownAndInvalidFunctions(t *testing.T) {
+	faasInformerFactory := informers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	functionsInformer.Informer().GetIndexer().Add(newPolicyFunction("slow", map[string]string{AnnotationTimeout: "5m"}))
+	functionsInformer.Informer().GetIndexer().Add(newPolicyFunction("invalid", map[string]string{AnnotationTimeout: "forever"}))
+
+	policies := newFunctionPolicies(functionsInformer.Lister().Functions("openfaas-fn"), time.Second*8)
+
+	if got := policies.Policy("slow").timeout; got != time.Minute*5 {
+		t.Errorf("expected timeout of 5m, got %s", got)
+	}
+	if got := policies.Policy("invalid").timeout; got != time.Second*8 {
+		t.Errorf("expected the default timeout for invalid annotations, got %s", got)
+	}
+	if got := policies.Policy("unknown"); got != policies.defaults {
+		t.Errorf("expected the default policy for unknown functions, got %+v", got)
+	}
+}
+
+func Test_makeProxy_FunctionTimeout(t *testing.T) {
+	release := make(chan struct{})
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Millisecond * 50, retryAttempts: 3, retryBackoff: time.Millisecond},
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+	defer stop()
+	defer close(release)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "50ms") {
+		t.Errorf("expected the timeout in the error, got %q", w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the retries to stop at the timeout, took %s", elapsed)
+	}
+}
+
+func Test_makeProxy_RetriesIdempotentRequests(t *testing.T) {
+	var attempts int32
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Second, retryAttempts: 3, retryBackoff: time.Millisecond},
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(body)
+		})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/function/nodeinfo", strings.NewReader("payload")))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 after retries, got %d", w.Code)
+	}
+	if w.Body.String() != "payload" {
+		t.Errorf("expected the body to be sent on each attempt, got %q", w.Body.String())
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("expected 3 attempts, got %d", got)
+	}
+}
+
+func Test_makeProxy_ReturnsLastResponseWhenRetriesExhausted(t *testing.T) {
+	var attempts int32
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Second, retryAttempts: 2, retryBackoff: time.Millisecond},
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", w.Code)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+}
+
+func Test_makeProxy_DoesNotRetryPost(t *testing.T) {
+	var attempts int32
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Second, retryAttempts: 3, retryBackoff: time.Millisecond},
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/function/nodeinfo", strings.NewReader("payload")))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected a single attempt for POST, got %d", got)
+	}
+}
+
+func Test_makeProxy_MaxBodySize(t *testing.T) {
+	policy := proxyPolicy{timeout: time.Second, retryAttempts: 1, maxBodySize: 4}
+	router, stop := newStubFunctionWithPolicy(t, policy, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	defer stop()
+
+	t.Run("within the limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/function/nodeinfo", strings.NewReader("four")))
+
+		if w.Code != http.StatusOK || w.Body.String() != "four" {
+			t.Errorf("expected status 200 with the body, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("content length over the limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/function/nodeinfo", strings.NewReader("too large")))
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "limit of 4 bytes") {
+			t.Errorf("expected the limit in the error, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("chunked body over the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/function/nodeinfo", ioutil.NopCloser(strings.NewReader("too large")))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status 413, got %d", w.Code)
+		}
+	})
+}
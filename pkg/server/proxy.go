@@ -1,89 +1,294 @@
 package server
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/openfaas/faas/gateway/requests"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	glog "k8s.io/klog"
 )
 
+const (
+	watchdogPort = "8080"
+
+	maxIdleConns        = 1024
+	maxIdleConnsPerHost = 1024
+
+	flushInterval = 100 * time.Millisecond
+)
+
+// endpointResolver returns the URL of an endpoint of a function for a request,
+// done is called once the request to the endpoint has completed
+type endpointResolver interface {
+	Resolve(functionName string) (functionURL url.URL, done func(), err error)
+}
+
 // makeProxy creates a proxy for HTTP web requests which can be routed to a function.
-func makeProxy(functionNamespace string, timeout time.Duration) http.HandlerFunc {
-	proxyClient := http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   timeout,
-				KeepAlive: 1 * time.Second,
-			}).DialContext,
-			IdleConnTimeout:       120 * time.Millisecond,
-			ExpectContinueTimeout: 1500 * time.Millisecond,
-		},
-	}
+// The status code, headers, body and trailers of the function are written back as they are,
+// functions which can't be resolved respond with 503, unreachable functions with 502,
+// requests which take longer than the timeout of the function with 504 and requests
+// with a body larger than the limit of the function with 413. Responses are flushed
+// while they are streamed and WebSocket connections are upgraded through the proxy.
+// The result of each attempt is given to the outlier recorder, when set, so that
+// failing endpoints can be ejected.
+func makeProxy(resolver endpointResolver, policies policyLookup, outliers outlierRecorder, dialTimeout time.Duration) http.HandlerFunc {
+	transport := newProxyTransport(dialTimeout)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
 		}
 
-		switch r.Method {
-		case http.MethodPost,
-			http.MethodPut,
-			http.MethodPatch,
-			http.MethodDelete,
-			http.MethodGet:
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+		if len(functionName) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a function name in the request path"))
+			return
+		}
 
-			vars := mux.Vars(r)
-			service := vars["name"]
+		start := time.Now()
+		callID := r.Header.Get("X-Call-Id")
+		if len(callID) == 0 {
+			callID = string(uuid.NewUUID())
+		}
+		startTime := strconv.FormatInt(start.UTC().UnixNano(), 10)
 
-			defer func(when time.Time) {
-				seconds := time.Since(when).Seconds()
-				glog.V(2).Infof("%s took %f seconds", service, seconds)
-			}(time.Now())
+		// the headers are set on the response of the function or of the error, setting
+		// them on the writer upfront would repeat them when the response is copied
+		writeError := func(w http.ResponseWriter, status int, message string) {
+			w.Header().Set("X-Call-Id", callID)
+			w.Header().Set("X-Start-Time", startTime)
+			w.Header().Set("X-Duration-Seconds", durationSeconds(start))
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write([]byte(message))
+		}
 
-			forwardReq := requests.NewForwardRequest(r.Method, *r.URL)
+		policy := policies.Policy(functionName)
 
-			url := forwardReq.ToURL(fmt.Sprintf("%s.%s", service, functionNamespace), 8080)
+		var body *limitedBody
+		if policy.maxBodySize > 0 {
+			if r.ContentLength > policy.maxBodySize {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body of %d bytes exceeds the limit of %d bytes for: %s.",
+					r.ContentLength, policy.maxBodySize, functionName))
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &limitedBody{ReadCloser: r.Body, remaining: policy.maxBodySize}
+			}
+		}
 
-			request, _ := http.NewRequest(r.Method, url, r.Body)
+		functionURL, done, err := resolver.Resolve(functionName)
+		if err != nil {
+			glog.Errorf("Function %s resolve error: %v", functionName, err)
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("No endpoints available for: %s.", functionName))
+			return
+		}
+		defer done()
 
-			copyHeaders(&request.Header, &r.Header)
+		timeout := policy.timeout
+		if isStream(r) {
+			timeout = policy.streamTimeout
+		}
 
-			defer request.Body.Close()
+		// the context bounds the request and its response, upgraded connections get
+		// the stream timeout of the function once they are hijacked from the server
+		if isUpgrade(r) {
+			w = &upgradeWriter{ResponseWriter: w, timeout: timeout}
+		}
 
-			response, err := proxyClient.Do(request)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
 
-			if err != nil {
-				glog.Errorf("%s error: %s", service, err.Error())
-				writeHead(service, http.StatusInternalServerError, w)
-				buf := bytes.NewBufferString("Can't reach service: " + service)
-				w.Write(buf.Bytes())
-				return
-			}
+		var upstream http.RoundTripper = transport
+		if outliers != nil {
+			upstream = &outlierTransport{next: transport, outliers: outliers, functionName: functionName, body: body}
+		}
 
-			clientHeader := w.Header()
-			copyHeaders(&clientHeader, &response.Header)
+		reverseProxy := &httputil.ReverseProxy{
+			// event streams and responses without a content length are flushed on each write
+			FlushInterval: flushInterval,
+			Transport: &retryTransport{
+				next:     upstream,
+				attempts: policy.retryAttempts,
+				backoff:  policy.retryBackoff,
+			},
+			Director: func(req *http.Request) {
+				req.URL.Scheme = functionURL.Scheme
+				req.URL.Host = functionHost(functionURL)
+				req.URL.Path = "/" + vars["params"]
+				req.URL.RawPath = ""
+				req.Host = req.URL.Host
 
-			writeHead(service, http.StatusOK, w)
-			io.Copy(w, response.Body)
+				if len(r.Host) > 0 && len(req.Header.Get("X-Forwarded-Host")) == 0 {
+					req.Header.Set("X-Forwarded-Host", r.Host)
+				}
+				req.Header.Set("X-Call-Id", callID)
+				req.Header.Set("X-Start-Time", startTime)
+			},
+			ModifyResponse: func(res *http.Response) error {
+				// the content type of the request is used when the function doesn't set one
+				if len(res.Header.Get("Content-Type")) == 0 && len(r.Header.Get("Content-Type")) > 0 {
+					res.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+				}
+				res.Header.Set("X-Call-Id", callID)
+				res.Header.Set("X-Start-Time", startTime)
+				res.Header.Set("X-Duration-Seconds", durationSeconds(start))
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				if body != nil && body.Exceeded() {
+					glog.Errorf("Function %s request body exceeds the limit of %d bytes", functionName, policy.maxBodySize)
+					writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the limit of %d bytes for: %s.",
+						policy.maxBodySize, functionName))
+					return
+				}
+
+				if r.Context().Err() != nil {
+					glog.V(2).Infof("Function %s request cancelled by the client: %v", functionName, err)
+					return
+				}
+
+				status := proxyErrorStatus(err)
+				glog.Errorf("Function %s proxy error with status %d: %v", functionName, status, err)
+
+				message := fmt.Sprintf("Can't reach service for: %s.", functionName)
+				if status == http.StatusGatewayTimeout {
+					message = fmt.Sprintf("Timed out after %s waiting for: %s.", timeout, functionName)
+				}
+				writeError(w, status, message)
+			},
 		}
+
+		upstreamReq := r.WithContext(ctx)
+		if body != nil {
+			upstreamReq.Body = body
+		}
+		reverseProxy.ServeHTTP(w, upstreamReq)
+
+		glog.V(2).Infof("%s took %f seconds", functionName, time.Since(start).Seconds())
 	}
 }
 
-func writeHead(service string, code int, w http.ResponseWriter) {
-	w.WriteHeader(code)
+// makeFunctionName removes the namespace suffix from the function name of the route, for
+// example nodeinfo.openfaas-fn, so that the annotations, limits and metrics of the function
+// apply whichever name it is invoked with. The vars of the route are shared by the handlers
+// of the request so the name is replaced in place.
+func makeFunctionName(namespace string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if functionName, ok := vars["name"]; ok {
+			vars["name"] = strings.TrimSuffix(functionName, "."+namespace)
+		}
+		next(w, r)
+	}
 }
 
-func copyHeaders(destination *http.Header, source *http.Header) {
-	for k, v := range *source {
-		vClone := make([]string, len(v))
-		copy(vClone, v)
-		(*destination)[k] = vClone
+// functionHost returns the host and port of a function endpoint, the port
+// of the watchdog is used when the URL has none
+func functionHost(functionURL url.URL) string {
+	if len(functionURL.Port()) == 0 {
+		return net.JoinHostPort(functionURL.Hostname(), watchdogPort)
 	}
+	return functionURL.Host
+}
+
+// newProxyTransport returns a transport which keeps the connections to the
+// functions alive and fails to dial after the timeout
+func newProxyTransport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 1 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       120 * time.Millisecond,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1500 * time.Millisecond,
+	}
+}
+
+// isStream returns true for requests which keep the connection open to stream
+// Server-Sent Events or to upgrade the protocol, for example to WebSocket
+func isStream(r *http.Request) bool {
+	if isUpgrade(r) {
+		return true
+	}
+
+	for _, accept := range r.Header["Accept"] {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWriter sets the deadline of an upgraded connection when the proxy hijacks it,
+// it keeps the http.Flusher of the underlying writer
+type upgradeWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+}
+
+func (w *upgradeWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the hijacked connection outlives the context of the request, the deadline bounds it by the stream timeout
+	conn.SetDeadline(time.Now().Add(w.timeout))
+	return conn, rw, nil
+}
+
+func isUpgrade(r *http.Request) bool {
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return len(r.Header.Get("Upgrade")) > 0
+			}
+		}
+	}
+	return false
+}
+
+// proxyErrorStatus maps the error of a round-trip to a function to a status code
+func proxyErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusBadGateway
+}
+
+func durationSeconds(start time.Time) string {
+	return fmt.Sprintf("%f", time.Since(start).Seconds())
 }
@@ -0,0 +1,595 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testResolver resolves every function to the URL of a stub function
+type testResolver struct {
+	url *url.URL
+	err error
+}
+
+func (r testResolver) Resolve(functionName string) (url.URL, func(), error) {
+	if r.err != nil {
+		return url.URL{}, nil, r.err
+	}
+	return *r.url, func() {}, nil
+}
+
+// testPolicy applies the same proxy policy to every function
+type testPolicy proxyPolicy
+
+func (p testPolicy) Policy(functionName string) proxyPolicy {
+	return proxyPolicy(p)
+}
+
+func newProxyRouter(resolver testResolver, policy proxyPolicy) *mux.Router {
+	handler := makeProxy(resolver, testPolicy(policy), nil, time.Second)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", handler)
+	r.HandleFunc("/function/{name}/", handler)
+	r.HandleFunc("/function/{name}/{params:.*}", handler)
+	return r
+}
+
+// newStubFunction starts a stub function and returns a router proxying to it
+func newStubFunction(t *testing.T, timeout time.Duration, function http.HandlerFunc) (*mux.Router, func()) {
+	return newStubFunctionWithPolicy(t, proxyPolicy{timeout: timeout, retryAttempts: 1}, function)
+}
+
+func newStubFunctionWithPolicy(t *testing.T, policy proxyPolicy, function http.HandlerFunc) (*mux.Router, func()) {
+	stub := httptest.NewServer(function)
+	stubURL, err := url.Parse(stub.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newProxyRouter(testResolver{url: stubURL}, policy), stub.Close
+}
+
+func Test_makeProxy_PreservesStatusCode(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusFound, http.StatusNotFound, http.StatusInternalServerError} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+				if status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(status)
+			})
+			defer stop()
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+			if w.Code != status {
+				t.Errorf("expected status %d, got %d", status, w.Code)
+			}
+		})
+	}
+}
+
+func Test_makeProxy_SupportsAllMethods(t *testing.T) {
+	methods := []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+		http.MethodOptions,
+	}
+
+	for _, method := range methods {
+		t.Run(method, func(t *testing.T) {
+			router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Method", r.Method)
+			})
+			defer stop()
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, "/function/nodeinfo", nil))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("X-Method"); got != method {
+				t.Errorf("expected the function to receive %s, got %s", method, got)
+			}
+		})
+	}
+}
+
+func Test_makeProxy_ForwardsPathQueryBodyAndHeaders(t *testing.T) {
+	router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s?%s %s %s %s",
+			r.Method, r.URL.Path, r.URL.RawQuery, string(body), r.Header.Get("X-Custom"), r.Header.Get("X-Forwarded-Host"))
+	})
+	defer stop()
+
+	req := httptest.NewRequest(http.MethodPost, "http://gateway:8080/function/nodeinfo/users/1?verbose=true", strings.NewReader("payload"))
+	req.Header.Set("X-Custom", "value")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	expected := "POST /users/1?verbose=true payload value gateway:8080"
+	if got := w.Body.String(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func Test_makeProxy_CopiesResponseHeadersAndTrailers(t *testing.T) {
+	router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Function", "nodeinfo")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "ok"}`))
+		w.Header().Set("X-Checksum", "abc123")
+	})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	res := w.Result()
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("expected status 202, got %d", res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected content type application/json, got %s", got)
+	}
+	if got := res.Header.Get("X-Function"); got != "nodeinfo" {
+		t.Errorf("expected X-Function header, got %q", got)
+	}
+	if body := w.Body.String(); body != `{"status": "ok"}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if got := res.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("expected X-Checksum trailer abc123, got %q", got)
+	}
+}
+
+func Test_makeProxy_UsesRequestContentTypeAsDefault(t *testing.T) {
+	router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.WriteHeader(http.StatusOK)
+	})
+	defer stop()
+
+	req := httptest.NewRequest(http.MethodPost, "/function/nodeinfo", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected content type application/json, got %q", got)
+	}
+}
+
+func Test_makeProxy_SetsCallHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	})
+	defer stop()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	callID := w.Header().Get("X-Call-Id")
+	if len(callID) == 0 {
+		t.Fatalf("expected X-Call-Id to be generated")
+	}
+
+	header := <-received
+	if got := header.Get("X-Call-Id"); got != callID {
+		t.Errorf("expected the function to receive X-Call-Id %s, got %q", callID, got)
+	}
+
+	startTime := w.Header().Get("X-Start-Time")
+	if _, err := strconv.ParseInt(startTime, 10, 64); err != nil {
+		t.Errorf("expected X-Start-Time in nanoseconds, got %q", startTime)
+	}
+	if got := header.Get("X-Start-Time"); got != startTime {
+		t.Errorf("expected the function to receive X-Start-Time %s, got %q", startTime, got)
+	}
+
+	if _, err := strconv.ParseFloat(w.Header().Get("X-Duration-Seconds"), 64); err != nil {
+		t.Errorf("expected X-Duration-Seconds, got %q", w.Header().Get("X-Duration-Seconds"))
+	}
+	if values := w.Header()["X-Call-Id"]; len(values) != 1 {
+		t.Errorf("expected a single X-Call-Id, got %v", values)
+	}
+}
+
+func Test_makeProxy_KeepsCallIDOfRequest(t *testing.T) {
+	router, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Received-Call-Id", r.Header.Get("X-Call-Id"))
+	})
+	defer stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil)
+	req.Header.Set("X-Call-Id", "call-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-Call-Id"); got != "call-1" {
+		t.Errorf("expected X-Call-Id call-1, got %q", got)
+	}
+	if got := w.Header().Get("X-Received-Call-Id"); got != "call-1" {
+		t.Errorf("expected the function to receive X-Call-Id call-1, got %q", got)
+	}
+}
+
+func Test_makeProxy_TimeoutReturnsGatewayTimeout(t *testing.T) {
+	release := make(chan struct{})
+	router, stop := newStubFunction(t, time.Millisecond*50, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer stop()
+	defer close(release)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504, got %d", w.Code)
+	}
+	if len(w.Header().Get("X-Call-Id")) == 0 || len(w.Header().Get("X-Duration-Seconds")) == 0 {
+		t.Errorf("expected call headers on the error response, got %v", w.Header())
+	}
+}
+
+func Test_makeProxy_UnreachableReturnsBadGateway(t *testing.T) {
+	// reserve a port and close it so that connections are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	router := newProxyRouter(testResolver{url: &url.URL{Scheme: "http", Host: addr}}, proxyPolicy{timeout: time.Second, retryAttempts: 1})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", w.Code)
+	}
+}
+
+func Test_makeProxy_ResolveErrorReturnsServiceUnavailable(t *testing.T) {
+	router := newProxyRouter(testResolver{err: fmt.Errorf("no endpoints available for: nodeinfo")}, proxyPolicy{timeout: time.Second, retryAttempts: 1})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "nodeinfo") {
+		t.Errorf("expected the function name in the error, got %q", w.Body.String())
+	}
+}
+
+// newProxyServer serves the proxy of a stub function over HTTP, which is
+// required to hijack connections and to observe flushes from a client
+func newProxyServer(t *testing.T, policy proxyPolicy, function http.HandlerFunc) (*httptest.Server, func()) {
+	router, stop := newStubFunctionWithPolicy(t, policy, function)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", makeInvocationMetrics(router.ServeHTTP))
+	server := httptest.NewServer(r)
+	return server, func() {
+		server.Close()
+		stop()
+	}
+}
+
+func Test_makeProxy_FlushesEventStream(t *testing.T) {
+	release := make(chan struct{})
+	server, stop := newProxyServer(t, proxyPolicy{timeout: time.Second, retryAttempts: 1, streamTimeout: time.Second * 5},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("data: 2\n\n"))
+		})
+	defer stop()
+	defer close(release)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/function/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(res.Body).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "data: 1\n" {
+			t.Errorf("expected the first event, got %q", line)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("expected the first event to be flushed before the stream completes")
+	}
+}
+
+func Test_makeProxy_StreamTimeout(t *testing.T) {
+	server, stop := newProxyServer(t, proxyPolicy{timeout: time.Millisecond * 20, retryAttempts: 1, streamTimeout: time.Millisecond * 300},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; ; i++ {
+				fmt.Fprintf(w, "data: %d\n\n", i)
+				w.(http.Flusher).Flush()
+
+				select {
+				case <-time.After(time.Millisecond * 50):
+				case <-r.Context().Done():
+					return
+				}
+			}
+		})
+	defer stop()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/function/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	start := time.Now()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	events := 0
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data:") {
+			events++
+		}
+	}
+	elapsed := time.Since(start)
+
+	if events < 3 {
+		t.Errorf("expected the stream to outlive the function timeout, got %d events", events)
+	}
+	if elapsed > time.Second*2 {
+		t.Errorf("expected the stream to end after the stream timeout, took %s", elapsed)
+	}
+}
+
+// upgradeEcho upgrades the connection and echoes each line until the client closes it
+func upgradeEcho(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "websocket" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	rw.Flush()
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		rw.WriteString(line)
+		rw.Flush()
+	}
+}
+
+func Test_makeProxy_UpgradesWebSocket(t *testing.T) {
+	server, stop := newProxyServer(t, proxyPolicy{timeout: time.Second, retryAttempts: 1, streamTimeout: time.Second * 5},
+		upgradeEcho)
+	defer stop()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	fmt.Fprintf(conn, "GET /function/echo HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", server.Listener.Addr().String())
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", res.StatusCode)
+	}
+
+	for _, message := range []string{"hello\n", "world\n"} {
+		conn.Write([]byte(message))
+		echo, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if echo != message {
+			t.Errorf("expected echo %q, got %q", message, echo)
+		}
+	}
+}
+
+func Test_makeProxy_UpgradedConnectionOutlivesServerTimeouts(t *testing.T) {
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Second, retryAttempts: 1, streamTimeout: time.Second * 5}, upgradeEcho)
+	defer stop()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", makeInvocationMetrics(router.ServeHTTP))
+	server := httptest.NewUnstartedServer(r)
+	server.Config.ReadTimeout = time.Millisecond * 50
+	server.Config.WriteTimeout = time.Millisecond * 50
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	fmt.Fprintf(conn, "GET /function/echo HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", server.Listener.Addr().String())
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status 101, got %d", res.StatusCode)
+	}
+
+	// the deadlines of the server have passed once the connection is upgraded
+	time.Sleep(time.Millisecond * 150)
+
+	conn.Write([]byte("hello\n"))
+	echo, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected the upgraded connection to outlive the timeouts of the server: %v", err)
+	}
+	if echo != "hello\n" {
+		t.Errorf("expected echo %q, got %q", "hello\n", echo)
+	}
+}
+
+// countingResolver records the requests in progress reported through done
+type countingResolver struct {
+	url      *url.URL
+	inflight int32
+}
+
+func (r *countingResolver) Resolve(functionName string) (url.URL, func(), error) {
+	atomic.AddInt32(&r.inflight, 1)
+	return *r.url, func() { atomic.AddInt32(&r.inflight, -1) }, nil
+}
+
+func Test_makeProxy_ReleasesEndpointWhenDone(t *testing.T) {
+	inflight := make(chan int32, 1)
+	resolver := &countingResolver{}
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight <- atomic.LoadInt32(&resolver.inflight)
+	}))
+	defer stub.Close()
+	resolver.url, _ = url.Parse(stub.URL)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", makeProxy(resolver, testPolicy{timeout: time.Second, retryAttempts: 1}, nil, time.Second))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil))
+
+	if got := <-inflight; got != 1 {
+		t.Errorf("expected the endpoint to be in use during the request, got %d", got)
+	}
+	if got := atomic.LoadInt32(&resolver.inflight); got != 0 {
+		t.Errorf("expected the endpoint to be released after the request, got %d", got)
+	}
+}
+
+func Test_makeFunctionName_RemovesNamespaceSuffix(t *testing.T) {
+	names := []string{}
+	handler := makeFunctionName("openfaas-fn", func(w http.ResponseWriter, r *http.Request) {
+		names = append(names, mux.Vars(r)["name"])
+	})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		// the handlers of the route see the same name
+		names = append(names, mux.Vars(r)["name"])
+	})
+
+	for _, path := range []string{"/function/nodeinfo", "/function/nodeinfo.openfaas-fn", "/function/nodeinfo.other"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	expected := []string{"nodeinfo", "nodeinfo", "nodeinfo", "nodeinfo", "nodeinfo.other", "nodeinfo.other"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected names %v, got %v", expected, names)
+	}
+}
+
+// deadlineConn records the deadline set on a hijacked connection
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn *deadlineConn
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.conn, nil, nil
+}
+
+func Test_upgradeWriter_SetsDeadlineOnHijack(t *testing.T) {
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: &deadlineConn{}}
+	w := &upgradeWriter{ResponseWriter: recorder, timeout: time.Minute}
+
+	start := time.Now()
+	conn, _, err := w.Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn != recorder.conn {
+		t.Errorf("expected the connection of the underlying writer")
+	}
+	if deadline := recorder.conn.deadline; deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("expected the deadline to be the stream timeout, got %s", deadline.Sub(start))
+	}
+
+	if _, _, err := (&upgradeWriter{ResponseWriter: httptest.NewRecorder()}).Hijack(); err == nil {
+		t.Errorf("expected an error when the underlying writer can't be hijacked")
+	}
+}
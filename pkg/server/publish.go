@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/connector"
+	glog "k8s.io/klog"
+)
+
+// makePublishHandler publishes the request body to the topic, the functions with
+// the topic in their topic annotation are invoked by the connector dispatcher.
+// Bodies larger than maxAsyncBodySize get 413.
+func makePublishHandler(publisher connector.Publisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		topic := mux.Vars(r)["topic"]
+
+		body, ok := readBody(w, r, maxAsyncBodySize, topic)
+		if !ok {
+			return
+		}
+
+		header := r.Header.Clone()
+		header.Del("Authorization")
+
+		if err := publisher.Publish(&connector.Message{Topic: topic, Body: body, Header: header}); err != nil {
+			glog.Errorf("Topic %s publish error: %v", topic, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/connector"
+)
+
+func Test_makePublishHandler(t *testing.T) {
+	broker := connector.NewMemoryBroker(1, time.Millisecond)
+
+	received := make(chan *connector.Message, 1)
+	sub, err := broker.Subscribe("orders.created", func(msg *connector.Message) error {
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/system/topics/{topic}", makePublishHandler(broker))
+
+	req := httptest.NewRequest(http.MethodPost, "/system/topics/orders.created", bytes.NewBufferString("order-1"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", w.Code)
+	}
+
+	select {
+	case msg := <-received:
+		if string(msg.Body) != "order-1" || msg.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected message: %+v", msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("expected the message to be published")
+	}
+}
+
+func Test_makePublishHandler_BodyTooLarge(t *testing.T) {
+	broker := connector.NewMemoryBroker(1, time.Millisecond)
+	router := mux.NewRouter()
+	router.HandleFunc("/system/topics/{topic}", makePublishHandler(broker))
+
+	req := httptest.NewRequest(http.MethodPost, "/system/topics/orders.created", strings.NewReader(strings.Repeat("a", maxAsyncBodySize+1)))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", w.Code)
+	}
+}
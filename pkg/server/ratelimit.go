@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/ratelimit"
+)
+
+// makeRateLimiter rejects the requests over the rate limit of a function with 429 and
+// a Retry-After header, it runs before the invocation tracker so that rejected requests
+// neither scale functions from zero nor count as load for the autoscaler
+func makeRateLimiter(limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := mux.Vars(r)["name"]
+		if len(functionName) == 0 {
+			next(w, r)
+			return
+		}
+
+		if ok, retryAfter := limiter.Allow(functionName, r); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(fmt.Sprintf("Rate limit exceeded for: %s.", functionName)))
+			return
+		}
+
+		next(w, r)
+	}
+}
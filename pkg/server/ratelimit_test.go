@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasfake "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions"
+	"github.com/openfaas/openfaas-operator/pkg/ratelimit"
+
+	"github.com/gorilla/mux"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_makeRateLimiter(t *testing.T) {
+	faas := faasfake.NewSimpleClientset()
+	faasInformerFactory := informers.NewSharedInformerFactory(faas, 0)
+	functionsInformer := faasInformerFactory.Openfaas().V1().Functions()
+	limiter := ratelimit.NewLimiter(functionsInformer, nil, clock.RealClock{})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	faasInformerFactory.Start(stopCh)
+	cache.WaitForCacheSync(stopCh, functionsInformer.Informer().HasSynced)
+
+	function := newPolicyFunction("limited", map[string]string{ratelimit.AnnotationRateLimit: "1/m"})
+	if _, err := faas.OpenfaasV1().Functions("openfaas-fn").Create(function); err != nil {
+		t.Fatal(err)
+	}
+
+	invoked := 0
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", makeRateLimiter(limiter, func(w http.ResponseWriter, r *http.Request) {
+		invoked++
+	}))
+
+	invoke := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/function/limited", nil))
+		return w
+	}
+
+	// the requests are allowed until the informer has delivered the function
+	var w *httptest.ResponseRecorder
+	deadline := time.Now().Add(time.Second * 5)
+	for w = invoke(); w.Code == http.StatusOK && time.Now().Before(deadline); w = invoke() {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got == "" || got == "0" {
+		t.Errorf("expected a Retry-After header in seconds, got %q", got)
+	}
+	if invoked == 0 {
+		t.Errorf("expected the requests under the rate limit to be forwarded")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+	glog "k8s.io/klog"
+)
+
+// makeScaleFromZero holds requests for functions without ready endpoints until the
+// function has been scaled from zero, the request is then forwarded to the next handler
+func makeScaleFromZero(scaler *scaling.Scaler, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		if len(functionName) > 0 {
+			if err := scaler.Ready(r.Context(), functionName); err != nil {
+				if r.Context().Err() != nil {
+					glog.V(2).Infof("Function %s request cancelled by the client during the scale from zero", functionName)
+					return
+				}
+
+				glog.Errorf("Function %s scale from zero error: %v", functionName, err)
+
+				status := http.StatusInternalServerError
+				if err == scaling.ErrScaleTimeout {
+					status = http.StatusGatewayTimeout
+				}
+
+				w.WriteHeader(status)
+				w.Write([]byte(fmt.Sprintf("Function %s is not ready: %s", functionName, err.Error())))
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
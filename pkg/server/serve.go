@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	bootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/certs"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	glog "k8s.io/klog"
+)
+
+// defaultHealthPort serves the plaintext health and metrics endpoints when TLS is on
+const defaultHealthPort = 8082
+
+// serving is how the provider API and the health endpoints are served
+type serving struct {
+	// certificates of the API, the API is served over plain HTTP when nil
+	certificates *certs.Reloader
+	// healthPort serves /healthz and /metrics over plain HTTP when set
+	healthPort int
+}
+
+// newServing reads the TLS files from the tls_cert_file, tls_key_file and tls_client_ca_file
+// environment variables, the client certificates are required when the client CA is set.
+// The health endpoints are served on health_port, by default on 8082 when TLS is on so that
+// the probes and Prometheus don't need certificates.
+func newServing() (*serving, error) {
+	s := &serving{}
+
+	certFile := os.Getenv("tls_cert_file")
+	keyFile := os.Getenv("tls_key_file")
+	clientCAFile := os.Getenv("tls_client_ca_file")
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			return nil, fmt.Errorf("tls_cert_file and tls_key_file must both be set")
+		}
+		certificates, err := certs.NewReloader(certFile, keyFile, clientCAFile, clock.RealClock{})
+		if err != nil {
+			return nil, err
+		}
+		s.certificates = certificates
+		s.healthPort = defaultHealthPort
+	} else if len(clientCAFile) > 0 {
+		return nil, fmt.Errorf("tls_client_ca_file requires tls_cert_file and tls_key_file")
+	}
+
+	if val := os.Getenv("health_port"); len(val) > 0 {
+		port, err := strconv.Atoi(val)
+		if err != nil || port < 0 {
+			return nil, fmt.Errorf("invalid health_port: %s", val)
+		}
+		s.healthPort = port
+	}
+	return s, nil
+}
+
+// registerRoutes adds the routes of the provider API to the router. bootstrap.Serve registers
+// them on the same call which starts its plain HTTP server, so they are added here for the
+// servers of the operator and Test_registerRoutes_MatchesBootstrap keeps them in step.
+func registerRoutes(r *mux.Router, handlers *types.FaaSHandlers) {
+	r.HandleFunc("/system/functions", handlers.FunctionReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/functions", handlers.DeployHandler).Methods(http.MethodPost)
+	r.HandleFunc("/system/functions", handlers.DeleteHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/system/functions", handlers.UpdateHandler).Methods(http.MethodPut)
+
+	r.HandleFunc("/system/function/{name:["+bootstrap.NameExpression+"]+}", handlers.ReplicaReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/scale-function/{name:["+bootstrap.NameExpression+"]+}", handlers.ReplicaUpdater).Methods(http.MethodPost)
+	r.HandleFunc("/system/info", handlers.InfoHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/system/secrets", handlers.SecretHandler).Methods(http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+	r.HandleFunc("/system/logs", handlers.LogHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/system/namespaces", handlers.ListNamespaceHandler).Methods(http.MethodGet)
+
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/", handlers.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/{params:.*}", handlers.FunctionProxy)
+
+	if handlers.HealthHandler != nil {
+		r.HandleFunc("/healthz", handlers.HealthHandler).Methods(http.MethodGet)
+	}
+}
+
+// makeWriteTimeout responds with 503 when the handler takes longer than the timeout, it bounds
+// the routes of the API server which don't stream their response as the server has no write timeout
+func makeWriteTimeout(timeout time.Duration, next http.Handler) http.HandlerFunc {
+	return http.TimeoutHandler(next, timeout, "Request timed out").ServeHTTP
+}
+
+// boundHandlers bounds the handlers of the provider API by the write timeout, the function
+// proxy and the logs stream their responses with their own timeouts
+func boundHandlers(handlers *types.FaaSHandlers, timeout time.Duration) {
+	handlers.FunctionReader = makeWriteTimeout(timeout, handlers.FunctionReader)
+	handlers.DeployHandler = makeWriteTimeout(timeout, handlers.DeployHandler)
+	handlers.DeleteHandler = makeWriteTimeout(timeout, handlers.DeleteHandler)
+	handlers.UpdateHandler = makeWriteTimeout(timeout, handlers.UpdateHandler)
+	handlers.ReplicaReader = makeWriteTimeout(timeout, handlers.ReplicaReader)
+	handlers.ReplicaUpdater = makeWriteTimeout(timeout, handlers.ReplicaUpdater)
+	handlers.InfoHandler = makeWriteTimeout(timeout, handlers.InfoHandler)
+	handlers.SecretHandler = makeWriteTimeout(timeout, handlers.SecretHandler)
+	handlers.ListNamespaceHandler = makeWriteTimeout(timeout, handlers.ListNamespaceHandler)
+	handlers.HealthHandler = makeWriteTimeout(timeout, handlers.HealthHandler)
+}
+
+// newHealthHandler serves the health and metrics endpoints without the provider API
+func newHealthHandler(health http.HandlerFunc) http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", health).Methods(http.MethodGet)
+	r.Path("/metrics").Handler(promhttp.Handler())
+	return r
+}
+
+// apiServer returns the server of the provider API and the proxy. The server has no write
+// timeout, nor a read timeout which would cancel the requests once it passes, so that the
+// invocations are bounded by the timeout of each function and the streams by their stream
+// timeout. The other routes are bounded by the write timeout with makeWriteTimeout.
+func (s *Server) apiServer() *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *s.BootstrapConfig.TCPPort),
+		ReadHeaderTimeout: s.BootstrapConfig.ReadTimeout,
+		IdleTimeout:       s.BootstrapConfig.ReadTimeout,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+		Handler:           bootstrap.Router(),
+	}
+	if s.serving.certificates != nil {
+		server.TLSConfig = s.serving.certificates.TLSConfig()
+	}
+	return server
+}
+
+// healthServer returns the plaintext server of the health endpoints, nil when it is off
+func (s *Server) healthServer() *http.Server {
+	if s.serving.healthPort == 0 {
+		return nil
+	}
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%d", s.serving.healthPort),
+		ReadTimeout:    s.BootstrapConfig.ReadTimeout,
+		WriteTimeout:   s.BootstrapConfig.WriteTimeout,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		Handler:        newHealthHandler(s.BootstrapHandlers.HealthHandler),
+	}
+}
+
+// Start begins the server, it returns once the server is shut down
+func (s *Server) Start() {
+	if s.health != nil {
+		glog.Infof("Starting health server on port %d", s.serving.healthPort)
+		go func() {
+			if err := s.health.ListenAndServe(); err != http.ErrServerClosed {
+				glog.Fatal(err)
+			}
+		}()
+	}
+
+	var err error
+	if s.api.TLSConfig != nil {
+		glog.Infof("Starting HTTPS server on port %d", *s.BootstrapConfig.TCPPort)
+		err = s.api.ListenAndServeTLS("", "")
+	} else {
+		glog.Infof("Starting HTTP server on port %d", *s.BootstrapConfig.TCPPort)
+		err = s.api.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		glog.Fatal(err)
+	}
+}
+
+// Shutdown fails the readiness and waits for delay so that the Services stop routing to the
+// operator, then it stops accepting connections and waits for the inflight requests until ctx
+// is done. The connections which are still open are closed when the requests can't be drained.
+func (s *Server) Shutdown(ctx context.Context, delay time.Duration) error {
+	s.readiness.fail()
+	glog.Infof("Failing readiness, the server stops in %s", delay)
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+
+	glog.Info("Draining the inflight requests")
+	err := s.api.Shutdown(ctx)
+	if err != nil {
+		s.api.Close()
+	}
+	if s.health != nil {
+		s.health.Close()
+	}
+	return err
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	bootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_registerRoutes(t *testing.T) {
+	cases := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodGet, path: "/system/functions", expected: "FunctionReader"},
+		{method: http.MethodPost, path: "/system/functions", expected: "DeployHandler"},
+		{method: http.MethodPut, path: "/system/functions", expected: "UpdateHandler"},
+		{method: http.MethodDelete, path: "/system/functions", expected: "DeleteHandler"},
+		{method: http.MethodGet, path: "/system/function/nodeinfo", expected: "ReplicaReader"},
+		{method: http.MethodPost, path: "/system/scale-function/nodeinfo", expected: "ReplicaUpdater"},
+		{method: http.MethodGet, path: "/system/info", expected: "InfoHandler"},
+		{method: http.MethodPut, path: "/system/secrets", expected: "SecretHandler"},
+		{method: http.MethodGet, path: "/system/logs", expected: "LogHandler"},
+		{method: http.MethodGet, path: "/system/namespaces", expected: "ListNamespaceHandler"},
+		{method: http.MethodPost, path: "/function/nodeinfo", expected: "FunctionProxy"},
+		{method: http.MethodPost, path: "/function/nodeinfo/", expected: "FunctionProxy"},
+		{method: http.MethodGet, path: "/function/nodeinfo/api/v1", expected: "FunctionProxy"},
+		{method: http.MethodGet, path: "/healthz", expected: "HealthHandler"},
+	}
+
+	users := map[string]string{}
+	r := mux.NewRouter()
+	registerRoutes(r, newStubHandlers(users))
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			for name := range users {
+				delete(users, name)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+			if _, invoked := users[tc.expected]; !invoked || len(users) != 1 {
+				t.Errorf("expected %s to be invoked, got %v", tc.expected, users)
+			}
+		})
+	}
+}
+
+// routeTable lists the path template and the methods of each route of the router
+func routeTable(r *mux.Router) []string {
+	routes := []string{}
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		sort.Strings(methods)
+		routes = append(routes, template+" "+strings.Join(methods, ","))
+		return nil
+	})
+	sort.Strings(routes)
+	return routes
+}
+
+func Test_registerRoutes_MatchesBootstrap(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// bootstrap.Serve registers its routes before it starts serving, it can't be stopped
+	// so the server is left running until the end of the tests
+	go bootstrap.Serve(newStubHandlers(map[string]string{}), &types.FaaSConfig{TCPPort: &port})
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for bootstrap.Serve: %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	r := mux.NewRouter()
+	registerRoutes(r, newStubHandlers(map[string]string{}))
+
+	expected := routeTable(bootstrap.Router())
+	if got := routeTable(r); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the routes of bootstrap.Serve %v, got %v", expected, got)
+	}
+}
+
+func Test_newHealthHandler(t *testing.T) {
+	handler := newHealthHandler(makeHealthHandler(&readiness{}))
+
+	cases := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/healthz", expected: http.StatusOK},
+		{path: "/metrics", expected: http.StatusOK},
+		{path: "/system/functions", expected: http.StatusNotFound},
+		{path: "/function/nodeinfo", expected: http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.expected {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.expected, w.Code)
+		}
+	}
+}
+
+func Test_newServing(t *testing.T) {
+	defer setEnv("tls_cert_file", "")()
+	defer setEnv("tls_key_file", "")()
+	defer setEnv("tls_client_ca_file", "")()
+	defer setEnv("health_port", "")()
+
+	s, err := newServing()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.certificates != nil || s.healthPort != 0 {
+		t.Errorf("expected plain HTTP without a health port by default, got %+v", s)
+	}
+
+	defer setEnv("health_port", "9090")()
+	if s, err := newServing(); err != nil || s.healthPort != 9090 {
+		t.Errorf("expected the health port 9090, got %+v %v", s, err)
+	}
+
+	cases := []struct {
+		name         string
+		certFile     string
+		keyFile      string
+		clientCAFile string
+		healthPort   string
+	}{
+		{name: "certificate without a key", certFile: "tls.crt"},
+		{name: "client CA without a certificate", clientCAFile: "ca.crt"},
+		{name: "missing certificate", certFile: filepath.Join(os.TempDir(), "missing.crt"), keyFile: filepath.Join(os.TempDir(), "missing.key")},
+		{name: "invalid health port", healthPort: "http"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer setEnv("tls_cert_file", tc.certFile)()
+			defer setEnv("tls_key_file", tc.keyFile)()
+			defer setEnv("tls_client_ca_file", tc.clientCAFile)()
+			defer setEnv("health_port", tc.healthPort)()
+			if _, err := newServing(); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func Test_Server_Shutdown(t *testing.T) {
+	readiness := &readiness{}
+	health := makeHealthHandler(readiness)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health)
+	mux.HandleFunc("/function/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{readiness: readiness, api: &http.Server{Handler: mux}}
+	go s.api.Serve(listener)
+	url := "http://" + listener.Addr().String()
+
+	inflight := make(chan string)
+	go func() {
+		res, err := http.Get(url + "/function/slow")
+		if err != nil {
+			inflight <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		inflight <- string(body)
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(context.Background(), 100*time.Millisecond)
+	}()
+
+	// readiness fails while the server still accepts requests
+	time.Sleep(20 * time.Millisecond)
+	res, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("expected the server to accept requests during the delay: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected healthz to fail with 503 once shutting down, got %d", res.StatusCode)
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected the shutdown to wait for the inflight request, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	if body := <-inflight; body != "done" {
+		t.Errorf("expected the inflight request to complete, got %q", body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Errorf("expected the connections to be refused after the shutdown")
+	}
+}
+
+func Test_Server_Shutdown_GracePeriod(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{readiness: &readiness{}, api: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}}
+	go s.api.Serve(listener)
+
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("expected the grace period to expire, got %v", err)
+	}
+}
+
+// newTestAPIServer serves the handler with the timeouts of the API server
+func newTestAPIServer(handler http.Handler, readTimeout, writeTimeout time.Duration) *httptest.Server {
+	port := 0
+	s := &Server{
+		BootstrapConfig: &types.FaaSConfig{ReadTimeout: readTimeout, WriteTimeout: writeTimeout, TCPPort: &port},
+		serving:         &serving{},
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Config = s.apiServer()
+	server.Config.Handler = handler
+	server.Start()
+	return server
+}
+
+func Test_Server_apiServer_StreamsPastWriteTimeout(t *testing.T) {
+	router, stop := newStubFunctionWithPolicy(t, proxyPolicy{timeout: time.Millisecond * 50, retryAttempts: 1, streamTimeout: time.Second * 5},
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(w, "data: %d\n\n", i)
+				w.(http.Flusher).Flush()
+				time.Sleep(time.Millisecond * 50)
+			}
+		})
+	defer stop()
+
+	server := newTestAPIServer(router, time.Millisecond*100, time.Millisecond*100)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/function/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	events := 0
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data:") {
+			events++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Errorf("expected the stream to complete, got %v", err)
+	}
+	if events != 10 {
+		t.Errorf("expected the stream to outlive the read and write timeouts, got %d of 10 events", events)
+	}
+}
+
+func Test_makeWriteTimeout(t *testing.T) {
+	handler := makeWriteTimeout(time.Millisecond*50, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/system/functions", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 after the write timeout, got %d", w.Code)
+	}
+}
+
+func Test_Server_apiServer_FunctionTimeoutPastWriteTimeout(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Millisecond * 300):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}
+
+	cases := []struct {
+		name     string
+		timeout  time.Duration
+		expected int
+		body     string
+	}{
+		{name: "function timeout longer than the invocation", timeout: time.Second, expected: http.StatusOK, body: "done"},
+		{name: "function timeout shorter than the invocation", timeout: time.Millisecond * 200, expected: http.StatusGatewayTimeout},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, stop := newStubFunction(t, tc.timeout, slow)
+			defer stop()
+
+			server := newTestAPIServer(router, time.Millisecond*100, time.Millisecond*100)
+			defer server.Close()
+
+			res, err := http.Get(server.URL + "/function/slow")
+			if err != nil {
+				t.Fatalf("expected a response past the write timeout of the server: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+
+			if res.StatusCode != tc.expected {
+				t.Errorf("expected status %d, got %d", tc.expected, res.StatusCode)
+			}
+			if len(tc.body) > 0 && string(body) != tc.body {
+				t.Errorf("expected the response of the function %q, got %q", tc.body, body)
+			}
+		})
+	}
+}
+
+func Test_Server_apiServer_ColdStartPastWriteTimeout(t *testing.T) {
+	replicas := int32(0)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	kube := fake.NewSimpleClientset(deployment)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+	deploymentsInformer := kubeInformerFactory.Apps().V1().Deployments()
+	endpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
+	deploymentsInformer.Informer().GetIndexer().Add(deployment)
+
+	// the function has a ready endpoint after a cold start longer than the write timeout
+	kube.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		go func() {
+			time.Sleep(time.Millisecond * 300)
+			endpointsInformer.Informer().GetIndexer().Add(&corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+				Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+			})
+		}()
+		return false, nil, nil
+	})
+
+	scaler := scaling.NewScaler(kube, "openfaas-fn", deploymentsInformer.Lister(), endpointsInformer.Lister(), clock.RealClock{}, time.Second*5)
+	function, stop := newStubFunction(t, time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ready"))
+	})
+	defer stop()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/function/{name}", makeScaleFromZero(scaler, function.ServeHTTP))
+	server := newTestAPIServer(r, time.Millisecond*100, time.Millisecond*100)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/function/nodeinfo")
+	if err != nil {
+		t.Fatalf("expected a response after the cold start: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK || string(body) != "ready" {
+		t.Errorf("expected the response of the function after the cold start, got %d %q", res.StatusCode, body)
+	}
+}
@@ -7,19 +7,32 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/openfaas/faas-netes/k8s"
 	faasnetesk8s "github.com/openfaas/faas-netes/k8s"
 	bootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/openfaas-operator/pkg/balancer"
+	"github.com/openfaas/openfaas-operator/pkg/breaker"
 	clientset "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned"
+	faasscheme "github.com/openfaas/openfaas-operator/pkg/client/clientset/versioned/scheme"
+	faasinformers "github.com/openfaas/openfaas-operator/pkg/client/informers/externalversions/openfaas/v1"
+	"github.com/openfaas/openfaas-operator/pkg/connector"
+	"github.com/openfaas/openfaas-operator/pkg/controller"
+	"github.com/openfaas/openfaas-operator/pkg/queue"
+	"github.com/openfaas/openfaas-operator/pkg/ratelimit"
+	"github.com/openfaas/openfaas-operator/pkg/responsecache"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
 
 	"github.com/openfaas/faas-provider/logs"
-	"github.com/openfaas/faas-provider/proxy"
 	"github.com/openfaas/faas-provider/types"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	appsinformer "k8s.io/client-go/informers/apps/v1"
 	coreinformer "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	glog "k8s.io/klog"
 )
 
@@ -28,12 +41,22 @@ import (
 const defaultHTTPPort = 8081
 const defaultReadTimeout = 8
 const defaultWriteTimeout = 8
+const defaultScaleFromZeroTimeout = 30
+
+// eventComponent is the source of the events recorded by the function proxy
+const eventComponent = "openfaas-operator-proxy"
 
 // New creates HTTP server struct
 func New(client clientset.Interface,
 	kube kubernetes.Interface,
 	endpointsInformer coreinformer.EndpointsInformer,
-	deploymentsInformer appsinformer.DeploymentInformer) *Server {
+	deploymentsInformer appsinformer.DeploymentInformer,
+	functionsInformer faasinformers.FunctionInformer,
+	tracker *scaling.Tracker,
+	asyncQueue queue.Queue,
+	deadLetters *queue.DeadLetters,
+	publisher connector.Publisher,
+	factory controller.FunctionFactory) *Server {
 
 	functionNamespace := "openfaas-fn"
 	if namespace, exists := os.LookupEnv("function_namespace"); exists {
@@ -64,15 +87,46 @@ func New(client clientset.Interface,
 		}
 	}
 
+	scaleFromZeroTimeout := defaultScaleFromZeroTimeout
+	if val, exists := os.LookupEnv("scale_from_zero_timeout"); exists {
+		parsedVal, parseErr := strconv.Atoi(val)
+		if parseErr == nil && parsedVal > 0 {
+			scaleFromZeroTimeout = parsedVal
+		}
+	}
+
 	pprof := "false"
 	if val, exists := os.LookupEnv("pprof"); exists {
 		pprof = val
 	}
 
+	loadBalancer := balancer.StrategyLeastInflight
+	if val, exists := os.LookupEnv("load_balancer"); exists {
+		loadBalancer = val
+	}
+
+	trustedProxies, err := ratelimit.ParseTrustedProxies(os.Getenv("trusted_proxies"))
+	if err != nil {
+		glog.Warningf("%v, the client IP is taken from the connection", err)
+	}
+
 	lister := endpointsInformer.Lister()
-	functionLookup := k8s.NewFunctionLookup(functionNamespace, lister)
+	functionLookup, err := balancer.NewBalancer(functionNamespace, endpointsInformer, loadBalancer)
+	if err != nil {
+		glog.Warningf("%v, using %s", err, balancer.StrategyLeastInflight)
+		functionLookup, _ = balancer.NewBalancer(functionNamespace, endpointsInformer, balancer.StrategyLeastInflight)
+	}
 
 	deploymentLister := deploymentsInformer.Lister().Deployments(functionNamespace)
+
+	scaler := scaling.NewScaler(kube,
+		functionNamespace,
+		deploymentsInformer.Lister(),
+		lister,
+		clock.RealClock{},
+		time.Duration(scaleFromZeroTimeout)*time.Second,
+	)
+
 	bootstrapConfig := types.FaaSConfig{
 		ReadTimeout:  time.Duration(readTimeout) * time.Second,
 		WriteTimeout: time.Duration(writeTimeout) * time.Second,
@@ -80,43 +134,105 @@ func New(client clientset.Interface,
 		EnableHealth: true,
 	}
 
+	policies := newFunctionPolicies(functionsInformer.Lister().Functions(functionNamespace), bootstrapConfig.WriteTimeout)
+	limiter := newConcurrencyLimiter(functionLookup)
+	rateLimiter := ratelimit.NewLimiter(functionsInformer, trustedProxies, clock.RealClock{})
+	responses := responsecache.NewCache(functionsInformer, clock.RealClock{})
+	mirrors := newMirror(functionNamespace, functionLookup, policies, tracker, bootstrapConfig.WriteTimeout)
+
+	breakers := breaker.NewBreakers(functionNamespace, functionsInformer.Lister().Functions(functionNamespace), newEventRecorder(kube), clock.RealClock{})
+	functionLookup.SetEjector(breakers)
+
+	readiness := &readiness{}
+
 	bootstrapHandlers := types.FaaSHandlers{
-		FunctionProxy:        proxy.NewHandlerFunc(bootstrapConfig, functionLookup),
+		FunctionProxy:        makeFunctionName(functionNamespace, makeInvocationMetrics(makeRateLimiter(rateLimiter, makeResponseCache(responses, tracker, makeCircuitBreaker(breakers, makeInvocationTracker(tracker, makeScaleFromZero(scaler, makeConcurrencyLimiter(limiter, policies, makeMirror(mirrors, makeProxy(functionLookup, policies, breakers, bootstrapConfig.WriteTimeout)))))))))),
 		DeleteHandler:        makeDeleteHandler(functionNamespace, client),
 		DeployHandler:        makeApplyHandler(functionNamespace, client),
 		FunctionReader:       makeListHandler(functionNamespace, client, deploymentLister),
 		ReplicaReader:        makeReplicaReader(functionNamespace, client, deploymentLister),
 		ReplicaUpdater:       makeReplicaHandler(functionNamespace, kube),
 		UpdateHandler:        makeApplyHandler(functionNamespace, client),
-		HealthHandler:        makeHealthHandler(),
+		HealthHandler:        makeHealthHandler(readiness),
 		InfoHandler:          makeInfoHandler(),
 		SecretHandler:        makeSecretHandler(functionNamespace, kube),
 		ListNamespaceHandler: makeListNamespaceHandler(functionNamespace),
 		LogHandler:           logs.NewLogHandlerFunc(faasnetesk8s.NewLogRequestor(kube, functionNamespace), bootstrapConfig.WriteTimeout),
 	}
 
+	authn, err := newAuthentication(kube)
+	if err != nil {
+		glog.Fatalf("Error reading the API authentication: %s", err.Error())
+	}
+	if authn == nil {
+		glog.Warningf("The /system endpoints are not authenticated, set basic_auth or token_auth")
+	}
+	authz, err := newAuthorization(kube, functionNamespace)
+	if err != nil {
+		glog.Fatalf("Error reading the API authorization: %s", err.Error())
+	}
+	if authz != nil && authn == nil {
+		glog.Fatalf("Authorization requires basic_auth or token_auth")
+	}
+	// the requests are authenticated before they are authorized
+	authz.DecorateHandlers(&bootstrapHandlers)
+	authn.DecorateHandlers(&bootstrapHandlers)
+	boundHandlers(&bootstrapHandlers, bootstrapConfig.WriteTimeout)
+
 	if pprof == "true" {
 		bootstrap.Router().PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 	}
 
-	bootstrap.Router().Path("/metrics").Handler(promhttp.Handler())
+	asyncHandler := makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.DecorateFunction(authz.Decorate(VerbInvoke, makeAsyncHandler(asyncQueue, policies))))
+	bootstrap.Router().HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}", asyncHandler)
+	bootstrap.Router().HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}/", asyncHandler)
+	bootstrap.Router().HandleFunc("/async-function/{name:["+bootstrap.NameExpression+"]+}/{params:.*}", asyncHandler)
+	bootstrap.Router().HandleFunc("/system/dead-letters", makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.Decorate(authz.Decorate(VerbLogs, makeDeadLetterHandler(deadLetters))))).Methods(http.MethodGet)
+
+	bootstrap.Router().HandleFunc("/system/topics/{topic}", makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.Decorate(authz.Decorate(VerbInvoke, makePublishHandler(publisher))))).Methods(http.MethodPost)
+
+	jobPath := "/system/jobs/{name:[" + bootstrap.NameExpression + "]+}"
+	bootstrap.Router().HandleFunc(jobPath, makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.Decorate(authz.Decorate(VerbInvoke, makeJobCreateHandler(functionNamespace, client, kube, factory))))).Methods(http.MethodPost)
+	bootstrap.Router().HandleFunc(jobPath, makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.Decorate(authz.Decorate(VerbRead, makeJobListHandler(functionNamespace, kube))))).Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc(jobPath+"/{id}", makeWriteTimeout(bootstrapConfig.WriteTimeout, authn.Decorate(authz.Decorate(VerbRead, makeJobStatusHandler(functionNamespace, kube))))).Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc(jobPath+"/{id}/logs", authn.Decorate(authz.Decorate(VerbLogs, makeJobLogsHandler(functionNamespace, kube)))).Methods(http.MethodGet)
+
+	registerRoutes(bootstrap.Router(), &bootstrapHandlers)
+	bootstrap.Router().Path("/metrics").Handler(makeWriteTimeout(bootstrapConfig.WriteTimeout, promhttp.Handler()))
+
+	serving, err := newServing()
+	if err != nil {
+		glog.Fatalf("Error reading the TLS configuration: %s", err.Error())
+	}
 
 	glog.Infof("Using namespace '%s'", functionNamespace)
 
-	return &Server{
+	srv := &Server{
 		BootstrapConfig:   &bootstrapConfig,
 		BootstrapHandlers: &bootstrapHandlers,
+		serving:           serving,
+		readiness:         readiness,
 	}
+	srv.api = srv.apiServer()
+	srv.health = srv.healthServer()
+	return srv
+}
+
+// newEventRecorder returns a recorder for the events of the function proxy
+func newEventRecorder(kube kubernetes.Interface) record.EventRecorder {
+	faasscheme.AddToScheme(scheme.Scheme)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.V(4).Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
 }
 
 type Server struct {
 	BootstrapHandlers *types.FaaSHandlers
 	BootstrapConfig   *types.FaaSConfig
-}
-
-// Start begins the server
-func (s *Server) Start() {
-	glog.Infof("Starting HTTP server on port %d", *s.BootstrapConfig.TCPPort)
 
-	bootstrap.Serve(s.BootstrapHandlers, s.BootstrapConfig)
+	serving   *serving
+	readiness *readiness
+	api       *http.Server
+	health    *http.Server
 }
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/openfaas-operator/pkg/scaling"
+)
+
+// makeInvocationTracker records the invocations made through the function proxy
+// so that the idler and the autoscaler can measure the load of each function
+func makeInvocationTracker(tracker *scaling.Tracker, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+		if len(functionName) == 0 {
+			next(w, r)
+			return
+		}
+
+		tracker.Begin(functionName)
+		defer tracker.End(functionName)
+
+		next(w, r)
+	}
+}